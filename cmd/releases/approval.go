@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/seanturner026/moot/internal/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultRequestTTL is how long a release request waits for approval when RELEASE_REQUEST_TTL_HOURS
+// is not set
+const defaultRequestTTL = 24 * time.Hour
+
+var (
+	errReleaseRequestNotFound = errors.New("release request does not exist")
+	errReleaseRequestChanged  = errors.New("release request was changed by another request")
+)
+
+// releaseRequest is a release waiting for approval. Once RequiredApprovals users other than the
+// requester have approved it, the release workflow runs as the final approver. Status is pending,
+// approved, releasing, released or rejected. Requests stay approved while their release has failed,
+// and can be released again by one of their approvers.
+type releaseRequest struct {
+	PK                string       `dynamodbav:"PK"                         json:"-"`
+	SK                string       `dynamodbav:"SK"                         json:"-"`
+	RequestID         string       `dynamodbav:"RequestID"                  json:"request_id"`
+	Release           releaseEvent `dynamodbav:"Release"                    json:"release"`
+	Status            string       `dynamodbav:"Status"                     json:"status"`
+	RequestedBy       string       `dynamodbav:"RequestedBy"                json:"requested_by"`
+	RequestedAt       string       `dynamodbav:"RequestedAt"                json:"requested_at"`
+	RequiredApprovals int          `dynamodbav:"RequiredApprovals"          json:"required_approvals"`
+	Approvals         []string     `dynamodbav:"Approvals"                  json:"approvals"`
+	RejectedBy        string       `dynamodbav:"RejectedBy,omitempty"       json:"rejected_by,omitempty"`
+	Reason            string       `dynamodbav:"Reason,omitempty"           json:"reason,omitempty"`
+	Message           string       `dynamodbav:"Message,omitempty"          json:"message,omitempty"`
+	Revision          int          `dynamodbav:"Revision"                   json:"-"`
+	ReleaseStartedAt  int64        `dynamodbav:"ReleaseStartedAt,omitempty" json:"release_started_at,omitempty"`
+
+	// NOTE(SMT): DynamoDB deletes the request once ExpiresAt has passed, so it is cleared once the request
+	// is approved to keep it as a record of who approved the release
+	ExpiresAt int64 `dynamodbav:"ExpiresAt,omitempty" json:"expires_at,omitempty"`
+}
+
+// releaseDecision is the body of /releases/approve and /releases/reject
+type releaseDecision struct {
+	RequestID string `json:"request_id"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// expired returns true once the request can no longer be approved. DynamoDB deletes expired items
+// some time after ExpiresAt, so expiry is checked rather than relying on the item being gone.
+func (r releaseRequest) expired(now time.Time) bool {
+	return now.Unix() >= r.ExpiresAt
+}
+
+// stalled returns true when the release of the request started longer ago than lease, so the Lambda
+// which was running it has timed out
+func (r releaseRequest) stalled(now time.Time, lease time.Duration) bool {
+	return r.Status == "releasing" && now.Unix() >= r.ReleaseStartedAt+int64(lease/time.Second)
+}
+
+func (r releaseRequest) approvedBy(user string) bool {
+	for _, approver := range r.Approvals {
+		if approver == user {
+			return true
+		}
+	}
+	return false
+}
+
+// notify posts message to Slack when a webhook has been configured. Failures are only logged, as the
+// notification is not part of the change it describes.
+func (app application) notify(message string) {
+	if app.Config.SlackWebhookURL == "" {
+		return
+	}
+
+	err := util.PostToSlack(app.Config.SlackWebhookURL, message)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to send slack notification, %v", err))
+	}
+}
+
+func (app awsController) getReleaseRequest(id string) (releaseRequest, error) {
+	input := &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("release_request"),
+			},
+			"SK": {
+				S: aws.String(id),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String(app.TableName),
+	}
+
+	r := releaseRequest{}
+	resp, err := app.DB.GetItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return r, err
+	}
+
+	if len(resp.Item) == 0 {
+		return r, errReleaseRequestNotFound
+	}
+
+	err = dynamodbattribute.UnmarshalMap(resp.Item, &r)
+	return r, err
+}
+
+// putReleaseRequest writes r and increments its revision. The write fails with
+// errReleaseRequestChanged when another request has written r since it was read.
+func (app awsController) putReleaseRequest(r *releaseRequest) error {
+	revision := r.Revision
+	r.Revision++
+	item, err := dynamodbattribute.MarshalMap(r)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal release request, %v", err))
+		return err
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(app.TableName),
+	}
+	if revision == 0 {
+		input.ConditionExpression = aws.String("attribute_not_exists(PK)")
+	} else {
+		input.ConditionExpression = aws.String("Revision = :revision")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":revision": {
+				N: aws.String(strconv.Itoa(revision)),
+			},
+		}
+	}
+
+	_, err = app.DB.PutItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return errReleaseRequestChanged
+	} else if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return err
+	}
+	return nil
+}
+
+// readReleaseDecision reads the request which a decision is being made on, returning the message and
+// status code to respond with when no decision can be made
+func (app application) readReleaseDecision(event events.APIGatewayV2HTTPRequest) (releaseRequest, releaseDecision, string, int) {
+	d := releaseDecision{}
+	err := json.Unmarshal([]byte(event.Body), &d)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+	}
+
+	if d.RequestID == "" {
+		message := "Unable to find release request, request_id is required"
+		statusCode := 400
+		return releaseRequest{}, d, message, statusCode
+	}
+
+	r, err := app.AWS.getReleaseRequest(d.RequestID)
+	if err == errReleaseRequestNotFound {
+		message := fmt.Sprintf("Release request %v does not exist", d.RequestID)
+		statusCode := 404
+		return r, d, message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to read release request %v from backend", d.RequestID)
+		statusCode := 400
+		return r, d, message, statusCode
+	}
+
+	if r.Status == "pending" && r.expired(time.Now()) {
+		message := fmt.Sprintf("Release request %v for %v version %v has expired", r.RequestID, r.Release.RepoName, r.Release.ReleaseVersion)
+		statusCode := 410
+		return r, d, message, statusCode
+	}
+	return r, d, "", 200
+}
+
+func (app application) releasesRequestHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	e := releaseEvent{}
+	err := json.Unmarshal([]byte(event.Body), &e)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+	}
+
+	if _, ok := app.Providers[e.RepoProvider]; !ok {
+		message := fmt.Sprintf("Unable to request release of %s version %s, provider %s is not supported", e.RepoName, e.ReleaseVersion, e.RepoProvider)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	repo, err := app.AWS.getRepository(e)
+	if err == errRepositoryNotFound {
+		message := fmt.Sprintf("Unable to request release of %s version %s, repository has not been onboarded for %s", e.RepoName, e.ReleaseVersion, e.RepoProvider)
+		statusCode := 404
+		return message, statusCode
+	} else if err == errComponentNotFound {
+		message := fmt.Sprintf("Unable to request release of %s version %s, component %s has not been onboarded", e.RepoName, e.ReleaseVersion, e.Component)
+		statusCode := 404
+		return message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to request release of %s version %s, could not read repository from backend", e.RepoName, e.ReleaseVersion)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	// NOTE(SMT): the version is resolved when the release is requested, so that approvers sign off on the
+	// version which is released rather than a bump
+	version, err := resolveReleaseVersion(e, repo)
+	if err != nil {
+		message := fmt.Sprintf("Unable to request release of %s, %v", e.RepoName, err)
+		statusCode := 400
+		return message, statusCode
+	}
+	e.ReleaseVersion = version
+	e.Bump = ""
+
+	id := event.RequestContext.RequestID
+	if id == "" {
+		id = fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+
+	// NOTE(SMT): requests always need at least one approval, even when releases may be created directly
+	required := app.Config.RequiredApprovals
+	if required < 1 {
+		required = 1
+	}
+
+	now := time.Now()
+	r := releaseRequest{
+		PK:                "release_request",
+		SK:                id,
+		RequestID:         id,
+		Release:           e,
+		Status:            "pending",
+		RequestedBy:       requestActor(event),
+		RequestedAt:       now.UTC().Format(time.RFC3339),
+		RequiredApprovals: required,
+		Approvals:         []string{},
+		ExpiresAt:         now.Add(app.Config.RequestTTL).Unix(),
+	}
+	err = app.AWS.putReleaseRequest(&r)
+	if err != nil {
+		message := fmt.Sprintf("Unable to request release of %s version %s, could not write request to backend", e.RepoName, e.ReleaseVersion)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	app.notify(fmt.Sprintf("%v requested the release of %v version %v, which needs %d approvals. Approve or reject request %v.",
+		r.RequestedBy,
+		e.RepoName,
+		e.ReleaseVersion,
+		required,
+		r.RequestID,
+	))
+
+	body, err := json.Marshal(r)
+	statusCode := 200
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal json for response, %v", err))
+		statusCode = 400
+	}
+
+	var buf bytes.Buffer
+	json.HTMLEscape(&buf, body)
+	return buf.String(), statusCode
+}
+
+func (app application) releasesApproveHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	r, _, message, statusCode := app.readReleaseDecision(event)
+	if statusCode != 200 {
+		return message, statusCode
+	}
+
+	approver := requestActor(event)
+	if r.Status == "pending" {
+		if approver == "" || approver == r.RequestedBy {
+			message := fmt.Sprintf("Unable to approve release request %v, releases must be approved by someone other than the requester", r.RequestID)
+			statusCode := 403
+			return message, statusCode
+		}
+		if r.approvedBy(approver) {
+			message := fmt.Sprintf("Release request %v has already been approved by %v", r.RequestID, approver)
+			statusCode := 409
+			return message, statusCode
+		}
+
+		r.Approvals = append(r.Approvals, approver)
+		if len(r.Approvals) >= r.RequiredApprovals {
+			r.Status = "approved"
+			r.ExpiresAt = 0
+		}
+		err := app.AWS.putReleaseRequest(&r)
+		if err == errReleaseRequestChanged {
+			message := fmt.Sprintf("Release request %v was changed while approving it, please try again", r.RequestID)
+			statusCode := 409
+			return message, statusCode
+		} else if err != nil {
+			message := fmt.Sprintf("Unable to approve release request %v, could not write request to backend", r.RequestID)
+			statusCode := 400
+			return message, statusCode
+		}
+
+		app.notify(fmt.Sprintf("%v approved the release of %v version %v (%d of %d approvals).",
+			approver,
+			r.Release.RepoName,
+			r.Release.ReleaseVersion,
+			len(r.Approvals),
+			r.RequiredApprovals,
+		))
+
+		if r.Status == "pending" {
+			message := fmt.Sprintf("Approved release request %v, %d of %d approvals", r.RequestID, len(r.Approvals), r.RequiredApprovals)
+			statusCode := 200
+			return message, statusCode
+		}
+
+	} else if r.Status == "approved" || r.stalled(time.Now(), app.Config.LambdaTimeout+releaseLockMargin) {
+		if !r.approvedBy(approver) {
+			message := fmt.Sprintf("Unable to release request %v again, only its approvers may release it", r.RequestID)
+			statusCode := 403
+			return message, statusCode
+		}
+
+	} else if r.Status == "releasing" {
+		message := fmt.Sprintf("Release request %v is already being released", r.RequestID)
+		statusCode := 409
+		return message, statusCode
+
+	} else {
+		message := fmt.Sprintf("Release request %v has already been %v", r.RequestID, r.Status)
+		statusCode := 409
+		return message, statusCode
+	}
+
+	// NOTE(SMT): the request is marked as releasing before its release runs, so that a request which is
+	// approved or retried twice at once is only released once
+	r.Status = "releasing"
+	r.ReleaseStartedAt = time.Now().Unix()
+	err := app.AWS.putReleaseRequest(&r)
+	if err == errReleaseRequestChanged {
+		message := fmt.Sprintf("Release request %v is already being released", r.RequestID)
+		statusCode := 409
+		return message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to release request %v, could not write request to backend", r.RequestID)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	// NOTE(SMT): releasing a request whose release failed resumes it through the idempotency key of the
+	// request
+	e := r.Release
+	e.IdempotencyKey = fmt.Sprintf("release_request#%s", r.RequestID)
+	e.ApprovalRequestID = r.RequestID
+	message, statusCode = app.release(event, e)
+
+	r.Message = message
+	r.Status = "approved"
+	if statusCode == 200 {
+		r.Status = "released"
+	}
+	err = app.AWS.putReleaseRequest(&r)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to update release request %v, %v", r.RequestID, err))
+	}
+	return message, statusCode
+}
+
+func (app application) releasesRejectHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	r, d, message, statusCode := app.readReleaseDecision(event)
+	if statusCode != 200 {
+		return message, statusCode
+	}
+
+	if r.Status != "pending" {
+		message := fmt.Sprintf("Release request %v has already been %v", r.RequestID, r.Status)
+		statusCode := 409
+		return message, statusCode
+	}
+
+	r.Status = "rejected"
+	r.RejectedBy = requestActor(event)
+	r.Reason = d.Reason
+	err := app.AWS.putReleaseRequest(&r)
+	if err == errReleaseRequestChanged {
+		message := fmt.Sprintf("Release request %v was changed while rejecting it, please try again", r.RequestID)
+		statusCode := 409
+		return message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to reject release request %v, could not write request to backend", r.RequestID)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	app.notify(fmt.Sprintf("%v rejected the release of %v version %v. %v",
+		r.RejectedBy,
+		r.Release.RepoName,
+		r.Release.ReleaseVersion,
+		r.Reason,
+	))
+
+	message = fmt.Sprintf("Rejected release request %v", r.RequestID)
+	statusCode = 200
+	return message, statusCode
+}
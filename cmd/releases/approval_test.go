@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func userRequest(path, body, email string) events.APIGatewayV2HTTPRequest {
+	event := events.APIGatewayV2HTTPRequest{RawPath: path, Body: body}
+	event.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+		JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+			Claims: map[string]string{"email": email},
+		},
+	}
+	return event
+}
+
+// requestRelease requests a release as jane and returns the id of the request
+func requestRelease(t *testing.T, app application, body string) string {
+	t.Helper()
+	resp, _ := app.handler(userRequest("/releases/request", body, "jane@example.com"))
+	if resp.StatusCode != 200 {
+		t.Fatalf("Release should have been requested, got %v %v", resp.StatusCode, resp.Body)
+	}
+
+	wrapped := map[string]string{}
+	_ = json.Unmarshal([]byte(resp.Body), &wrapped)
+	r := releaseRequest{}
+	_ = json.Unmarshal([]byte(wrapped["message"]), &r)
+	return r.RequestID
+}
+
+func storedReleaseRequest(items map[string]map[string]*dynamodb.AttributeValue, id string) releaseRequest {
+	r := releaseRequest{}
+	_ = dynamodbattribute.UnmarshalMap(items["release_request|"+id], &r)
+	return r
+}
+
+func TestReleaseApproval(t *testing.T) {
+	newApprovalApplication := func(provider *fakeProvider, required int) (application, map[string]map[string]*dynamodb.AttributeValue) {
+		app := newTestApplication(provider)
+		items := map[string]map[string]*dynamodb.AttributeValue{}
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{CurrentVersion: "1.0.0"}), Items: items}
+		app.Config.RequiredApprovals = required
+		app.Config.RequestTTL = time.Hour
+		return app, items
+	}
+	body := `{"repo_name": "test", "repo_provider": "fake", "bump": "minor"}`
+
+	t.Run("Successfully released once approved", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, items := newApprovalApplication(provider, 1)
+		id := requestRelease(t, app, body)
+		if len(provider.Releases) != 0 {
+			t.Fatal("Release should not have been created before it was approved")
+		}
+		if r := storedReleaseRequest(items, id); r.Release.ReleaseVersion != "1.1.0" || r.Status != "pending" {
+			t.Fatalf("Request should have been pending for version 1.1.0, got %+v", r)
+		}
+
+		resp, _ := app.handler(userRequest("/releases/approve", fmt.Sprintf(`{"request_id": "%v"}`, id), "joe@example.com"))
+		if resp.StatusCode != 200 {
+			t.Fatalf("Release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.Releases) != 1 || provider.Releases[0] != "1.1.0" {
+			t.Fatalf("Release 1.1.0 should have been created, got %v", provider.Releases)
+		}
+		if r := storedReleaseRequest(items, id); r.Status != "released" || r.Approvals[0] != "joe@example.com" {
+			t.Fatalf("Request should have been released, got %+v", r)
+		}
+
+		records := historyRecords(items)
+		if len(records) != 1 || records[0].ApprovalRequestID != id || records[0].Actor != "joe@example.com" {
+			t.Fatalf("Release should have been recorded with its approval, got %+v", records)
+		}
+	})
+
+	t.Run("Requester cannot approve their own release", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, _ := newApprovalApplication(provider, 1)
+		id := requestRelease(t, app, body)
+
+		resp, _ := app.handler(userRequest("/releases/approve", fmt.Sprintf(`{"request_id": "%v"}`, id), "jane@example.com"))
+		if resp.StatusCode != 403 || len(provider.Releases) != 0 {
+			t.Fatalf("Approval should have been rejected, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Release waits for the configured number of approvals", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, items := newApprovalApplication(provider, 2)
+		id := requestRelease(t, app, body)
+		approval := fmt.Sprintf(`{"request_id": "%v"}`, id)
+
+		resp, _ := app.handler(userRequest("/releases/approve", approval, "joe@example.com"))
+		if resp.StatusCode != 200 || len(provider.Releases) != 0 {
+			t.Fatalf("Release should have waited for a second approval, got %v %v", resp.StatusCode, resp.Body)
+		}
+
+		resp, _ = app.handler(userRequest("/releases/approve", approval, "joe@example.com"))
+		if resp.StatusCode != 409 {
+			t.Fatalf("Second approval by the same user should have been rejected, got %v", resp.StatusCode)
+		}
+
+		resp, _ = app.handler(userRequest("/releases/approve", approval, "ann@example.com"))
+		if resp.StatusCode != 200 || len(provider.Releases) != 1 {
+			t.Fatalf("Release should have succeeded after two approvals, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if r := storedReleaseRequest(items, id); len(r.Approvals) != 2 {
+			t.Fatalf("Both approvals should have been recorded, got %v", r.Approvals)
+		}
+	})
+
+	t.Run("Approved request is kept after it expires", func(t *testing.T) {
+		app, items := newApprovalApplication(&fakeProvider{}, 1)
+		id := requestRelease(t, app, body)
+
+		_, _ = app.handler(userRequest("/releases/approve", fmt.Sprintf(`{"request_id": "%v"}`, id), "joe@example.com"))
+		if item := items["release_request|"+id]; item["ExpiresAt"] != nil {
+			t.Fatalf("Approved request should not have expired, got %v", item["ExpiresAt"])
+		}
+	})
+
+	t.Run("Released request is not released again", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, _ := newApprovalApplication(provider, 1)
+		id := requestRelease(t, app, body)
+		approval := fmt.Sprintf(`{"request_id": "%v"}`, id)
+
+		_, _ = app.handler(userRequest("/releases/approve", approval, "joe@example.com"))
+		resp, _ := app.handler(userRequest("/releases/approve", approval, "joe@example.com"))
+		if resp.StatusCode != 409 || len(provider.Releases) != 1 {
+			t.Fatalf("Released request should not have been released again, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Failed release is only released again by its approvers", func(t *testing.T) {
+		provider := &fakeProvider{Errors: map[string]error{"createRelease": errors.New("tag exists")}}
+		app, items := newApprovalApplication(provider, 1)
+		id := requestRelease(t, app, body)
+		approval := fmt.Sprintf(`{"request_id": "%v"}`, id)
+
+		resp, _ := app.handler(userRequest("/releases/approve", approval, "joe@example.com"))
+		if resp.StatusCode == 200 || storedReleaseRequest(items, id).Status != "approved" {
+			t.Fatalf("Failed release should have left the request approved, got %v %+v", resp.StatusCode, storedReleaseRequest(items, id))
+		}
+
+		delete(provider.Errors, "createRelease")
+		resp, _ = app.handler(userRequest("/releases/approve", approval, "ann@example.com"))
+		if resp.StatusCode != 403 || len(provider.Releases) != 0 {
+			t.Fatalf("Release by someone who did not approve it should have been rejected, got %v %v", resp.StatusCode, resp.Body)
+		}
+
+		resp, _ = app.handler(userRequest("/releases/approve", approval, "joe@example.com"))
+		if resp.StatusCode != 200 || len(provider.Releases) != 1 || storedReleaseRequest(items, id).Status != "released" {
+			t.Fatalf("Approver should have released the request, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Request being released is not released twice", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, items := newApprovalApplication(provider, 1)
+		id := requestRelease(t, app, body)
+		r := storedReleaseRequest(items, id)
+		r.Status = "releasing"
+		r.Approvals = []string{"joe@example.com"}
+		r.ReleaseStartedAt = time.Now().Unix()
+		items["release_request|"+id], _ = dynamodbattribute.MarshalMap(r)
+
+		resp, _ := app.handler(userRequest("/releases/approve", fmt.Sprintf(`{"request_id": "%v"}`, id), "joe@example.com"))
+		if resp.StatusCode != 409 || len(provider.Releases) != 0 {
+			t.Fatalf("Request being released should not have been released again, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Rejected release cannot be approved", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, items := newApprovalApplication(provider, 1)
+		id := requestRelease(t, app, body)
+
+		resp, _ := app.handler(userRequest("/releases/reject", fmt.Sprintf(`{"request_id": "%v", "reason": "missing changelog"}`, id), "joe@example.com"))
+		if resp.StatusCode != 200 {
+			t.Fatalf("Release should have been rejected, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if r := storedReleaseRequest(items, id); r.Status != "rejected" || r.RejectedBy != "joe@example.com" || r.Reason != "missing changelog" {
+			t.Fatalf("Rejection should have been recorded, got %+v", r)
+		}
+
+		resp, _ = app.handler(userRequest("/releases/approve", fmt.Sprintf(`{"request_id": "%v"}`, id), "ann@example.com"))
+		if resp.StatusCode != 409 || len(provider.Releases) != 0 {
+			t.Fatalf("Approval should have been rejected, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Expired request cannot be approved", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, items := newApprovalApplication(provider, 1)
+		id := requestRelease(t, app, body)
+		r := storedReleaseRequest(items, id)
+		r.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+		items["release_request|"+id], _ = dynamodbattribute.MarshalMap(r)
+
+		resp, _ := app.handler(userRequest("/releases/approve", fmt.Sprintf(`{"request_id": "%v"}`, id), "joe@example.com"))
+		if resp.StatusCode != 410 || len(provider.Releases) != 0 {
+			t.Fatalf("Approval should have been rejected, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Unknown request is not found", func(t *testing.T) {
+		app, _ := newApprovalApplication(&fakeProvider{}, 1)
+
+		resp, _ := app.handler(userRequest("/releases/approve", `{"request_id": "unknown"}`, "joe@example.com"))
+		if resp.StatusCode != 404 {
+			t.Fatalf("Request should not have been found, got %v", resp.StatusCode)
+		}
+	})
+
+	t.Run("Release without approval is rejected when approvals are required", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, _ := newApprovalApplication(provider, 1)
+
+		resp, _ := app.handler(userRequest("/releases/create", body, "jane@example.com"))
+		if resp.StatusCode != 403 || !strings.Contains(resp.Body, "/releases/request") || len(provider.Releases) != 0 {
+			t.Fatalf("Release should have required approval, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// artifactKeyPrefix is the prefix of the dashboard bucket which artifacts are uploaded to, as the
+// bucket also hosts the frontend
+const artifactKeyPrefix = "artifacts/"
+
+// maxArtifactSize is the largest artifact which can be attached, and maxArtifactsSize the largest total
+// size of the artifacts of a release, as artifacts are held in the releases lambda's memory
+const (
+	maxArtifactSize  = 64 << 20
+	maxArtifactsSize = 128 << 20
+)
+
+// artifactTimeout is how long an artifact may take to download, which leaves the rest of the releases
+// lambda's timeout for the release itself
+const artifactTimeout = 15 * time.Second
+
+var errArtifactsUnsupported = errors.New("artifacts are not supported on this provider")
+
+// privateNetworks are the address ranges which artifacts are never downloaded from, along with loopback,
+// link-local and multicast addresses
+var privateNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("fc00::/7"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// artifact is a file to attach to a release, which is either an object under artifacts/ in the
+// dashboard's bucket or a URL
+type artifact struct {
+	Name string `json:"name"`
+	Key  string `json:"key,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+// releasedArtifact is an artifact along with its checksum. DownloadURL is set once it has been
+// attached to the release.
+type releasedArtifact struct {
+	artifact
+	SHA256      string `json:"sha256"`
+	Size        int    `json:"size"`
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// artifactProvider is implemented by the providers which are able to attach files to releases
+type artifactProvider interface {
+	// uploadArtifact attaches content to the release of e as name and returns its download URL. The
+	// release is identified by draftID when it is a draft, and by its tag otherwise.
+	uploadArtifact(e releaseEvent, draftID, name string, content []byte) (string, error)
+}
+
+// validate returns an error unless the artifact is a file under artifacts/ in the dashboard's bucket,
+// or an https URL on one of hosts
+func (a artifact) validate(hosts []string) error {
+	// NOTE(SMT): a leading dot also rules out . and .., which resolve to directories rather than files
+	if a.Name == "" || strings.ContainsAny(a.Name, `/\`) || strings.HasPrefix(a.Name, ".") {
+		return fmt.Errorf("artifact name %q must be a file name", a.Name)
+	}
+	if (a.Key == "") == (a.URL == "") {
+		return fmt.Errorf("artifact %v needs either a key or a url", a.Name)
+	}
+	if a.Key != "" && !strings.HasPrefix(a.Key, artifactKeyPrefix) {
+		return fmt.Errorf("artifact %v key must start with %v", a.Name, artifactKeyPrefix)
+	}
+	if a.URL != "" {
+		u, err := url.Parse(a.URL)
+		if err != nil {
+			return fmt.Errorf("artifact %v url is not a url", a.Name)
+		}
+		err = artifactURLAllowed(u, hosts)
+		if err != nil {
+			return fmt.Errorf("artifact %v %v", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// artifactURLAllowed returns an error unless u is an https URL on one of hosts. Hosts are host names,
+// or host:port for URLs with a port.
+func artifactURLAllowed(u *url.URL, hosts []string) error {
+	if u.Scheme != "https" {
+		return errors.New("url must be an https url")
+	}
+	for _, host := range hosts {
+		if strings.EqualFold(host, u.Host) || (u.Port() == "" && strings.EqualFold(host, u.Hostname())) {
+			return nil
+		}
+	}
+	return fmt.Errorf("url host %v is not one of the allowed artifact hosts", u.Host)
+}
+
+// publicIP reports whether ip is a public address, rather than a loopback, private, link-local or
+// multicast address such as the lambda runtime API or the instance metadata service
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublic connects to addr once every address its host resolves to has been found to be public.
+// The connection is made to the checked address, so the host cannot resolve somewhere else in between.
+func dialPublic(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%v does not resolve to an address", host)
+	}
+	for _, ip := range ips {
+		if !publicIP(ip.IP) {
+			return nil, fmt.Errorf("%v resolves to %v, which is not a public address", host, ip.IP)
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: artifactTimeout}
+	return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+}
+
+// newArtifactClient returns the client which downloads artifacts passed as URLs. It only follows
+// redirects to the allowed hosts, and only connects to public addresses.
+func newArtifactClient(hosts []string) *http.Client {
+	return &http.Client{
+		Timeout: artifactTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialPublic,
+			TLSHandshakeTimeout: artifactTimeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("stopped after 5 redirects")
+			}
+			return artifactURLAllowed(req.URL, hosts)
+		},
+	}
+}
+
+// fetchArtifact downloads the artifact from the dashboard's bucket or its URL
+func (app application) fetchArtifact(a artifact) ([]byte, error) {
+	var body io.ReadCloser
+	if a.Key != "" {
+		if app.Config.ArtifactsBucket == "" {
+			return nil, errors.New("no artifacts bucket has been configured")
+		}
+
+		resp, err := app.AWS.S3.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(app.Config.ArtifactsBucket),
+			Key:    aws.String(a.Key),
+		})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok {
+				log.Error(fmt.Sprintf("%v", aerr.Error()))
+			} else {
+				log.Error(fmt.Sprintf("%v", err.Error()))
+			}
+			return nil, err
+		}
+		body = resp.Body
+	} else {
+		client := app.ArtifactClient
+		if client == nil {
+			client = newArtifactClient(app.Config.ArtifactHosts)
+		}
+
+		resp, err := client.Get(a.URL)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%v responded with %v", a.URL, resp.Status)
+		}
+		body = resp.Body
+	}
+	defer body.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(body, maxArtifactSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxArtifactSize {
+		return nil, fmt.Errorf("artifact %v is larger than %d bytes", a.Name, maxArtifactSize)
+	}
+	return content, nil
+}
+
+// fetchArtifacts downloads every artifact and computes its checksum. The contents are keyed by
+// artifact name.
+func (app application) fetchArtifacts(artifacts []artifact) ([]releasedArtifact, map[string][]byte, error) {
+	released := []releasedArtifact{}
+	contents := map[string][]byte{}
+	size := 0
+	for _, a := range artifacts {
+		err := a.validate(app.Config.ArtifactHosts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := contents[a.Name]; ok {
+			return nil, nil, fmt.Errorf("artifact %v is listed more than once", a.Name)
+		}
+
+		log.Info(fmt.Sprintf("fetching artifact %v...", a.Name))
+		content, err := app.fetchArtifact(a)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to fetch artifact %v, %v", a.Name, err)
+		}
+
+		size += len(content)
+		if size > maxArtifactsSize {
+			return nil, nil, fmt.Errorf("artifacts are larger than %d bytes in total", maxArtifactsSize)
+		}
+
+		sum := sha256.Sum256(content)
+		contents[a.Name] = content
+		released = append(released, releasedArtifact{artifact: a, SHA256: hex.EncodeToString(sum[:]), Size: len(content)})
+	}
+	return released, contents, nil
+}
+
+// appendChecksums lists the checksums of the artifacts at the end of the release notes, in the
+// format read by sha256sum --check
+func appendChecksums(body string, artifacts []releasedArtifact) string {
+	lines := []string{}
+	for _, a := range artifacts {
+		lines = append(lines, fmt.Sprintf("%v  %v", a.SHA256, a.Name))
+	}
+	checksums := fmt.Sprintf("### Checksums\n\n```\n%v\n```", strings.Join(lines, "\n"))
+
+	if strings.TrimSpace(body) == "" {
+		return checksums
+	}
+	return fmt.Sprintf("%v\n\n%v", strings.TrimRight(body, "\n"), checksums)
+}
+
+// uploadArtifacts attaches the artifacts of the release which have not been attached yet. Artifacts
+// are fetched again when the release is resumed, and must not have changed since it started.
+func (app application) uploadArtifacts(provider releaseProvider, e releaseEvent, state *releaseState, contents map[string][]byte) error {
+	ap, ok := provider.(artifactProvider)
+	if !ok {
+		return errArtifactsUnsupported
+	}
+
+	for i, a := range state.Artifacts {
+		if a.DownloadURL != "" {
+			continue
+		}
+
+		content, ok := contents[a.Name]
+		if !ok {
+			var err error
+			content, err = app.fetchArtifact(a.artifact)
+			if err != nil {
+				return fmt.Errorf("unable to fetch artifact %v, %v", a.Name, err)
+			}
+			sum := sha256.Sum256(content)
+			if hex.EncodeToString(sum[:]) != a.SHA256 {
+				return fmt.Errorf("artifact %v has changed since the release started", a.Name)
+			}
+		}
+
+		log.Info(fmt.Sprintf("uploading %v artifact %v...", e.RepoName, a.Name))
+		downloadURL, err := ap.uploadArtifact(e, state.DraftReleaseID, a.Name, content)
+		if err != nil {
+			return fmt.Errorf("unable to upload artifact %v, %v", a.Name, err)
+		}
+
+		// NOTE(SMT): the state is saved after every upload, so that a retry does not attach an artifact twice
+		state.Artifacts[i].DownloadURL = downloadURL
+		err = app.AWS.putReleaseState(*state)
+		if err != nil {
+			log.Error(fmt.Sprintf("unable to save %v release %v artifact %v", e.RepoName, e.ReleaseVersion, a.Name))
+		}
+	}
+	return nil
+}
+
+// writeArtifactFile writes content to a file called name in a new temporary directory, as the
+// provider clients upload files from disk. The directory is removed by calling cleanup.
+func writeArtifactFile(name string, content []byte) (string, func(), error) {
+	dir, err := ioutil.TempDir("", "artifact")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	path := filepath.Join(dir, name)
+	err = ioutil.WriteFile(path, content, 0600)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return path, cleanup, nil
+}
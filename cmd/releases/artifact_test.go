@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// artifactChecksum is the SHA-256 checksum of "binary"
+const artifactChecksum = "9a3a45d01531a20e89ac6ae10b0b0beb0492acd7216a368aa062d1a5fecaf9cd"
+
+func TestArtifactValidate(t *testing.T) {
+	tests := map[string]struct {
+		Artifact artifact
+		Valid    bool
+	}{
+		"bucket artifact":       {Artifact: artifact{Name: "app.tar.gz", Key: "artifacts/app.tar.gz"}, Valid: true},
+		"url artifact":          {Artifact: artifact{Name: "app.tar.gz", URL: "https://example.com/app.tar.gz"}, Valid: true},
+		"missing name":          {Artifact: artifact{Key: "artifacts/app.tar.gz"}},
+		"path as name":          {Artifact: artifact{Name: "../app.tar.gz", Key: "artifacts/app.tar.gz"}},
+		"dot as name":           {Artifact: artifact{Name: ".", Key: "artifacts/app.tar.gz"}},
+		"dot dot as name":       {Artifact: artifact{Name: "..", Key: "artifacts/app.tar.gz"}},
+		"hidden file as name":   {Artifact: artifact{Name: ".env", Key: "artifacts/app.tar.gz"}},
+		"missing source":        {Artifact: artifact{Name: "app.tar.gz"}},
+		"key and url":           {Artifact: artifact{Name: "app.tar.gz", Key: "artifacts/app.tar.gz", URL: "https://example.com/app.tar.gz"}},
+		"key outside artifacts": {Artifact: artifact{Name: "index.html", Key: "index.html"}},
+		"url without http":      {Artifact: artifact{Name: "app.tar.gz", URL: "file:///etc/passwd"}},
+		"http url":              {Artifact: artifact{Name: "app.tar.gz", URL: "http://example.com/app.tar.gz"}},
+		"url on another host":   {Artifact: artifact{Name: "app.tar.gz", URL: "https://127.0.0.1:9001/2018-06-01/runtime/invocation/next"}},
+		"url on another port":   {Artifact: artifact{Name: "app.tar.gz", URL: "https://example.com:8443/app.tar.gz"}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := test.Artifact.validate([]string{"example.com"}); (err == nil) != test.Valid {
+				t.Fatalf("expected valid %v, got %v", test.Valid, err)
+			}
+		})
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"169.254.169.254": false,
+		"10.1.2.3":        false,
+		"172.20.0.1":      false,
+		"192.168.1.1":     false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fd00::1":         false,
+		"fe80::1":         false,
+	}
+
+	for address, public := range tests {
+		t.Run(address, func(t *testing.T) {
+			if got := publicIP(net.ParseIP(address)); got != public {
+				t.Fatalf("expected public %v, got %v", public, got)
+			}
+		})
+	}
+}
+
+func TestArtifactClient(t *testing.T) {
+	t.Run("Artifact on a loopback address is not downloaded", func(t *testing.T) {
+		requested := false
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = true
+		}))
+		defer server.Close()
+
+		u, _ := url.Parse(server.URL)
+		_, err := newArtifactClient([]string{u.Host}).Get(server.URL)
+		if err == nil || requested || !strings.Contains(err.Error(), "not a public address") {
+			t.Fatalf("Download from a loopback address should have been refused, got %v", err)
+		}
+	})
+}
+
+func TestAppendChecksums(t *testing.T) {
+	artifacts := []releasedArtifact{
+		{artifact: artifact{Name: "a.zip"}, SHA256: "aaa"},
+		{artifact: artifact{Name: "b.zip"}, SHA256: "bbb"},
+	}
+
+	got := appendChecksums("notes\n", artifacts)
+	want := "notes\n\n### Checksums\n\n```\naaa  a.zip\nbbb  b.zip\n```"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestReleaseArtifacts(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/checksums.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("binary"))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	// NOTE(SMT): the test server listens on a loopback address, which the artifact client refuses
+	newApplication := func(provider *fakeProvider) (application, map[string]map[string]*dynamodb.AttributeValue) {
+		app, _, items := newRepositoryApplication(provider, repository{CurrentVersion: "1.2.0"})
+		app.Config.ArtifactsBucket = "dashboard"
+		app.Config.ArtifactHosts = []string{u.Host}
+		app.ArtifactClient = server.Client()
+		return app, items
+	}
+
+	event := events.APIGatewayV2HTTPRequest{
+		RawPath: "/releases/create",
+		Body: `{"repo_name": "test", "repo_provider": "fake", "repo_owner": "test", "branch_base": "main", "branch_head": "develop", "release_version": "1.3.0", "release_body": "notes",
+			"artifacts": [{"name": "app.tar.gz", "key": "artifacts/app.tar.gz"}, {"name": "checksums.txt", "url": "` + server.URL + `/checksums.txt"}]}`,
+	}
+
+	t.Run("Successfully attached artifacts from the bucket and urls", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, items := newApplication(provider)
+		app.AWS.S3 = mockS3{Objects: map[string]string{"artifacts/app.tar.gz": "binary"}}
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 200 {
+			t.Fatalf("Release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if provider.Uploaded["app.tar.gz"] != "binary" || provider.Uploaded["checksums.txt"] != "binary" {
+			t.Fatalf("Artifacts should have been uploaded, got %v", provider.Uploaded)
+		}
+		if !strings.Contains(provider.ReleaseBodies[0], artifactChecksum+"  app.tar.gz\n"+artifactChecksum+"  checksums.txt") {
+			t.Fatalf("Checksums should have been listed in the release notes, got %v", provider.ReleaseBodies[0])
+		}
+
+		records := historyRecords(items)
+		if len(records) != 1 || len(records[0].Artifacts) != 2 || records[0].Artifacts[0].DownloadURL != "https://example.com/download/app.tar.gz" {
+			t.Fatalf("Artifacts should have been recorded in history, got %+v", records)
+		}
+	})
+
+	t.Run("Missing artifact fails the release before it starts", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, _ := newApplication(provider)
+		app.AWS.S3 = mockS3{Objects: map[string]string{}}
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 400 || len(provider.PullRequests) != 0 {
+			t.Fatalf("Release should have failed before opening a pull request, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Successfully resumed a release whose artifact upload failed", func(t *testing.T) {
+		provider := &fakeProvider{Errors: map[string]error{"uploadArtifact:checksums.txt": errors.New("boom")}}
+		app, _ := newApplication(provider)
+		app.AWS.S3 = mockS3{Objects: map[string]string{"artifacts/app.tar.gz": "binary"}}
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 400 || len(provider.Releases) != 1 || len(provider.Uploaded) != 1 {
+			t.Fatalf("Upload should have failed after the release, got %v %v", resp.StatusCode, resp.Body)
+		}
+
+		provider.Errors = nil
+		provider.Uploaded = nil
+		resp, _ = app.handler(event)
+		if resp.StatusCode != 200 {
+			t.Fatalf("Release should have resumed, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.Releases) != 1 || len(provider.Uploaded) != 1 || provider.Uploaded["checksums.txt"] != "binary" {
+			t.Fatalf("Only the missing artifact should have been uploaded, got releases %v uploads %v", provider.Releases, provider.Uploaded)
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/seanturner026/moot/internal/azuredevops"
+	log "github.com/sirupsen/logrus"
+)
+
+type azureDevOpsController struct {
+	Client              *azuredevops.Client
+	PollInterval        time.Duration
+	MergeCommitTemplate string
+}
+
+func newAzureDevOpsController(e releaseEvent, repo repository, token string) (releaseProvider, error) {
+	if repo.AzureOrganization == "" || repo.AzureProject == "" {
+		log.Error(fmt.Sprintf("%v does not have an azure devops organization and project", e.RepoName))
+		return nil, errors.New("azure devops repositories require an organization and project")
+	}
+
+	return azureDevOpsController{
+		Client:              azuredevops.NewClient(repo.BaseURL, repo.AzureOrganization, repo.AzureProject, token),
+		PollInterval:        time.Second,
+		MergeCommitTemplate: repo.MergeCommitTemplate,
+	}, nil
+}
+
+// compareBranches returns the commits on head which are not on base, and the files they change.
+// Hotfixes compare the previous release's tag with BranchBase, so base is a tag rather than a branch.
+func (app azureDevOpsController) compareBranches(e releaseEvent, base, head string) (comparison, error) {
+	baseType := "branch"
+	if e.Hotfix {
+		baseType = "tag"
+	}
+
+	log.Info(fmt.Sprintf("comparing %v %v...%v...", e.RepoName, base, head))
+	resp, err := app.Client.ListCommits(e.RepoName, base, baseType, head)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to compare %v %v...%v, %v", e.RepoName, base, head, err))
+		return comparison{}, err
+	}
+
+	diffs, err := app.Client.GetCommitDiffs(e.RepoName, base, baseType, head)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to list %v files changed on %v, %v", e.RepoName, head, err))
+		return comparison{}, err
+	}
+
+	c := comparison{AheadBy: diffs.AheadCount, BehindBy: diffs.BehindCount}
+	for _, change := range diffs.Changes {
+		if change.Item.IsFolder {
+			continue
+		}
+		c.Files = append(c.Files, changedFile{Filename: change.Item.Path, Status: change.ChangeType})
+	}
+	for _, azureCommit := range resp {
+		c.Commits = append(c.Commits, commit{
+			SHA:     azureCommit.CommitID,
+			Message: azureCommit.Comment,
+			Author:  azureCommit.Author.Name,
+			Merge:   len(azureCommit.Parents) > 1,
+		})
+	}
+	return c, nil
+}
+
+// createPullRequest opens an Azure DevOps pull request which merges BranchHead into BranchBase, unless
+// an active one already does, in which case its title and description are replaced
+func (app azureDevOpsController) createPullRequest(e releaseEvent) (pullRequest, error) {
+	existing, err := app.Client.ListActivePullRequests(e.RepoName, e.BranchHead, e.BranchBase)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to list %v pull requests, %v", e.RepoName, err))
+		return pullRequest{}, err
+	}
+	if len(existing) > 0 {
+		id := existing[0].PullRequestID
+		log.Info(fmt.Sprintf("updating existing %v pull request %v...", e.RepoName, id))
+		_, err := app.Client.UpdatePullRequest(e.RepoName, id, e.pullRequestTitle(), e.ReleaseBody)
+		if err != nil {
+			log.Error(fmt.Sprintf("unable to update %v pull request %v, %v", e.RepoName, id, err))
+			return pullRequest{}, err
+		}
+		return pullRequest{Number: id, URL: app.Client.WebURL(e.RepoName, id)}, nil
+	}
+
+	log.Info(fmt.Sprintf("creating %v pull request...", e.RepoName))
+	resp, err := app.Client.CreatePullRequest(e.RepoName, e.pullRequestTitle(), e.ReleaseBody, e.BranchHead, e.BranchBase)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create %v pull request, %v", e.RepoName, err))
+		return pullRequest{}, err
+	}
+
+	return pullRequest{Number: resp.PullRequestID, URL: app.Client.WebURL(e.RepoName, resp.PullRequestID)}, nil
+}
+
+// waitForMergeable returns an error if Azure DevOps found conflicts when it attempted the merge
+func (app azureDevOpsController) waitForMergeable(e releaseEvent, pr pullRequest) error {
+	log.Info(fmt.Sprintf("checking %v pull request %v mergability...", e.RepoName, pr.Number))
+	resp, err := app.Client.GetPullRequest(e.RepoName, pr.Number)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to check %v pull request %v mergability, %v", e.RepoName, pr.Number, err))
+		return err
+	}
+
+	if resp.MergeStatus == "conflicts" || resp.MergeStatus == "failure" {
+		return fmt.Errorf("pull request merge status is %v", resp.MergeStatus)
+	}
+	return nil
+}
+
+// listChecks returns no checks, as Azure DevOps enforces branch policies when the pull request is
+// completed
+func (app azureDevOpsController) listChecks(e releaseEvent, pr pullRequest) ([]check, error) {
+	return []check{}, nil
+}
+
+// mergePullRequest completes the pull request and waits for the merge to finish. If branch policies
+// prevent the pull request from completing, auto-complete is set so that it merges once the policies
+// pass, and an error is returned as the release cannot continue yet. The merge commit ID is returned
+// once the pull request completes.
+func (app azureDevOpsController) mergePullRequest(e releaseEvent, pr pullRequest) (string, error) {
+	message := mergeCommitMessage(app.MergeCommitTemplate, e, pr)
+
+	resp, err := app.Client.GetPullRequest(e.RepoName, pr.Number)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to get %v pull request %v, %v", e.RepoName, pr.Number, err))
+		return "", err
+	}
+
+	log.Info(fmt.Sprintf("completing %v pull request %v...", e.RepoName, pr.Number))
+	completed, err := app.Client.CompletePullRequest(e.RepoName, resp, message)
+	if err == nil {
+		resp = completed
+		for i := 0; i < 7; i++ {
+			switch {
+			case resp.Status == "completed":
+				return resp.LastMergeCommit.CommitID, nil
+			case resp.Status == "abandoned":
+				return "", errors.New("pull request was abandoned")
+			case resp.MergeStatus == "conflicts" || resp.MergeStatus == "failure":
+				return "", fmt.Errorf("pull request merge status is %v", resp.MergeStatus)
+			}
+
+			time.Sleep(app.PollInterval)
+			resp, err = app.Client.GetPullRequest(e.RepoName, pr.Number)
+			if err != nil {
+				log.Error(fmt.Sprintf("unable to get %v pull request %v, %v", e.RepoName, pr.Number, err))
+				return "", err
+			}
+		}
+	}
+
+	log.Info(fmt.Sprintf("setting auto-complete on %v pull request %v...", e.RepoName, pr.Number))
+	_, autoCompleteErr := app.Client.SetAutoComplete(e.RepoName, resp, message)
+	if autoCompleteErr != nil {
+		log.Error(fmt.Sprintf("unable to set auto-complete on %v pull request %v, %v", e.RepoName, pr.Number, autoCompleteErr))
+		return "", autoCompleteErr
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to complete %v pull request %v, %v", e.RepoName, pr.Number, err))
+		return "", err
+	}
+	return "", errors.New("pull request is waiting on branch policies and will auto-complete")
+}
+
+// createRelease creates an annotated tag on the head of the release's target branch, as Azure DevOps
+// Repos has no concept of releases
+func (app azureDevOpsController) createRelease(e releaseEvent) error {
+	branch, err := app.Client.GetBranch(e.RepoName, e.releaseTarget())
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to find %v branch %v, %v", e.RepoName, e.releaseTarget(), err))
+		return err
+	}
+
+	log.Info(fmt.Sprintf("creating %v tag %v...", e.RepoName, e.ReleaseVersion))
+	err = app.Client.CreateAnnotatedTag(e.RepoName, e.ReleaseVersion, branch.ObjectID, e.ReleaseBody)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create %v tag %v, %v", e.RepoName, e.ReleaseVersion, err))
+		return err
+	}
+	return nil
+}
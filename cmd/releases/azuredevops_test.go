@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/seanturner026/moot/internal/azuredevops"
+)
+
+func TestAzureDevOpsRelease(t *testing.T) {
+	t.Run("Successfully released to Azure DevOps", func(t *testing.T) {
+		tags := []string{}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/org/project/_apis/git/repositories/repo/pullrequests", func(w http.ResponseWriter, r *http.Request) {
+			if _, token, _ := r.BasicAuth(); token != "token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.Method == http.MethodGet {
+				w.Write([]byte(`{"value": []}`))
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"pullRequestId": 12, "status": "active"}`))
+		})
+		mux.HandleFunc("/org/project/_apis/git/repositories/repo/pullrequests/12", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPatch {
+				w.Write([]byte(`{"pullRequestId": 12, "status": "completed", "mergeStatus": "succeeded"}`))
+				return
+			}
+			w.Write([]byte(`{"pullRequestId": 12, "status": "active", "createdBy": {"id": "user"}, "lastMergeSourceCommit": {"commitId": "def456"}}`))
+		})
+		mux.HandleFunc("/org/project/_apis/git/repositories/repo/refs", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("filter") != "heads/main" {
+				t.Errorf("unexpected ref filter %v", r.URL.Query().Get("filter"))
+			}
+			w.Write([]byte(`{"value": [{"name": "refs/heads/main", "objectId": "abc123"}]}`))
+		})
+		mux.HandleFunc("/org/project/_apis/git/repositories/repo/annotatedtags", func(w http.ResponseWriter, r *http.Request) {
+			input := struct {
+				Name         string `json:"name"`
+				TaggedObject struct {
+					ObjectID string `json:"objectId"`
+				} `json:"taggedObject"`
+			}{}
+			json.NewDecoder(r.Body).Decode(&input)
+			if input.TaggedObject.ObjectID != "abc123" {
+				t.Errorf("tag should target the head of main, got %v", input.TaggedObject.ObjectID)
+			}
+			tags = append(tags, input.Name)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		provider := azureDevOpsController{Client: azuredevops.NewClient(server.URL, "org", "project", "token")}
+		e := releaseEvent{
+			RepoOwner:      "org",
+			RepoName:       "repo",
+			RepoProvider:   "azuredevops",
+			BranchBase:     "main",
+			BranchHead:     "develop",
+			ReleaseVersion: "1.0.0",
+		}
+
+		app := application{AWS: awsController{TableName: "test", DB: mockDynamoDB{}}}
+		_, statusCode := app.runRelease(provider, e, &releaseState{Step: "started"})
+		if statusCode != 200 {
+			t.Fatalf("Release should have succeeded, got %v", statusCode)
+		}
+		if len(tags) != 1 || tags[0] != "1.0.0" {
+			t.Fatalf("Tag 1.0.0 should have been created, got %v", tags)
+		}
+	})
+
+	t.Run("Pull request blocked by policies is set to auto-complete", func(t *testing.T) {
+		autoCompleteSetBy := ""
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPatch {
+				input := map[string]interface{}{}
+				json.NewDecoder(r.Body).Decode(&input)
+				if setBy, ok := input["autoCompleteSetBy"].(map[string]interface{}); ok {
+					autoCompleteSetBy = setBy["id"].(string)
+				}
+			}
+			w.Write([]byte(`{"pullRequestId": 12, "status": "active", "createdBy": {"id": "user"}}`))
+		}))
+		defer server.Close()
+
+		provider := azureDevOpsController{Client: azuredevops.NewClient(server.URL, "org", "project", "token")}
+		_, err := provider.mergePullRequest(releaseEvent{RepoName: "repo"}, pullRequest{Number: 12})
+		if err == nil {
+			t.Fatal("Merge should not have completed")
+		}
+		if autoCompleteSetBy != "user" {
+			t.Fatalf("Auto-complete should have been set by the pull request creator, got %q", autoCompleteSetBy)
+		}
+	})
+
+	t.Run("Repository without an organization and project is rejected", func(t *testing.T) {
+		_, err := newAzureDevOpsController(releaseEvent{RepoName: "repo"}, repository{}, "token")
+		if err == nil {
+			t.Fatal("Provider should not have been created")
+		}
+	})
+}
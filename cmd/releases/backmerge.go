@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// backMergeEvent is the release event for the pull request which merges a hotfix on BranchBase back
+// into BranchHead
+func backMergeEvent(e releaseEvent) releaseEvent {
+	b := e
+	b.BranchBase = e.BranchHead
+	b.BranchHead = e.BranchBase
+	b.ReleaseBody = fmt.Sprintf("Merges hotfix %v on %v back into %v.", e.ReleaseVersion, e.BranchBase, e.BranchHead)
+	b.BackMerge = true
+	return b
+}
+
+// backMerge opens a pull request which merges a hotfix on BranchBase back into BranchHead, so that the
+// next release does not regress the fix. The pull request is merged straight away when autoMerge is
+// set, it can be merged and its checks have passed. As the hotfix has already been released,
+// failures are only described in the returned sentence, which is appended to the release message.
+func (app application) backMerge(provider releaseProvider, e releaseEvent, autoMerge bool) (pullRequest, string) {
+	b := backMergeEvent(e)
+	log.Info(fmt.Sprintf("opening %v back-merge of %v into %v...", e.RepoName, b.BranchHead, b.BranchBase))
+	pr, err := provider.createPullRequest(b)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to open %v back-merge pull request, %v", e.RepoName, err))
+		note := fmt.Sprintf("Unable to open a pull request merging %v back into %v, please merge it by hand.", b.BranchHead, b.BranchBase)
+		return pullRequest{}, note
+	}
+
+	if !autoMerge {
+		note := fmt.Sprintf("Opened back-merge pull request %v into %v.", pr.URL, b.BranchBase)
+		return pr, note
+	}
+
+	err = provider.waitForMergeable(b, pr)
+	if err != nil {
+		log.Info(fmt.Sprintf("%v back-merge pull request %v cannot be merged, %v", e.RepoName, pr.Number, err))
+		note := fmt.Sprintf("Opened back-merge pull request %v into %v, which cannot be merged automatically, %v.", pr.URL, b.BranchBase, err)
+		return pr, note
+	}
+
+	blocking, err := app.waitForChecks(provider, b, pr)
+	if err != nil {
+		note := fmt.Sprintf("Opened back-merge pull request %v into %v, whose checks could not be read, please merge it once they have passed.", pr.URL, b.BranchBase)
+		return pr, note
+	}
+	if len(blocking) > 0 {
+		log.Info(fmt.Sprintf("%v back-merge pull request %v is blocked by checks %v", e.RepoName, pr.Number, describeChecks(blocking)))
+		note := fmt.Sprintf("Opened back-merge pull request %v into %v, which is blocked by checks %v.", pr.URL, b.BranchBase, describeChecks(blocking))
+		return pr, note
+	}
+
+	_, err = provider.mergePullRequest(b, pr)
+	if err != nil {
+		note := fmt.Sprintf("Opened back-merge pull request %v into %v, which could not be merged automatically.", pr.URL, b.BranchBase)
+		return pr, note
+	}
+
+	note := fmt.Sprintf("Merged back-merge pull request %v into %v.", pr.URL, b.BranchBase)
+	return pr, note
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestBackMerge(t *testing.T) {
+	hotfix := events.APIGatewayV2HTTPRequest{
+		RawPath: "/releases/create",
+		Body:    `{"repo_name": "test", "repo_provider": "fake", "branch_base": "main", "branch_head": "develop", "release_version": "1.0.1", "hotfix": true}`,
+	}
+
+	t.Run("Successfully opened back-merge pull request", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+
+		resp, _ := app.handler(hotfix)
+		if resp.StatusCode != 200 || !strings.Contains(resp.Body, "Opened back-merge pull request https://example.com/pull into develop") {
+			t.Fatalf("Back-merge pull request should have been reported, got %v %v", resp.StatusCode, resp.Body)
+		}
+
+		b := provider.PullRequestEvents[0]
+		if b.BranchBase != "develop" || b.BranchHead != "main" || b.pullRequestTitle() != "Back-merge 1.0.1 into develop" {
+			t.Fatalf("Back-merge should merge main into develop, got %+v", b)
+		}
+		if len(provider.Merged) != 0 {
+			t.Fatal("Back-merge should not have been merged")
+		}
+	})
+
+	t.Run("Successfully auto-merged back-merge pull request", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{AutoBackMerge: true})}
+
+		resp, _ := app.handler(hotfix)
+		if resp.StatusCode != 200 || !strings.Contains(resp.Body, "Merged back-merge pull request") || len(provider.Merged) != 1 {
+			t.Fatalf("Back-merge should have been merged, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Conflicting back-merge is left open", func(t *testing.T) {
+		provider := &fakeProvider{Errors: map[string]error{"waitForMergeable": errors.New("pull request has merge conflicts")}}
+		app := newTestApplication(provider)
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{AutoBackMerge: true})}
+
+		resp, _ := app.handler(hotfix)
+		if resp.StatusCode != 200 || !strings.Contains(resp.Body, "cannot be merged automatically") || len(provider.Merged) != 0 {
+			t.Fatalf("Hotfix should have been released with the back-merge left open, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Back-merge blocked by checks is left open", func(t *testing.T) {
+		provider := &fakeProvider{Checks: []check{{Name: "ci", State: "pending"}}}
+		app := newTestApplication(provider)
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{AutoBackMerge: true})}
+
+		resp, _ := app.handler(hotfix)
+		if resp.StatusCode != 200 || !strings.Contains(resp.Body, "blocked by checks ci (pending)") || len(provider.Merged) != 0 {
+			t.Fatalf("Hotfix should have been released with the back-merge left open, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Hotfix is released when the back-merge cannot be opened", func(t *testing.T) {
+		provider := &fakeProvider{Errors: map[string]error{"createPullRequest": errors.New("forbidden")}}
+		app := newTestApplication(provider)
+
+		resp, _ := app.handler(hotfix)
+		if resp.StatusCode != 200 || len(provider.Releases) != 1 || !strings.Contains(resp.Body, "please merge it by hand") {
+			t.Fatalf("Hotfix should have been released, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Redeployed hotfix does not open a second back-merge", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: map[string]map[string]*dynamodb.AttributeValue{}}
+
+		_, _ = app.handler(hotfix)
+		_, _ = app.handler(hotfix)
+		if len(provider.PullRequests) != 1 {
+			t.Fatalf("Only one back-merge pull request should have been opened, got %v", len(provider.PullRequests))
+		}
+	})
+}
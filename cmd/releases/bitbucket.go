@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/seanturner026/moot/internal/bitbucket"
+	log "github.com/sirupsen/logrus"
+)
+
+type bitbucketController struct {
+	Client              *bitbucket.Client
+	MergeCommitTemplate string
+}
+
+func newBitbucketController(e releaseEvent, repo repository, token string) (releaseProvider, error) {
+	return bitbucketController{Client: bitbucket.NewClient(token), MergeCommitTemplate: repo.MergeCommitTemplate}, nil
+}
+
+// compareBranches returns the commits on head which are not on base, and the files they change.
+// Bitbucket reports files which would conflict when head is merged into base.
+func (app bitbucketController) compareBranches(e releaseEvent, base, head string) (comparison, error) {
+	log.Info(fmt.Sprintf("comparing %v %v...%v...", e.RepoName, base, head))
+	resp, err := app.Client.ListCommits(e.RepoOwner, e.RepoName, head, base)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to compare %v %v...%v, %v", e.RepoName, base, head, err))
+		return comparison{}, err
+	}
+
+	behind, err := app.Client.ListCommits(e.RepoOwner, e.RepoName, base, head)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to compare %v %v...%v, %v", e.RepoName, head, base, err))
+		return comparison{}, err
+	}
+
+	stats, err := app.Client.ListDiffStat(e.RepoOwner, e.RepoName, head, base)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to list %v files changed on %v, %v", e.RepoName, head, err))
+		return comparison{}, err
+	}
+
+	c := comparison{AheadBy: len(resp), BehindBy: len(behind)}
+	for _, stat := range stats {
+		file := changedFile{Status: stat.Status, Additions: stat.LinesAdded, Deletions: stat.LinesRemoved}
+		if stat.New != nil {
+			file.Filename = stat.New.Path
+		} else if stat.Old != nil {
+			file.Filename = stat.Old.Path
+		}
+		if strings.Contains(stat.Status, "conflict") {
+			c.Mergeable = "conflicts"
+		}
+		c.Files = append(c.Files, file)
+	}
+	for _, bitbucketCommit := range resp {
+		c.Commits = append(c.Commits, commit{
+			SHA:     bitbucketCommit.Hash,
+			Message: bitbucketCommit.Message,
+			Author:  bitbucketCommit.Author.Raw,
+			Merge:   len(bitbucketCommit.Parents) > 1,
+		})
+	}
+	return c, nil
+}
+
+// createPullRequest opens a Bitbucket pull request which merges BranchHead into BranchBase. An open
+// pull request between the branches is updated for the release instead.
+func (app bitbucketController) createPullRequest(e releaseEvent) (pullRequest, error) {
+	existing, err := app.Client.ListOpenPullRequests(e.RepoOwner, e.RepoName, e.BranchHead, e.BranchBase)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to list %v pull requests, %v", e.RepoName, err))
+		return pullRequest{}, err
+	}
+	if len(existing) > 0 {
+		log.Info(fmt.Sprintf("updating existing %v pull request %v...", e.RepoName, existing[0].ID))
+		resp, err := app.Client.UpdatePullRequest(e.RepoOwner, e.RepoName, existing[0].ID, e.pullRequestTitle(), e.ReleaseBody)
+		if err != nil {
+			log.Error(fmt.Sprintf("unable to update %v pull request %v, %v", e.RepoName, existing[0].ID, err))
+			return pullRequest{}, err
+		}
+		return pullRequest{Number: resp.ID, URL: resp.Links.HTML.Href}, nil
+	}
+
+	log.Info(fmt.Sprintf("creating %v pull request...", e.RepoName))
+	resp, err := app.Client.CreatePullRequest(
+		e.RepoOwner,
+		e.RepoName,
+		e.pullRequestTitle(),
+		e.ReleaseBody,
+		e.BranchHead,
+		e.BranchBase,
+	)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create %v pull request, %v", e.RepoName, err))
+		return pullRequest{}, err
+	}
+
+	return pullRequest{Number: resp.ID, URL: resp.Links.HTML.Href}, nil
+}
+
+// waitForMergeable is a no-op, as Bitbucket only reports conflicts when the pull request is merged
+func (app bitbucketController) waitForMergeable(e releaseEvent, pr pullRequest) error {
+	return nil
+}
+
+// listChecks does not gate merges on Bitbucket build statuses
+func (app bitbucketController) listChecks(e releaseEvent, pr pullRequest) ([]check, error) {
+	return []check{}, nil
+}
+
+// mergePullRequest merges the pull request created by createPullRequest, and returns the hash of the
+// merge commit
+func (app bitbucketController) mergePullRequest(e releaseEvent, pr pullRequest) (string, error) {
+	log.Info(fmt.Sprintf("merging pull request %v...", pr.Number))
+	resp, err := app.Client.MergePullRequest(
+		e.RepoOwner,
+		e.RepoName,
+		pr.Number,
+		mergeCommitMessage(app.MergeCommitTemplate, e, pr),
+	)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to merge %v pull request %v, %v", e.RepoName, pr.Number, err))
+		return "", err
+	}
+
+	if resp.State != "MERGED" {
+		log.Error(fmt.Sprintf("%v pull request %v not merged, state is %v", e.RepoName, pr.Number, resp.State))
+		return "", errors.New("pull request was not merged")
+	}
+
+	if resp.MergeCommit == nil {
+		return "", nil
+	}
+	return resp.MergeCommit.Hash, nil
+}
+
+// createRelease tags the head of the release's target branch with the ReleaseVersion, as Bitbucket
+// has no concept of releases
+func (app bitbucketController) createRelease(e releaseEvent) error {
+	branch, err := app.Client.GetBranch(e.RepoOwner, e.RepoName, e.releaseTarget())
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to find %v branch %v, %v", e.RepoName, e.releaseTarget(), err))
+		return err
+	}
+
+	log.Info(fmt.Sprintf("creating %v tag %v...", e.RepoName, e.ReleaseVersion))
+	err = app.Client.CreateTag(e.RepoOwner, e.RepoName, e.ReleaseVersion, branch.Target.Hash, e.ReleaseBody)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create %v tag %v, %v", e.RepoName, e.ReleaseVersion, err))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/seanturner026/moot/internal/bitbucket"
+)
+
+// newBitbucketServer is a local stand-in for the subset of the Bitbucket REST API used by releases
+func newBitbucketServer(t *testing.T, tags *[]string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repositories/owner/repo/pullrequests", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"values": []}`))
+			return
+		}
+		input := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&input)
+		if input["title"] != "1.0.0" {
+			t.Errorf("unexpected pull request title %v", input["title"])
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 7, "state": "OPEN", "links": {"html": {"href": "https://bitbucket.org/owner/repo/pull-requests/7"}}}`))
+	})
+	mux.HandleFunc("/repositories/owner/repo/pullrequests/7/merge", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 7, "state": "MERGED", "merge_commit": {"hash": "abc123"}}`))
+	})
+	mux.HandleFunc("/repositories/owner/repo/refs/branches/main", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name": "main", "target": {"hash": "abc123"}}`))
+	})
+	mux.HandleFunc("/repositories/owner/repo/refs/tags", func(w http.ResponseWriter, r *http.Request) {
+		input := struct {
+			Name   string `json:"name"`
+			Target struct {
+				Hash string `json:"hash"`
+			} `json:"target"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&input)
+		if input.Target.Hash != "abc123" {
+			t.Errorf("tag should target the head of main, got %v", input.Target.Hash)
+		}
+		*tags = append(*tags, input.Name)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestBitbucketRelease(t *testing.T) {
+	t.Run("Successfully released to Bitbucket", func(t *testing.T) {
+		tags := []string{}
+		server := newBitbucketServer(t, &tags)
+		defer server.Close()
+
+		client := bitbucket.NewClient("token")
+		client.BaseURL = server.URL
+		provider := bitbucketController{Client: client}
+
+		app := application{AWS: awsController{TableName: "test", DB: mockDynamoDB{}}}
+		e := releaseEvent{
+			RepoOwner:      "owner",
+			RepoName:       "repo",
+			RepoProvider:   "bitbucket",
+			BranchBase:     "main",
+			BranchHead:     "develop",
+			ReleaseVersion: "1.0.0",
+		}
+
+		_, statusCode := app.runRelease(provider, e, &releaseState{Step: "started"})
+		if statusCode != 200 {
+			t.Fatalf("Release should have succeeded, got %v", statusCode)
+		}
+		if len(tags) != 1 || tags[0] != "1.0.0" {
+			t.Fatalf("Tag 1.0.0 should have been created, got %v", tags)
+		}
+	})
+
+	t.Run("Unmerged pull request fails the release", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"id": 7, "state": "OPEN"}`))
+		}))
+		defer server.Close()
+
+		client := bitbucket.NewClient("user:app_password")
+		client.BaseURL = server.URL
+		provider := bitbucketController{Client: client}
+
+		_, err := provider.mergePullRequest(releaseEvent{RepoOwner: "owner", RepoName: "repo"}, pullRequest{Number: 7})
+		if err == nil {
+			t.Fatal("Merge should have failed")
+		}
+	})
+
+	t.Run("Successfully compared branches across pages", func(t *testing.T) {
+		var server *httptest.Server
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repositories/owner/repo/commits/develop", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("exclude") != "main" {
+				t.Errorf("unexpected exclude %v", r.URL.Query().Get("exclude"))
+			}
+			if r.URL.Query().Get("page") == "2" {
+				w.Write([]byte(`{"values": [{"hash": "bbb", "message": "fix: second", "parents": [{"hash": "ccc"}]}]}`))
+				return
+			}
+			w.Write([]byte(`{"values": [{"hash": "aaa", "message": "feat: first", "parents": [{"hash": "bbb"}]}], "next": "` +
+				server.URL + `/repositories/owner/repo/commits/develop?exclude=main&page=2"}`))
+		})
+		mux.HandleFunc("/repositories/owner/repo/commits/main", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"values": []}`))
+		})
+		mux.HandleFunc("/repositories/owner/repo/diffstat/develop..main", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"values": [{"status": "merge conflict", "lines_added": 2, "lines_removed": 1, "new": {"path": "main.go"}}]}`))
+		})
+		server = httptest.NewServer(mux)
+		defer server.Close()
+
+		client := bitbucket.NewClient("token")
+		client.BaseURL = server.URL
+		provider := bitbucketController{Client: client}
+
+		c, err := provider.compareBranches(releaseEvent{RepoOwner: "owner", RepoName: "repo"}, "main", "develop")
+		if err != nil {
+			t.Fatalf("Compare should have succeeded, %v", err)
+		}
+		if len(c.Commits) != 2 || c.Commits[1].SHA != "bbb" || c.AheadBy != 2 || c.BehindBy != 0 {
+			t.Fatalf("Both pages of commits should have been returned, got %+v", c)
+		}
+		if len(c.Files) != 1 || c.Files[0].Filename != "main.go" || c.Mergeable != "conflicts" {
+			t.Fatalf("Conflicting main.go should have been returned, got %+v", c.Files)
+		}
+	})
+}
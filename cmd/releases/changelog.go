@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pathCommitsMargin is the number of commits listed by pathCommits beyond the number of compared commits
+const pathCommitsMargin = 100
+
+var errPathFilterUnsupported = errors.New("filtering commits by path is not supported on this provider")
+
+// pathFilterProvider is implemented by the providers which are able to list the commits which touch a
+// path, so that the release notes of a component of a monorepo only describe its own changes
+type pathFilterProvider interface {
+	// pathCommits returns the SHAs of the latest commits of ref which touch path, up to limit commits
+	pathCommits(e releaseEvent, ref, path string, limit int) (map[string]bool, error)
+}
+
+// conventionalCommitPattern matches a Conventional Commit header, e.g. feat(api)!: add endpoint
+var conventionalCommitPattern = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?: *(.+)$`)
+
+// mergedPullRequestPatterns match the merge commit messages written by each provider when a pull
+// request is merged, and capture the pull request's number and title
+var mergedPullRequestPatterns = []*regexp.Regexp{
+	// github
+	regexp.MustCompile(`^Merge pull request #(?P<number>\d+) from \S+\n\n(?P<title>[^\n]+)`),
+	// gitea
+	regexp.MustCompile(`^Merge pull request '(?P<title>[^\n]+)' \(#(?P<number>\d+)\) from `),
+	// bitbucket
+	regexp.MustCompile(`^Merged in \S+ \(pull request #(?P<number>\d+)\)\n\n(?P<title>[^\n]+)`),
+	// azure devops
+	regexp.MustCompile(`^Merged PR (?P<number>\d+): (?P<title>[^\n]+)`),
+	// gitlab
+	regexp.MustCompile(`(?s)^Merge branch '[^']+' into '[^']+'\n\n(?P<title>[^\n]+).*See merge request \S*!(?P<number>\d+)`),
+}
+
+// changelogSections are rendered in order, and map Conventional Commit types to their section
+var changelogSections = []struct {
+	Title string
+	Types []string
+}{
+	{Title: "Features", Types: []string{"feat"}},
+	{Title: "Bug Fixes", Types: []string{"fix"}},
+	{Title: "Chores", Types: []string{"build", "chore", "ci", "docs", "perf", "refactor", "revert", "style", "test"}},
+}
+
+// changelogEntry is a single line of the release notes, generated from a commit or merged pull request
+type changelogEntry struct {
+	Type        string
+	Scope       string
+	Description string
+	Breaking    bool
+	Reference   string
+}
+
+// parseChangelogEntry parses the Conventional Commit header of title. Titles which are not
+// Conventional Commits are kept as is, with an empty Type.
+func parseChangelogEntry(title, body, reference string) changelogEntry {
+	entry := changelogEntry{Description: strings.TrimSpace(title), Reference: reference}
+	if match := conventionalCommitPattern.FindStringSubmatch(entry.Description); match != nil {
+		entry.Type = strings.ToLower(match[1])
+		entry.Scope = match[2]
+		entry.Breaking = match[3] == "!"
+		entry.Description = match[4]
+	}
+
+	if strings.Contains(body, "BREAKING CHANGE:") || strings.Contains(body, "BREAKING-CHANGE:") {
+		entry.Breaking = true
+	}
+	return entry
+}
+
+// mergedPullRequest returns the title and reference of the pull request merged by a merge commit
+func mergedPullRequest(message, provider string) (string, string, bool) {
+	for _, pattern := range mergedPullRequestPatterns {
+		match := pattern.FindStringSubmatch(message)
+		if match == nil {
+			continue
+		}
+
+		title := match[pattern.SubexpIndex("title")]
+		number := match[pattern.SubexpIndex("number")]
+		if provider == "gitlab" {
+			return title, "!" + number, true
+		}
+		return title, "#" + number, true
+	}
+	return "", "", false
+}
+
+// changelogEntries returns an entry for each merged pull request and each commit which is not a merge
+func changelogEntries(c comparison, provider string) []changelogEntry {
+	entries := []changelogEntry{}
+	for _, commit := range c.Commits {
+		subject := strings.SplitN(commit.Message, "\n", 2)[0]
+
+		if title, reference, ok := mergedPullRequest(commit.Message, provider); ok {
+			entries = append(entries, parseChangelogEntry(title, commit.Message, reference))
+			continue
+		}
+
+		// NOTE(SMT): merges of one branch into another, and merge commits created by the dashboard, add
+		// nothing to the notes that their commits have not already added
+		if commit.Merge || strings.HasPrefix(subject, "Merge ") || strings.HasPrefix(subject, "Merging pull request") {
+			continue
+		}
+
+		sha := commit.SHA
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		entries = append(entries, parseChangelogEntry(subject, commit.Message, sha))
+	}
+	return entries
+}
+
+func (entry changelogEntry) String() string {
+	line := "- "
+	if entry.Scope != "" {
+		line += fmt.Sprintf("**%s:** ", entry.Scope)
+	}
+	line += entry.Description
+	if entry.Reference != "" {
+		line += fmt.Sprintf(" (%s)", entry.Reference)
+	}
+	return line
+}
+
+// renderChangelog renders Markdown release notes which group the compared commits and merged pull
+// requests by their Conventional Commit type
+func renderChangelog(c comparison, provider string) string {
+	entries := changelogEntries(c, provider)
+	if len(entries) == 0 {
+		return "No changes."
+	}
+
+	grouped := map[string][]changelogEntry{}
+	for _, entry := range entries {
+		section := "Other Changes"
+		if entry.Breaking {
+			section = "Breaking Changes"
+		} else {
+			for _, s := range changelogSections {
+				for _, t := range s.Types {
+					if entry.Type == t {
+						section = s.Title
+					}
+				}
+			}
+		}
+		grouped[section] = append(grouped[section], entry)
+	}
+
+	titles := []string{"Breaking Changes"}
+	for _, s := range changelogSections {
+		titles = append(titles, s.Title)
+	}
+	titles = append(titles, "Other Changes")
+
+	sections := []string{}
+	for _, title := range titles {
+		if len(grouped[title]) == 0 {
+			continue
+		}
+
+		lines := []string{"### " + title, ""}
+		for _, entry := range grouped[title] {
+			lines = append(lines, entry.String())
+		}
+		sections = append(sections, strings.Join(lines, "\n"))
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+// releaseRange returns the base and head compared to find the commits being released. Regular
+// releases compare BranchBase with BranchHead, while hotfixes compare the current version's tag with
+// BranchBase. ok is false for hotfixes of repositories which have not been released.
+func releaseRange(e releaseEvent, repo repository) (string, string, bool) {
+	if !e.Hotfix {
+		return e.BranchBase, e.BranchHead, true
+	}
+	if repo.CurrentVersion == "" {
+		return "", "", false
+	}
+	return repo.CurrentVersion, e.BranchBase, true
+}
+
+// suggestedBump returns the smallest bump which follows semantic versioning for the changes in the
+// release notes
+func suggestedBump(entries []changelogEntry) string {
+	bump := "patch"
+	for _, entry := range entries {
+		if entry.Breaking {
+			return "major"
+		} else if entry.Type == "feat" {
+			bump = "minor"
+		}
+	}
+	return bump
+}
+
+// filterComparison keeps the commits whose SHA is in shas and the files under path
+func filterComparison(c comparison, path string, shas map[string]bool) comparison {
+	filtered := comparison{BehindBy: c.BehindBy, Mergeable: c.Mergeable}
+	for _, commit := range c.Commits {
+		if shas[commit.SHA] {
+			filtered.Commits = append(filtered.Commits, commit)
+		}
+	}
+
+	path = strings.Trim(path, "/")
+	for _, file := range c.Files {
+		if path == "" || file.Filename == path || strings.HasPrefix(file.Filename, path+"/") {
+			filtered.Files = append(filtered.Files, file)
+		}
+	}
+	filtered.AheadBy = len(filtered.Commits)
+	return filtered
+}
+
+// compareRelease compares base with head. Releases of a component only include the commits and files
+// which touch the component's path.
+func compareRelease(provider releaseProvider, e releaseEvent, repo repository, base, head string) (comparison, error) {
+	c, err := provider.compareBranches(e, base, head)
+	if err != nil || repo.ComponentPath == "" {
+		return c, err
+	}
+
+	pp, ok := provider.(pathFilterProvider)
+	if !ok {
+		return c, errPathFilterUnsupported
+	}
+
+	// NOTE(SMT): every compared commit which touches the path is among the latest commits of head which
+	// touch it, so listing as many of them as were compared is enough. The margin covers commits of
+	// head which are already on base, e.g. after a back-merge.
+	shas, err := pp.pathCommits(e, head, repo.ComponentPath, len(c.Commits)+pathCommitsMargin)
+	if err != nil {
+		return c, err
+	}
+	return filterComparison(c, repo.ComponentPath, shas), nil
+}
+
+// generateReleaseNotes renders release notes from the commits being released. The notes are left
+// empty when the commits cannot be compared.
+func generateReleaseNotes(provider releaseProvider, e releaseEvent, repo repository) string {
+	base, head, ok := releaseRange(e, repo)
+	if !ok {
+		log.Info(fmt.Sprintf("%v has not been released, unable to generate hotfix release notes", e.RepoName))
+		return ""
+	}
+
+	c, err := compareRelease(provider, e, repo, base, head)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to generate %v release notes, %v", e.RepoName, err))
+		return ""
+	}
+	return renderChangelog(c, e.RepoProvider)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRenderChangelog(t *testing.T) {
+	t.Run("Successfully grouped commits by Conventional Commit type", func(t *testing.T) {
+		c := comparison{Commits: []commit{
+			{SHA: "1111111aaa", Message: "feat(api): add list endpoint"},
+			{SHA: "2222222bbb", Message: "fix: handle empty body"},
+			{SHA: "3333333ccc", Message: "chore: bump dependencies"},
+			{SHA: "4444444ddd", Message: "refactor!: drop legacy routes"},
+			{SHA: "5555555eee", Message: "update readme"},
+			{SHA: "6666666fff", Message: "feat: new config\n\nBREAKING CHANGE: config moved"},
+		}}
+
+		expected := "### Breaking Changes\n\n" +
+			"- drop legacy routes (4444444)\n" +
+			"- new config (6666666)\n\n" +
+			"### Features\n\n" +
+			"- **api:** add list endpoint (1111111)\n\n" +
+			"### Bug Fixes\n\n" +
+			"- handle empty body (2222222)\n\n" +
+			"### Chores\n\n" +
+			"- bump dependencies (3333333)\n\n" +
+			"### Other Changes\n\n" +
+			"- update readme (5555555)"
+		if notes := renderChangelog(c, "github"); notes != expected {
+			t.Fatalf("Unexpected release notes, got\n%v", notes)
+		}
+	})
+
+	t.Run("Successfully referenced merged pull requests", func(t *testing.T) {
+		tests := []struct {
+			Provider string
+			Message  string
+			Expected string
+		}{
+			{"github", "Merge pull request #12 from org/feature\n\nfeat: add thing", "### Features\n\n- add thing (#12)"},
+			{"gitea", "Merge pull request 'fix: broken thing' (#3) from feature into develop", "### Bug Fixes\n\n- broken thing (#3)"},
+			{"bitbucket", "Merged in feature (pull request #7)\n\nfeat: add thing", "### Features\n\n- add thing (#7)"},
+			{"azuredevops", "Merged PR 42: fix: broken thing", "### Bug Fixes\n\n- broken thing (#42)"},
+			{"gitlab", "Merge branch 'feature' into 'develop'\n\nfeat: add thing\n\nSee merge request group/project!9", "### Features\n\n- add thing (!9)"},
+		}
+
+		for _, test := range tests {
+			c := comparison{Commits: []commit{{SHA: "abcdef123", Message: test.Message, Merge: true}}}
+			if notes := renderChangelog(c, test.Provider); notes != test.Expected {
+				t.Fatalf("Unexpected %v release notes, got\n%v", test.Provider, notes)
+			}
+		}
+	})
+
+	t.Run("Merges without a pull request are skipped", func(t *testing.T) {
+		c := comparison{Commits: []commit{
+			{SHA: "abcdef123", Message: "Merge branch 'main' into develop", Merge: true},
+			{SHA: "abcdef456", Message: "Merging pull request number 4"},
+		}}
+
+		if notes := renderChangelog(c, "github"); notes != "No changes." {
+			t.Fatalf("Merge commits should have been skipped, got\n%v", notes)
+		}
+	})
+}
+
+func TestFilterComparison(t *testing.T) {
+	t.Run("Successfully kept the commits and files of the component", func(t *testing.T) {
+		c := comparison{
+			Commits: []commit{{SHA: "a"}, {SHA: "b"}, {SHA: "c"}},
+			Files: []changedFile{
+				{Filename: "services/billing/main.go"},
+				{Filename: "services/billing-v2/main.go"},
+				{Filename: "README.md"},
+			},
+			AheadBy:  3,
+			BehindBy: 1,
+		}
+
+		filtered := filterComparison(c, "services/billing/", map[string]bool{"a": true, "c": true})
+		if len(filtered.Commits) != 2 || filtered.Commits[0].SHA != "a" || filtered.Commits[1].SHA != "c" {
+			t.Fatalf("Only the commits which touch the path should have been kept, got %+v", filtered.Commits)
+		}
+		if len(filtered.Files) != 1 || filtered.Files[0].Filename != "services/billing/main.go" {
+			t.Fatalf("Only the files under the path should have been kept, got %+v", filtered.Files)
+		}
+		if filtered.AheadBy != 2 || filtered.BehindBy != 1 {
+			t.Fatalf("Filtered comparison should be ahead by its commits, got %+v", filtered)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultChecksTimeout is how long the release waits for pending checks when CHECKS_TIMEOUT_SECONDS is
+// not set. It leaves the rest of the Lambda timeout for merging and releasing.
+const defaultChecksTimeout = 5 * time.Second
+
+// checksPollInterval is how long the release waits between reading pending checks
+const checksPollInterval = time.Second
+
+// blockingChecks returns the checks which have not succeeded
+func blockingChecks(checks []check) (blocking []check, failed bool) {
+	for _, c := range checks {
+		if c.State == "success" {
+			continue
+		}
+		blocking = append(blocking, c)
+		if c.State == "failure" {
+			failed = true
+		}
+	}
+	return blocking, failed
+}
+
+// describeChecks lists checks by name and state for responses and notifications
+func describeChecks(checks []check) string {
+	described := []string{}
+	for _, c := range checks {
+		described = append(described, fmt.Sprintf("%v (%v)", c.Name, c.State))
+	}
+	return strings.Join(described, ", ")
+}
+
+// waitForChecks waits up to ChecksTimeout for the pending checks on the head of the pull request,
+// and returns the checks which block it from being merged. Checks are read at least once, and a
+// failed check stops the wait immediately.
+func (app application) waitForChecks(provider releaseProvider, e releaseEvent, pr pullRequest) ([]check, error) {
+	deadline := time.Now().Add(app.Config.ChecksTimeout)
+	for {
+		checks, err := provider.listChecks(e, pr)
+		if err != nil {
+			return nil, err
+		}
+
+		blocking, failed := blockingChecks(checks)
+		if len(blocking) == 0 || failed || !time.Now().Add(checksPollInterval).Before(deadline) {
+			return blocking, nil
+		}
+
+		log.Info(fmt.Sprintf("waiting for %v pull request %v checks %v...", e.RepoName, pr.Number, describeChecks(blocking)))
+		time.Sleep(checksPollInterval)
+	}
+}
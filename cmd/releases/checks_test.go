@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestReleaseChecks(t *testing.T) {
+	event := events.APIGatewayV2HTTPRequest{
+		RawPath: "/releases/create",
+		Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.0"}`,
+	}
+
+	t.Run("Successfully merged once checks passed", func(t *testing.T) {
+		provider := &fakeProvider{Checks: []check{{Name: "build", State: "success"}}}
+		app := newTestApplication(provider)
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 200 || len(provider.Merged) != 1 {
+			t.Fatalf("Release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Failed check blocks the merge", func(t *testing.T) {
+		provider := &fakeProvider{Checks: []check{
+			{Name: "build", State: "success"},
+			{Name: "lint", State: "failure"},
+			{Name: "e2e", State: "pending"},
+		}}
+		app := newTestApplication(provider)
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 400 || !strings.Contains(resp.Body, "lint (failure), e2e (pending)") || strings.Contains(resp.Body, "build") {
+			t.Fatalf("Release should have been blocked by lint and e2e, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.Merged) != 0 || len(provider.Releases) != 0 {
+			t.Fatal("Pull request should not have been merged")
+		}
+	})
+
+	t.Run("Pending check blocks the merge once the wait times out", func(t *testing.T) {
+		provider := &fakeProvider{Checks: []check{{Name: "e2e", State: "pending"}}}
+		app := newTestApplication(provider)
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 400 || !strings.Contains(resp.Body, "e2e (pending)") || len(provider.Merged) != 0 {
+			t.Fatalf("Release should have been blocked by e2e, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Blocked release resumes once checks pass", func(t *testing.T) {
+		provider := &fakeProvider{Checks: []check{{Name: "e2e", State: "failure"}}}
+		app := newTestApplication(provider)
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: map[string]map[string]*dynamodb.AttributeValue{}}
+
+		_, _ = app.handler(event)
+		provider.Checks = []check{{Name: "e2e", State: "success"}}
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 200 || len(provider.PullRequests) != 1 || len(provider.Merged) != 1 {
+			t.Fatalf("Release should have resumed, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+}
+
+func TestGithubChecks(t *testing.T) {
+	t.Run("Successfully listed statuses and check runs", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v3/repos/owner/repo/pulls/3", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"number": 3, "head": {"sha": "abc"}}`))
+		})
+		mux.HandleFunc("/api/v3/repos/owner/repo/commits/abc/status", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"state": "failure", "statuses": [{"context": "ci/build", "state": "success"}, {"context": "ci/deploy", "state": "error"}]}`))
+		})
+		mux.HandleFunc("/api/v3/repos/owner/repo/commits/abc/check-runs", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"total_count": 3, "check_runs": [
+				{"name": "lint", "status": "completed", "conclusion": "neutral"},
+				{"name": "test", "status": "completed", "conclusion": "timed_out"},
+				{"name": "e2e", "status": "in_progress"}
+			]}`))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		e := releaseEvent{RepoOwner: "owner", RepoName: "repo", RepoProvider: "github"}
+		provider, _ := newGithubController(e, repository{BaseURL: server.URL}, "token")
+		checks, err := provider.listChecks(e, pullRequest{Number: 3})
+		if err != nil {
+			t.Fatalf("Checks should have been listed, %v", err)
+		}
+
+		blocking, failed := blockingChecks(checks)
+		if len(checks) != 5 || !failed || describeChecks(blocking) != "ci/deploy (failure), test (failure), e2e (pending)" {
+			t.Fatalf("ci/deploy, test and e2e should have been blocking, got %+v", checks)
+		}
+	})
+}
+
+func TestGitlabChecks(t *testing.T) {
+	t.Run("Successfully listed blocking pipeline jobs", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v4/projects/1/merge_requests/3", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"iid": 3, "head_pipeline": {"id": 9, "status": "failed"}}`))
+		})
+		mux.HandleFunc("/api/v4/projects/1/pipelines/9/jobs", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[
+				{"name": "build", "status": "success"},
+				{"name": "flaky", "status": "failed", "allow_failure": true},
+				{"name": "test", "status": "failed"},
+				{"name": "deploy", "status": "created"}
+			]`))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		e := releaseEvent{RepoName: "repo", RepoProvider: "gitlab", GitlabProjectID: "1"}
+		provider, _ := newGitlabController(e, repository{BaseURL: server.URL}, "token")
+		checks, err := provider.listChecks(e, pullRequest{Number: 3})
+		if err != nil {
+			t.Fatalf("Checks should have been listed, %v", err)
+		}
+		if describeChecks(checks) != "test (failure), deploy (pending)" {
+			t.Fatalf("test and deploy should have been blocking, got %+v", checks)
+		}
+	})
+
+	t.Run("Merge request without a pipeline has no checks", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v4/projects/1/merge_requests/3", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"iid": 3}`))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		e := releaseEvent{RepoName: "repo", RepoProvider: "gitlab", GitlabProjectID: "1"}
+		provider, _ := newGitlabController(e, repository{BaseURL: server.URL}, "token")
+		checks, err := provider.listChecks(e, pullRequest{Number: 3})
+		if err != nil || len(checks) != 0 {
+			t.Fatalf("Merge request should not have had checks, got %+v %v", checks, err)
+		}
+	})
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/seanturner026/moot/internal/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// runRelease executes the provider agnostic release workflow. Regular releases merge BranchHead into
+// BranchBase through a pull request before releasing, while hotfixes release BranchBase directly and
+// release candidates release BranchHead directly.
+// Steps which have already completed according to state are skipped, and state is saved after each
+// step.
+func (app application) runRelease(provider releaseProvider, e releaseEvent, state *releaseState) (string, int) {
+	if !e.Hotfix && !e.Prerelease {
+		pr := pullRequest{Number: state.PullRequestNumber, URL: state.PullRequestURL}
+		if !state.done("pr_opened") {
+			var err error
+			pr, err = provider.createPullRequest(e)
+			if err != nil {
+				message := fmt.Sprintf("Could not create %v pull request for %v version %v, please check %v for further details.",
+					e.RepoProvider,
+					e.RepoName,
+					e.ReleaseVersion,
+					e.RepoProvider)
+				statusCode := 400
+				return message, statusCode
+			}
+			state.PullRequestNumber = pr.Number
+			state.PullRequestURL = pr.URL
+			app.completeStep(state, "pr_opened")
+		}
+
+		if !state.done("mergeable") {
+			err := provider.waitForMergeable(e, pr)
+			if err != nil {
+				message := fmt.Sprintf("%v pull request %v for %v version %v cannot be merged, %v. Resolve it on %v and deploy again to resume the release.",
+					e.RepoProvider,
+					pr.Number,
+					e.RepoName,
+					e.ReleaseVersion,
+					err,
+					e.RepoProvider)
+				statusCode := 400
+				return message, statusCode
+			}
+			app.completeStep(state, "mergeable")
+		}
+
+		if !state.done("checks_passed") {
+			blocking, err := app.waitForChecks(provider, e, pr)
+			if err != nil {
+				message := fmt.Sprintf("Unable to read the checks of %v pull request %v for %v version %v, please check %v for further details.",
+					e.RepoProvider,
+					pr.Number,
+					e.RepoName,
+					e.ReleaseVersion,
+					e.RepoProvider)
+				statusCode := 400
+				return message, statusCode
+			}
+			if len(blocking) > 0 {
+				message := fmt.Sprintf("%v pull request %v for %v version %v is blocked by checks %v. Deploy again to resume the release once they have passed.",
+					e.RepoProvider,
+					pr.Number,
+					e.RepoName,
+					e.ReleaseVersion,
+					describeChecks(blocking))
+				statusCode := 400
+				return message, statusCode
+			}
+			app.completeStep(state, "checks_passed")
+		}
+
+		if !state.done("merged") {
+			sha, err := provider.mergePullRequest(e, pr)
+			if err != nil {
+				message := fmt.Sprintf("API request to merge %v pull request %v for %v version %v failed, please check the pull request on %v for further details.",
+					e.RepoProvider,
+					pr.Number,
+					e.RepoName,
+					e.ReleaseVersion,
+					e.RepoProvider)
+				statusCode := 400
+				return message, statusCode
+			}
+			state.MergeSHA = sha
+			app.completeStep(state, "merged")
+		}
+	}
+
+	if !state.done("tagged") {
+		var err error
+		if e.Draft {
+			state.DraftReleaseID, err = createDraftRelease(provider, e)
+		} else {
+			err = provider.createRelease(e)
+		}
+		if err != nil {
+			message := fmt.Sprintf("Unable to create %v release version %v on %v.",
+				e.RepoName,
+				e.ReleaseVersion,
+				e.RepoProvider)
+			statusCode := 400
+			return message, statusCode
+		}
+		app.completeStep(state, "tagged")
+	}
+
+	if e.Draft {
+		message := fmt.Sprintf("Created draft %v release version %v on %v, publish it through /releases/publish.",
+			e.RepoName,
+			e.ReleaseVersion,
+			e.RepoProvider)
+		statusCode := 200
+		return message, statusCode
+	}
+
+	message := fmt.Sprintf("Created %v release version %v on %v.",
+		e.RepoName,
+		e.ReleaseVersion,
+		e.RepoProvider)
+	statusCode := 200
+	return message, statusCode
+}
+
+func (app application) releasesCreateHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	e := releaseEvent{}
+	err := json.Unmarshal([]byte(event.Body), &e)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+	}
+
+	// NOTE(SMT): /releases/create/{provider} predates repo_provider being sent in the body
+	if e.RepoProvider == "" {
+		e.RepoProvider = strings.TrimPrefix(event.RawPath, "/releases/create/")
+	}
+
+	message, statusCode := "", 0
+	if app.Config.RequiredApprovals > 0 {
+		message = fmt.Sprintf("Unable to release %s version %s, releases require %d approvals, request one through /releases/request", e.RepoName, e.ReleaseVersion, app.Config.RequiredApprovals)
+		statusCode = 403
+	} else {
+		message, statusCode = app.release(event, e)
+	}
+
+	if id, ok := scheduledReleaseID(event); ok {
+		err = app.AWS.completeScheduledRelease(id, message, statusCode)
+		if err != nil {
+			log.Error(fmt.Sprintf("unable to record outcome of scheduled release %v", id))
+		}
+	}
+	return message, statusCode
+}
+
+// release runs the release workflow for e, on behalf of the user who made the request
+func (app application) release(event events.APIGatewayV2HTTPRequest, e releaseEvent) (string, int) {
+	newProvider, ok := app.Providers[e.RepoProvider]
+	if !ok {
+		log.Error(fmt.Sprintf("provider %v is not supported", e.RepoProvider))
+		message := fmt.Sprintf("Unable to release %s version %s, provider %s is not supported", e.RepoName, e.ReleaseVersion, e.RepoProvider)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	repo, err := app.AWS.getRepository(e)
+	if err == errRepositoryNotFound {
+		message := fmt.Sprintf("Unable to release %s version %s, repository has not been onboarded for %s", e.RepoName, e.ReleaseVersion, e.RepoProvider)
+		statusCode := 404
+		return message, statusCode
+	} else if err == errComponentNotFound {
+		message := fmt.Sprintf("Unable to release %s version %s, component %s has not been onboarded", e.RepoName, e.ReleaseVersion, e.Component)
+		statusCode := 404
+		return message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to release %s version %s, could not read repository from backend", e.RepoName, e.ReleaseVersion)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	lockID := event.RequestContext.RequestID
+	if lockID == "" {
+		lockID = fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	lock, err := app.AWS.acquireReleaseLock(newReleaseLock(e, lockID, requestActor(event), time.Now(), app.Config.LambdaTimeout+releaseLockMargin), time.Now())
+	if err == errReleaseLocked {
+		message := fmt.Sprintf("Unable to release %s, a release is already in progress by %s since %s", e.RepoName, lock.Holder, lock.AcquiredAt)
+		statusCode := 409
+		return message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to release %s, could not lock repository in backend", e.RepoName)
+		statusCode := 400
+		return message, statusCode
+	}
+	defer app.AWS.releaseReleaseLock(lock)
+
+	// NOTE(SMT): a release resumed by its idempotency key keeps the version it was started with, so that a
+	// bump is not applied twice
+	key := requestIdempotencyKey(event, e)
+	state, err := releaseState{}, errReleaseStateNotFound
+	if key != "" {
+		state, err = app.AWS.getReleaseState(e, key)
+	}
+
+	if err == errReleaseStateNotFound {
+		version, versionErr := resolveReleaseVersion(e, repo)
+		if versionErr != nil {
+			log.Error(fmt.Sprintf("invalid release version for %v, %v", e.RepoName, versionErr))
+			message := fmt.Sprintf("Unable to release %s, %v", e.RepoName, versionErr)
+			statusCode := 400
+			return message, statusCode
+		}
+		e.ReleaseVersion = version
+
+		if key == "" {
+			key = e.ReleaseVersion
+			state, err = app.AWS.getReleaseState(e, key)
+		}
+	}
+
+	resumed := err == nil
+	if err != nil && err != errReleaseStateNotFound {
+		message := fmt.Sprintf("Unable to release %s version %s, could not read release state from backend", e.RepoName, e.ReleaseVersion)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	if resumed {
+		if state.done("version_recorded") || (state.Draft && state.done("notified")) {
+			message := fmt.Sprintf("Release %v version %v has already been completed.", e.RepoName, state.ReleaseVersion)
+			statusCode := 200
+			return message, statusCode
+		}
+
+		log.Info(fmt.Sprintf("resuming %v release %v after step %v...", e.RepoName, state.ReleaseVersion, state.Step))
+		e.ReleaseVersion = state.ReleaseVersion
+		e.Hotfix = state.Hotfix
+		e.Prerelease = state.Prerelease
+		e.Draft = state.Draft
+		if strings.TrimSpace(e.ReleaseBody) == "" {
+			e.ReleaseBody = state.ReleaseBody
+		}
+	}
+
+	// NOTE(SMT): only hotfixes and rollbacks may be released during a freeze, and only when an admin
+	// overrides it
+	freeze, err := app.AWS.activeFreeze(e, time.Now())
+	if err != nil {
+		message := fmt.Sprintf("Unable to release %s version %s, could not read freeze windows from backend", e.RepoName, e.ReleaseVersion)
+		statusCode := 400
+		return message, statusCode
+	}
+	if freeze != "" {
+		if (!e.Hotfix && e.Rollback == "") || !e.FreezeOverride {
+			message := fmt.Sprintf("Unable to release %s version %s, %s", e.RepoName, e.ReleaseVersion, freeze)
+			statusCode := 403
+			return message, statusCode
+		}
+		if !requestIsAdmin(event) {
+			message := fmt.Sprintf("Unable to release %s version %s, only admins may override a freeze", e.RepoName, e.ReleaseVersion)
+			statusCode := 403
+			return message, statusCode
+		}
+		log.Info(fmt.Sprintf("%v overrode freeze for %v hotfix %v, %v", requestActor(event), e.RepoName, e.ReleaseVersion, freeze))
+	}
+
+	token, err := app.getProviderToken(e, repo)
+	if err != nil {
+		message := fmt.Sprintf("Unable to release %s version %s, please double check the %s token", e.RepoName, e.ReleaseVersion, e.RepoProvider)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	provider, err := newProvider(e, repo, token)
+	if err != nil {
+		message := fmt.Sprintf("Unable to release %s version %s, could not create %s client, %v", e.RepoName, e.ReleaseVersion, e.RepoProvider, err)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	if e.Rollback != "" {
+		e, err = app.prepareRollback(provider, e, repo, resumed)
+		if err != nil {
+			message := fmt.Sprintf("Unable to roll back %s to %s, %v", e.RepoName, e.Rollback, err)
+			statusCode := 400
+			return message, statusCode
+		}
+	}
+
+	if e.Prerelease {
+		e.ReleaseTarget = e.BranchHead
+	}
+
+	if _, ok := provider.(draftProvider); e.Draft && !ok {
+		message := fmt.Sprintf("Unable to release %s version %s, %v", e.RepoName, e.ReleaseVersion, errDraftsUnsupported)
+		statusCode := 400
+		return message, statusCode
+	}
+	if _, ok := provider.(artifactProvider); len(e.Artifacts) > 0 && !ok {
+		message := fmt.Sprintf("Unable to release %s version %s, %v", e.RepoName, e.ReleaseVersion, errArtifactsUnsupported)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	// NOTE(SMT): artifacts are fetched before anything changes on the provider, so that their checksums can
+	// be listed in the release notes
+	var artifacts []releasedArtifact
+	var contents map[string][]byte
+	if !resumed && len(e.Artifacts) > 0 {
+		artifacts, contents, err = app.fetchArtifacts(e.Artifacts)
+		if err != nil {
+			message := fmt.Sprintf("Unable to release %s version %s, %v", e.RepoName, e.ReleaseVersion, err)
+			statusCode := 400
+			return message, statusCode
+		}
+	}
+
+	if !resumed {
+		if strings.TrimSpace(e.ReleaseBody) == "" {
+			e.ReleaseBody = generateReleaseNotes(provider, e, repo)
+		}
+		if len(artifacts) > 0 {
+			e.ReleaseBody = appendChecksums(e.ReleaseBody, artifacts)
+		}
+		state = newReleaseState(e, key)
+		state.Artifacts = artifacts
+		app.completeStep(&state, "started")
+	}
+
+	record := newReleaseRecord(e, requestActor(event), time.Now())
+	record.FreezeOverride = freeze
+	record.RollbackTo = e.Rollback
+	record.TrainID = e.Train
+	message, statusCode := app.runRelease(provider, e, &state)
+	if statusCode == 200 && len(state.Artifacts) > 0 && !state.done("artifacts_uploaded") {
+		err = app.uploadArtifacts(provider, e, &state, contents)
+		if err != nil {
+			message = fmt.Sprintf("Created %v release version %v on %v, but %v. Deploy again to resume the release.",
+				e.RepoName,
+				e.ReleaseVersion,
+				e.RepoProvider,
+				err)
+			statusCode = 400
+		} else {
+			app.completeStep(&state, "artifacts_uploaded")
+		}
+	}
+	if statusCode == 200 && e.Hotfix && e.Rollback == "" {
+		if !state.done("back_merged") {
+			pr, note := app.backMerge(provider, e, repo.AutoBackMerge)
+			state.BackMergeURL = pr.URL
+			app.completeStep(&state, "back_merged")
+			message = fmt.Sprintf("%v %v", message, note)
+		} else if state.BackMergeURL != "" {
+			message = fmt.Sprintf("%v Back-merge pull request %v.", message, state.BackMergeURL)
+		}
+	}
+	if statusCode == 200 && e.Promote != "" && !state.done("candidate_published") {
+		if pp, ok := provider.(prereleaseProvider); ok {
+			tag := e.Promote
+			if !strings.HasPrefix(tag, repo.TagPrefix) {
+				tag = repo.TagPrefix + tag
+			}
+			err = pp.publishCandidate(e, tag)
+			if err != nil {
+				message = fmt.Sprintf("%v Release candidate %v is still listed as upcoming, %v.", message, tag, err)
+			}
+		}
+		app.completeStep(&state, "candidate_published")
+	}
+	record.BackMergeURL = state.BackMergeURL
+	record.PullRequestNumber = state.PullRequestNumber
+	record.PullRequestURL = state.PullRequestURL
+	record.MergeSHA = state.MergeSHA
+	record.Artifacts = state.Artifacts
+	if e.Draft {
+		record.DraftReleaseID = state.DraftReleaseID
+		record.ReleaseBody = e.ReleaseBody
+	}
+	record.complete(message, statusCode, time.Now())
+	err = app.AWS.putReleaseRecord(record)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to record %v release %v in history", e.RepoName, e.ReleaseVersion))
+	}
+	if statusCode != 200 {
+		return message, statusCode
+	}
+
+	// NOTE(SMT): drafts are announced when they are published, and releases of a train in its summary
+	if !state.done("notified") {
+		if app.Config.SlackWebhookURL != "" && !e.Draft && e.Train == "" {
+			slackMessage := fmt.Sprintf("Starting release for %v version %v...\n\n%v", e.RepoName, e.ReleaseVersion, e.ReleaseBody)
+			if e.Prerelease {
+				slackMessage = fmt.Sprintf("Starting release candidate for %v version %v...\n\n%v", e.RepoName, e.ReleaseVersion, e.ReleaseBody)
+			} else if e.Promote != "" {
+				slackMessage = fmt.Sprintf("Promoting %v release candidate %v to version %v...\n\n%v", e.RepoName, e.Promote, e.ReleaseVersion, e.ReleaseBody)
+			} else if e.Rollback != "" {
+				slackMessage = fmt.Sprintf("Rolling %v back to %v with release %v...\n\n%v", e.RepoName, e.Rollback, e.ReleaseVersion, e.ReleaseBody)
+			}
+			if state.BackMergeURL != "" {
+				slackMessage = fmt.Sprintf("%v\n\nBack-merge pull request: %v", slackMessage, state.BackMergeURL)
+			}
+			err = util.PostToSlack(app.Config.SlackWebhookURL, slackMessage)
+			if err != nil {
+				message := fmt.Sprintf("Released %v version %v successfully, unable to send slack notification and update latest version in backend", e.RepoName, e.ReleaseVersion)
+				statusCode := 200
+				return message, statusCode
+			}
+		}
+		app.completeStep(&state, "notified")
+	}
+
+	// NOTE(SMT): a draft becomes the latest version when it is published, see releasesPublishHandler
+	if e.Draft {
+		return message, statusCode
+	}
+
+	err = app.AWS.updateCurrentVersion(e)
+	if err != nil {
+		message := fmt.Sprintf("Released %v version %v successfully, unable to update latest version in backend", e.RepoName, e.ReleaseVersion)
+		statusCode := 200
+		return message, statusCode
+	}
+	app.completeStep(&state, "version_recorded")
+
+	return message, statusCode
+}
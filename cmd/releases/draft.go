@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	log "github.com/sirupsen/logrus"
+)
+
+var errDraftsUnsupported = errors.New("draft releases are not supported on this provider")
+
+var errDraftNotFound = errors.New("draft release does not exist")
+
+// draftProvider is implemented by the providers which are able to stage a release before publishing
+// it. Draft releases are identified by the id returned by createDraftRelease.
+type draftProvider interface {
+	createDraftRelease(e releaseEvent) (string, error)
+	publishRelease(e releaseEvent, id string) error
+	// updateDraftRelease replaces the notes of the draft release with ReleaseBody
+	updateDraftRelease(e releaseEvent, id string) error
+}
+
+// createDraftRelease creates the release of e as a draft, and returns its id on the provider
+func createDraftRelease(provider releaseProvider, e releaseEvent) (string, error) {
+	dp, ok := provider.(draftProvider)
+	if !ok {
+		return "", errDraftsUnsupported
+	}
+	return dp.createDraftRelease(e)
+}
+
+// getDraftRecord returns the release history record of the unpublished draft release of e's version
+func (app awsController) getDraftRecord(e releaseEvent) (releaseRecord, error) {
+	r, ok, err := app.findRelease(e.RepoProvider, e.RepoName, e.ReleaseVersion, func(r releaseRecord) bool {
+		return r.Draft && r.DraftReleaseID != "" && r.Outcome == "succeeded"
+	})
+	if err != nil {
+		return releaseRecord{}, err
+	}
+	if !ok {
+		return releaseRecord{}, errDraftNotFound
+	}
+	return r, nil
+}
+
+// openDraft reads the draft release of the version in the request body along with a client for its
+// provider. The status code is 200 when the draft can be changed, otherwise message describes why not.
+func (app application) openDraft(event events.APIGatewayV2HTTPRequest, action string) (releaseEvent, releaseRecord, draftProvider, string, int) {
+	e := releaseEvent{}
+	err := json.Unmarshal([]byte(event.Body), &e)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+	}
+
+	newProvider, ok := app.Providers[e.RepoProvider]
+	if !ok {
+		message := fmt.Sprintf("Unable to %s %s version %s, provider %s is not supported", action, e.RepoName, e.ReleaseVersion, e.RepoProvider)
+		statusCode := 400
+		return e, releaseRecord{}, nil, message, statusCode
+	}
+
+	repo, err := app.AWS.getRepository(e)
+	if err == errRepositoryNotFound {
+		message := fmt.Sprintf("Unable to %s %s version %s, repository has not been onboarded for %s", action, e.RepoName, e.ReleaseVersion, e.RepoProvider)
+		statusCode := 404
+		return e, releaseRecord{}, nil, message, statusCode
+	} else if err == errComponentNotFound {
+		message := fmt.Sprintf("Unable to %s %s version %s, component %s has not been onboarded", action, e.RepoName, e.ReleaseVersion, e.Component)
+		statusCode := 404
+		return e, releaseRecord{}, nil, message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to %s %s version %s, could not read repository from backend", action, e.RepoName, e.ReleaseVersion)
+		statusCode := 400
+		return e, releaseRecord{}, nil, message, statusCode
+	}
+
+	version, err := parseSemver(e.ReleaseVersion, repo.TagPrefix)
+	if err != nil {
+		message := fmt.Sprintf("Unable to %s %s, %v", action, e.RepoName, err)
+		statusCode := 400
+		return e, releaseRecord{}, nil, message, statusCode
+	}
+	e.ReleaseVersion = repo.TagPrefix + version.String()
+
+	record, err := app.AWS.getDraftRecord(e)
+	if err == errDraftNotFound {
+		message := fmt.Sprintf("Unable to %s %s version %s, it has not been drafted or has already been published", action, e.RepoName, e.ReleaseVersion)
+		statusCode := 404
+		return e, record, nil, message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to %s %s version %s, could not read release history from backend", action, e.RepoName, e.ReleaseVersion)
+		statusCode := 400
+		return e, record, nil, message, statusCode
+	}
+
+	// NOTE(SMT): drafts are changed on the onboarded repository, whichever branches they were released from
+	if e.RepoOwner == "" {
+		e.RepoOwner = repo.RepoOwner
+	}
+	if e.GitlabProjectID == "" {
+		e.GitlabProjectID = repo.GitlabProjectID
+	}
+
+	token, err := app.getProviderToken(e, repo)
+	if err != nil {
+		message := fmt.Sprintf("Unable to %s %s version %s, please double check the %s token", action, e.RepoName, e.ReleaseVersion, e.RepoProvider)
+		statusCode := 400
+		return e, record, nil, message, statusCode
+	}
+
+	provider, err := newProvider(e, repo, token)
+	if err != nil {
+		message := fmt.Sprintf("Unable to %s %s version %s, could not create %s client, %v", action, e.RepoName, e.ReleaseVersion, e.RepoProvider, err)
+		statusCode := 400
+		return e, record, nil, message, statusCode
+	}
+
+	dp, ok := provider.(draftProvider)
+	if !ok {
+		message := fmt.Sprintf("Unable to %s %s version %s, %v", action, e.RepoName, e.ReleaseVersion, errDraftsUnsupported)
+		statusCode := 400
+		return e, record, nil, message, statusCode
+	}
+	return e, record, dp, "", 200
+}
+
+// releasesPublishHandler publishes a draft release, which tags it on Github. The release is announced,
+// and becomes the latest version of the repository, when it is published rather than when it was
+// drafted.
+func (app application) releasesPublishHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	if app.Config.RequiredApprovals > 0 && !requestIsAdmin(event) {
+		message := fmt.Sprintf("Unable to publish release, releases require %d approvals, so only admins may publish drafts", app.Config.RequiredApprovals)
+		statusCode := 403
+		return message, statusCode
+	}
+
+	e, record, provider, message, statusCode := app.openDraft(event, "publish")
+	if statusCode != 200 {
+		return message, statusCode
+	}
+
+	freeze, err := app.AWS.activeFreeze(e, time.Now())
+	if err != nil {
+		message := fmt.Sprintf("Unable to publish %s version %s, could not read freeze windows from backend", e.RepoName, e.ReleaseVersion)
+		statusCode := 400
+		return message, statusCode
+	}
+	if freeze != "" {
+		message := fmt.Sprintf("Unable to publish %s version %s, %s", e.RepoName, e.ReleaseVersion, freeze)
+		statusCode := 403
+		return message, statusCode
+	}
+
+	e.ReleaseBody = record.ReleaseBody
+	err = provider.publishRelease(e, record.DraftReleaseID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to publish %s version %s on %s.", e.RepoName, e.ReleaseVersion, e.RepoProvider)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	record.Draft = false
+	record.PublishedBy = requestActor(event)
+	record.PublishedAt = time.Now().UTC().Format(historyTimeFormat)
+	err = app.AWS.putReleaseRecord(record)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to record %v release %v as published in history", e.RepoName, e.ReleaseVersion))
+	}
+
+	app.notify(fmt.Sprintf("Published release for %v version %v...\n\n%v", e.RepoName, e.ReleaseVersion, record.ReleaseBody))
+
+	e.Component = record.Component
+	e.Prerelease = record.Prerelease
+	err = app.AWS.updateCurrentVersion(e)
+	if err != nil {
+		message := fmt.Sprintf("Published %v release version %v on %v, unable to update latest version in backend", e.RepoName, e.ReleaseVersion, e.RepoProvider)
+		statusCode := 200
+		return message, statusCode
+	}
+
+	message = fmt.Sprintf("Published %v release version %v on %v.", e.RepoName, e.ReleaseVersion, e.RepoProvider)
+	statusCode = 200
+	return message, statusCode
+}
+
+// releasesDraftUpdateHandler replaces the notes of a draft release on the provider and in the release
+// history
+func (app application) releasesDraftUpdateHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	e, record, provider, message, statusCode := app.openDraft(event, "update the notes of")
+	if statusCode != 200 {
+		return message, statusCode
+	}
+
+	if strings.TrimSpace(e.ReleaseBody) == "" {
+		message := fmt.Sprintf("Unable to update the notes of %s version %s, release_body is empty", e.RepoName, e.ReleaseVersion)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	err := provider.updateDraftRelease(e, record.DraftReleaseID)
+	if err != nil {
+		message := fmt.Sprintf("Unable to update the notes of %s version %s on %s.", e.RepoName, e.ReleaseVersion, e.RepoProvider)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	record.ReleaseBody = e.ReleaseBody
+	err = app.AWS.putReleaseRecord(record)
+	if err != nil {
+		message := fmt.Sprintf("Updated the notes of %s version %s on %s, unable to update release history in backend", e.RepoName, e.ReleaseVersion, e.RepoProvider)
+		statusCode := 200
+		return message, statusCode
+	}
+
+	message = fmt.Sprintf("Updated the notes of %v draft release version %v on %v.", e.RepoName, e.ReleaseVersion, e.RepoProvider)
+	statusCode = 200
+	return message, statusCode
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// putDraftRecord writes the history record of an unpublished draft of version to items
+func putDraftRecord(items map[string]map[string]*dynamodb.AttributeValue, version string) {
+	record, _ := dynamodbattribute.MarshalMap(releaseRecord{
+		PK:             historyPartitionKey("fake", "test"),
+		SK:             "2021-12-25T06:00:00.000Z#" + version,
+		RepoProvider:   "fake",
+		RepoName:       "test",
+		ReleaseVersion: version,
+		Draft:          true,
+		DraftReleaseID: version,
+		ReleaseBody:    "notes",
+		Outcome:        "succeeded",
+	})
+	items[mockItemKey(record)] = record
+}
+
+func TestDraftRelease(t *testing.T) {
+	t.Run("Successfully released as a draft", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, updates, items := newRepositoryApplication(provider, repository{CurrentVersion: "1.2.0"})
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "repo_owner": "test", "branch_base": "main", "branch_head": "develop", "release_version": "1.3.0", "release_body": "notes", "draft": true}`,
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Draft release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.Drafts) != 1 || len(provider.Releases) != 0 || len(provider.Merged) != 1 {
+			t.Fatalf("Pull request should have been merged and 1.3.0 drafted, got drafts %v releases %v", provider.Drafts, provider.Releases)
+		}
+		if len(*updates) != 0 {
+			t.Fatal("Drafted version should not be recorded as the current version until it is published")
+		}
+
+		resp, _ = app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "repo_owner": "test", "branch_base": "main", "branch_head": "develop", "release_version": "1.3.0", "release_body": "notes", "draft": true}`,
+		})
+		if resp.StatusCode != 200 || len(provider.Drafts) != 1 || len(*updates) != 0 {
+			t.Fatalf("Resubmitted draft should have been reported as completed, got %v %v", resp.StatusCode, resp.Body)
+		}
+
+		records := historyRecords(items)
+		if len(records) != 1 || !records[0].Draft || records[0].DraftReleaseID != "1.3.0" || records[0].ReleaseBody != "notes" {
+			t.Fatalf("Draft should have been recorded in history, got %+v", records)
+		}
+	})
+}
+
+func TestPublish(t *testing.T) {
+	event := events.APIGatewayV2HTTPRequest{
+		RawPath: "/releases/publish",
+		Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.3.0"}`,
+	}
+
+	t.Run("Successfully published a draft release", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, updates, items := newRepositoryApplication(provider, repository{CurrentVersion: "1.2.0"})
+		putDraftRecord(items, "1.3.0")
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 200 {
+			t.Fatalf("Publish should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.Published) != 1 || provider.Published[0] != "1.3.0" {
+			t.Fatalf("Draft 1.3.0 should have been published, got %v", provider.Published)
+		}
+		if len(*updates) != 1 || *(*updates)[0].ExpressionAttributeValues[":cv"].S != "1.3.0" {
+			t.Fatal("Published version should have been recorded as the current version")
+		}
+
+		records := historyRecords(items)
+		if len(records) != 1 || records[0].Draft || records[0].PublishedAt == "" {
+			t.Fatalf("Release should have been recorded as published, got %+v", records)
+		}
+
+		resp, _ = app.handler(event)
+		if resp.StatusCode != 404 || len(provider.Published) != 1 {
+			t.Fatalf("Published release should not have been published again, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Only admins may publish when releases require approvals", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, _, items := newRepositoryApplication(provider, repository{CurrentVersion: "1.3.0"})
+		app.Config.RequiredApprovals = 1
+		putDraftRecord(items, "1.3.0")
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 403 || len(provider.Published) != 0 {
+			t.Fatalf("Publish should have been forbidden, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+}
+
+func TestDraftUpdate(t *testing.T) {
+	t.Run("Successfully updated the notes of a draft release", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, _, items := newRepositoryApplication(provider, repository{CurrentVersion: "v1.3.0", TagPrefix: "v"})
+		putDraftRecord(items, "v1.3.0")
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/draft/update",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.3.0", "release_body": "better notes"}`,
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Update should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if provider.DraftBodies["v1.3.0"] != "better notes" {
+			t.Fatalf("Draft notes should have been updated on the provider, got %v", provider.DraftBodies)
+		}
+		if records := historyRecords(items); len(records) != 1 || records[0].ReleaseBody != "better notes" || !records[0].Draft {
+			t.Fatalf("Draft notes should have been updated in history, got %+v", records)
+		}
+	})
+
+	t.Run("Empty notes are rejected", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, _, items := newRepositoryApplication(provider, repository{CurrentVersion: "1.3.0"})
+		putDraftRecord(items, "1.3.0")
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/draft/update",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.3.0", "release_body": " "}`,
+		})
+		if resp.StatusCode != 400 || len(provider.DraftBodies) != 0 {
+			t.Fatalf("Update should have been rejected, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+}
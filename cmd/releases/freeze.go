@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	// NOTE(SMT): the go1.x Lambda runtime does not guarantee a time zone database for freeze windows
+	_ "time/tzdata"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	log "github.com/sirupsen/logrus"
+)
+
+// adminGroup is the Cognito group whose members may manage freezes and override them for hotfixes
+const adminGroup = "admin"
+
+// freezeClockFormat is the time of day at which a weekly freeze window starts or ends
+const freezeClockFormat = "15:04"
+
+// freezeWindow blocks releases of every repository, or of a single repository when RepoName is set.
+// A window is either the date range Start to End, or recurs weekly on Days between StartTime and
+// EndTime in TimeZone. Weekly windows without times last the whole day.
+type freezeWindow struct {
+	PK           string   `dynamodbav:"PK"                     json:"-"`
+	SK           string   `dynamodbav:"SK"                     json:"-"`
+	Name         string   `dynamodbav:"Name"                   json:"name"`
+	RepoProvider string   `dynamodbav:"RepoProvider,omitempty" json:"repo_provider,omitempty"`
+	RepoName     string   `dynamodbav:"RepoName,omitempty"     json:"repo_name,omitempty"`
+	Reason       string   `dynamodbav:"Reason,omitempty"       json:"reason,omitempty"`
+	Start        string   `dynamodbav:"Start,omitempty"        json:"start,omitempty"`
+	End          string   `dynamodbav:"End,omitempty"          json:"end,omitempty"`
+	Days         []string `dynamodbav:"Days,omitempty"         json:"days,omitempty"`
+	StartTime    string   `dynamodbav:"StartTime,omitempty"    json:"start_time,omitempty"`
+	EndTime      string   `dynamodbav:"EndTime,omitempty"      json:"end_time,omitempty"`
+	TimeZone     string   `dynamodbav:"TimeZone,omitempty"     json:"time_zone,omitempty"`
+	CreatedBy    string   `dynamodbav:"CreatedBy,omitempty"    json:"created_by,omitempty"`
+}
+
+// releaseHalt is the global switch which stops every release until it is lifted
+type releaseHalt struct {
+	PK        string `dynamodbav:"PK"                  json:"-"`
+	SK        string `dynamodbav:"SK"                  json:"-"`
+	Halted    bool   `dynamodbav:"Halted"              json:"halted"`
+	Reason    string `dynamodbav:"Reason,omitempty"    json:"reason,omitempty"`
+	UpdatedBy string `dynamodbav:"UpdatedBy,omitempty" json:"updated_by,omitempty"`
+	UpdatedAt string `dynamodbav:"UpdatedAt,omitempty" json:"updated_at,omitempty"`
+}
+
+// freezeListResponse is the halt switch and every freeze window
+type freezeListResponse struct {
+	Halt    releaseHalt    `json:"halt"`
+	Windows []freezeWindow `json:"windows"`
+}
+
+// freezeSortKey scopes the window to its repository, or to every repository
+func freezeSortKey(provider, name, window string) string {
+	if name == "" {
+		return fmt.Sprintf("global#%s", window)
+	}
+	return fmt.Sprintf("%s#%s#%s", provider, name, window)
+}
+
+// requestIsAdmin returns true when the Cognito user who made the request is a member of adminGroup
+func requestIsAdmin(event events.APIGatewayV2HTTPRequest) bool {
+	if event.RequestContext.Authorizer == nil || event.RequestContext.Authorizer.JWT == nil {
+		return false
+	}
+
+	// NOTE(SMT): API Gateway flattens the cognito:groups claim into a string such as "[admin developers]"
+	groups := strings.Trim(event.RequestContext.Authorizer.JWT.Claims["cognito:groups"], "[]")
+	for _, group := range strings.FieldsFunc(groups, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if group == adminGroup {
+			return true
+		}
+	}
+	return false
+}
+
+func parseWeekday(day string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), day) {
+			return d, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("%v is not a day of the week", day)
+}
+
+func (w freezeWindow) validate() error {
+	if w.Name == "" {
+		return errors.New("name is required")
+	}
+	if (w.RepoProvider == "") != (w.RepoName == "") {
+		return errors.New("repo_provider and repo_name must be set together")
+	}
+
+	dateRange := w.Start != "" || w.End != ""
+	if dateRange == (len(w.Days) > 0) {
+		return errors.New("either start and end, or days are required")
+	}
+
+	if dateRange {
+		start, err := time.Parse(time.RFC3339, w.Start)
+		if err != nil {
+			return fmt.Errorf("start must be an RFC 3339 time, %v", err)
+		}
+		end, err := time.Parse(time.RFC3339, w.End)
+		if err != nil {
+			return fmt.Errorf("end must be an RFC 3339 time, %v", err)
+		}
+		if !end.After(start) {
+			return errors.New("end must be after start")
+		}
+		return nil
+	}
+
+	for _, day := range w.Days {
+		if _, err := parseWeekday(day); err != nil {
+			return err
+		}
+	}
+	if (w.StartTime == "") != (w.EndTime == "") {
+		return errors.New("start_time and end_time must be set together")
+	}
+	if w.StartTime != "" {
+		start, err := time.Parse(freezeClockFormat, w.StartTime)
+		if err != nil {
+			return errors.New("start_time must be formatted as HH:MM")
+		}
+		end, err := time.Parse(freezeClockFormat, w.EndTime)
+		if err != nil {
+			return errors.New("end_time must be formatted as HH:MM")
+		}
+		if !end.After(start) {
+			return errors.New("end_time must be after start_time, split windows which span midnight across two days")
+		}
+	}
+	if _, err := time.LoadLocation(w.TimeZone); err != nil {
+		return fmt.Errorf("time_zone %v is unknown", w.TimeZone)
+	}
+	return nil
+}
+
+// active returns true when now falls within the window. Windows which cannot be parsed are treated
+// as active, so that a mistake blocks releases rather than silently allowing them.
+func (w freezeWindow) active(now time.Time) bool {
+	if len(w.Days) == 0 {
+		start, err := time.Parse(time.RFC3339, w.Start)
+		if err != nil {
+			return true
+		}
+		end, err := time.Parse(time.RFC3339, w.End)
+		if err != nil {
+			return true
+		}
+		return !now.Before(start) && now.Before(end)
+	}
+
+	location, err := time.LoadLocation(w.TimeZone)
+	if err != nil {
+		return true
+	}
+	local := now.In(location)
+
+	today := false
+	for _, day := range w.Days {
+		d, err := parseWeekday(day)
+		if err != nil || d == local.Weekday() {
+			today = true
+		}
+	}
+	if !today || w.StartTime == "" {
+		return today
+	}
+
+	clock := local.Format(freezeClockFormat)
+	return clock >= w.StartTime && clock < w.EndTime
+}
+
+// listFreezes returns the halt switch and every freeze window, which are stored together under the
+// freeze partition
+func (app awsController) listFreezes() (releaseHalt, []freezeWindow, error) {
+	halt := releaseHalt{}
+	windows := []freezeWindow{}
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":primary_key": {
+				S: aws.String("freeze"),
+			}},
+		KeyConditionExpression: aws.String("PK = :primary_key"),
+		ConsistentRead:         aws.Bool(true),
+		TableName:              aws.String(app.TableName),
+	}
+
+	for {
+		resp, err := app.DB.Query(input)
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok {
+				log.Error(fmt.Sprintf("%v", aerr.Error()))
+			} else {
+				log.Error(fmt.Sprintf("%v", err.Error()))
+			}
+			return halt, windows, err
+		}
+
+		for _, item := range resp.Items {
+			if aws.StringValue(item["SK"].S) == "halt" {
+				err = dynamodbattribute.UnmarshalMap(item, &halt)
+			} else {
+				window := freezeWindow{}
+				err = dynamodbattribute.UnmarshalMap(item, &window)
+				windows = append(windows, window)
+			}
+			if err != nil {
+				log.Error(fmt.Sprintf("unable to unmarshal freeze, %v", err))
+				return halt, windows, err
+			}
+		}
+
+		if len(resp.LastEvaluatedKey) == 0 {
+			return halt, windows, nil
+		}
+		input.ExclusiveStartKey = resp.LastEvaluatedKey
+	}
+}
+
+// activeFreeze returns why releases of the repository are frozen at now, or an empty string when
+// they are not
+func (app awsController) activeFreeze(e releaseEvent, now time.Time) (string, error) {
+	halt, windows, err := app.listFreezes()
+	if err != nil {
+		return "", err
+	}
+
+	if halt.Halted {
+		return fmt.Sprintf("releases have been halted by %v, %v", halt.UpdatedBy, halt.Reason), nil
+	}
+
+	for _, w := range windows {
+		if w.RepoName != "" && (w.RepoProvider != e.RepoProvider || w.RepoName != e.RepoName) {
+			continue
+		}
+		if w.active(now) {
+			return fmt.Sprintf("freeze window %v is active, %v", w.Name, w.Reason), nil
+		}
+	}
+	return "", nil
+}
+
+func (app awsController) putFreezeItem(item interface{}) error {
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal freeze, %v", err))
+		return err
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(app.TableName),
+	}
+
+	_, err = app.DB.PutItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return err
+	}
+	return nil
+}
+
+func (app awsController) deleteFreezeWindow(w freezeWindow) error {
+	input := &dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("freeze"),
+			},
+			"SK": {
+				S: aws.String(freezeSortKey(w.RepoProvider, w.RepoName, w.Name)),
+			},
+		},
+		TableName: aws.String(app.TableName),
+	}
+
+	_, err := app.DB.DeleteItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return err
+	}
+	return nil
+}
+
+func (app application) releasesFreezeCreateHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	if !requestIsAdmin(event) {
+		message := "Only admins may create freeze windows"
+		statusCode := 403
+		return message, statusCode
+	}
+
+	w := freezeWindow{}
+	err := json.Unmarshal([]byte(event.Body), &w)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+	}
+
+	err = w.validate()
+	if err != nil {
+		message := fmt.Sprintf("Unable to create freeze window %v, %v", w.Name, err)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	w.PK = "freeze"
+	w.SK = freezeSortKey(w.RepoProvider, w.RepoName, w.Name)
+	w.CreatedBy = requestActor(event)
+	err = app.AWS.putFreezeItem(w)
+	if err != nil {
+		message := fmt.Sprintf("Unable to create freeze window %v, could not write to backend", w.Name)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	message := fmt.Sprintf("Created freeze window %v", w.Name)
+	statusCode := 200
+	return message, statusCode
+}
+
+func (app application) releasesFreezeDeleteHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	if !requestIsAdmin(event) {
+		message := "Only admins may delete freeze windows"
+		statusCode := 403
+		return message, statusCode
+	}
+
+	w := freezeWindow{}
+	err := json.Unmarshal([]byte(event.Body), &w)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+	}
+
+	if w.Name == "" {
+		message := "Unable to delete freeze window, name is required"
+		statusCode := 400
+		return message, statusCode
+	}
+
+	err = app.AWS.deleteFreezeWindow(w)
+	if err != nil {
+		message := fmt.Sprintf("Unable to delete freeze window %v, could not write to backend", w.Name)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	message := fmt.Sprintf("Deleted freeze window %v", w.Name)
+	statusCode := 200
+	return message, statusCode
+}
+
+func (app application) releasesFreezeListHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	halt, windows, err := app.AWS.listFreezes()
+	if err != nil {
+		message := "Failed to query freeze windows"
+		statusCode := 400
+		return message, statusCode
+	}
+
+	body, err := json.Marshal(freezeListResponse{Halt: halt, Windows: windows})
+	statusCode := 200
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal json for response, %v", err))
+		statusCode = 400
+	}
+
+	var buf bytes.Buffer
+	json.HTMLEscape(&buf, body)
+	return buf.String(), statusCode
+}
+
+// releasesHaltHandler flips the global switch which stops every release immediately
+func (app application) releasesHaltHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	if !requestIsAdmin(event) {
+		message := "Only admins may halt or resume releases"
+		statusCode := 403
+		return message, statusCode
+	}
+
+	halt := releaseHalt{}
+	err := json.Unmarshal([]byte(event.Body), &halt)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+	}
+
+	halt.PK = "freeze"
+	halt.SK = "halt"
+	halt.UpdatedBy = requestActor(event)
+	halt.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	err = app.AWS.putFreezeItem(halt)
+	if err != nil {
+		message := "Unable to update the release halt, could not write to backend"
+		statusCode := 400
+		return message, statusCode
+	}
+
+	log.Info(fmt.Sprintf("releases halted set to %v by %v", halt.Halted, halt.UpdatedBy))
+	message := "Releases have been resumed"
+	if halt.Halted {
+		message = "Releases have been halted"
+	}
+	statusCode := 200
+	return message, statusCode
+}
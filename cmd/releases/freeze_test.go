@@ -0,0 +1,237 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func adminRequest(path, body string) events.APIGatewayV2HTTPRequest {
+	event := events.APIGatewayV2HTTPRequest{RawPath: path, Body: body}
+	event.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+		JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+			Claims: map[string]string{"email": "admin@example.com", "cognito:groups": "[developers admin]"},
+		},
+	}
+	return event
+}
+
+func freezeItem(item interface{}) map[string]*dynamodb.AttributeValue {
+	av, _ := dynamodbattribute.MarshalMap(item)
+	return av
+}
+
+func TestFreezeWindowActive(t *testing.T) {
+	// NOTE(SMT): 2021-12-25 is a Saturday
+	now := time.Date(2021, 12, 25, 17, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		window freezeWindow
+		active bool
+	}{
+		{"Date range containing now", freezeWindow{Start: "2021-12-20T00:00:00Z", End: "2022-01-02T00:00:00Z"}, true},
+		{"Date range which has ended", freezeWindow{Start: "2021-12-01T00:00:00Z", End: "2021-12-25T17:30:00Z"}, false},
+		{"Whole day weekly rule", freezeWindow{Days: []string{"saturday", "sunday"}}, true},
+		{"Weekly rule on another day", freezeWindow{Days: []string{"Friday"}}, false},
+		{"Weekly rule within its hours", freezeWindow{Days: []string{"Saturday"}, StartTime: "17:00", EndTime: "18:00"}, true},
+		{"Weekly rule outside its hours", freezeWindow{Days: []string{"Saturday"}, StartTime: "09:00", EndTime: "17:00"}, false},
+		{"Weekly rule in its time zone", freezeWindow{Days: []string{"Sunday"}, StartTime: "00:00", EndTime: "06:00", TimeZone: "Asia/Tokyo"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.window.active(now) != tt.active {
+				t.Fatalf("Window %+v should have been active %v", tt.window, tt.active)
+			}
+		})
+	}
+}
+
+func TestFreezeWindowValidate(t *testing.T) {
+	t.Run("Successfully validated weekly rule", func(t *testing.T) {
+		w := freezeWindow{Name: "weekends", Days: []string{"Saturday", "Sunday"}, TimeZone: "Europe/London"}
+		if err := w.validate(); err != nil {
+			t.Fatalf("Window should have been valid, %v", err)
+		}
+	})
+
+	invalid := map[string]freezeWindow{
+		"Window without a name":          {Days: []string{"Saturday"}},
+		"Window without a range or days": {Name: "empty"},
+		"Range ending before it starts":  {Name: "range", Start: "2021-12-25T00:00:00Z", End: "2021-12-24T00:00:00Z"},
+		"Unknown day":                    {Name: "days", Days: []string{"Caturday"}},
+		"Unknown time zone":              {Name: "zone", Days: []string{"Monday"}, TimeZone: "Mars/Olympus"},
+		"Hours spanning midnight":        {Name: "night", Days: []string{"Friday"}, StartTime: "22:00", EndTime: "02:00"},
+		"Repository without a provider":  {Name: "repo", RepoName: "test", Days: []string{"Monday"}},
+	}
+	for name, w := range invalid {
+		t.Run(name+" is rejected", func(t *testing.T) {
+			if err := w.validate(); err == nil {
+				t.Fatalf("Window %+v should have been invalid", w)
+			}
+		})
+	}
+}
+
+func TestReleaseFreeze(t *testing.T) {
+	frozen := func() map[string]map[string]*dynamodb.AttributeValue {
+		return map[string]map[string]*dynamodb.AttributeValue{
+			"freeze|fake#test#incident": freezeItem(freezeWindow{
+				PK:           "freeze",
+				SK:           "fake#test#incident",
+				Name:         "incident",
+				RepoProvider: "fake",
+				RepoName:     "test",
+				Reason:       "database migration",
+				Start:        time.Now().Add(-time.Hour).Format(time.RFC3339),
+				End:          time.Now().Add(time.Hour).Format(time.RFC3339),
+			}),
+		}
+	}
+
+	t.Run("Release during a freeze window is rejected", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: frozen()}
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.0"}`,
+		})
+		if resp.StatusCode != 403 || !strings.Contains(resp.Body, "incident") {
+			t.Fatalf("Release should have been frozen, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.Compared) != 0 || len(provider.PullRequests) != 0 || len(provider.Releases) != 0 {
+			t.Fatal("Provider should not have been called during a freeze")
+		}
+	})
+
+	t.Run("Freeze window of another repository is ignored", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: frozen()}
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "other", "repo_provider": "fake", "release_version": "1.0.0"}`,
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Halted releases are rejected", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+		items := map[string]map[string]*dynamodb.AttributeValue{}
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: items}
+
+		resp, _ := app.handler(adminRequest("/releases/halt", `{"halted": true, "reason": "incident"}`))
+		if resp.StatusCode != 200 {
+			t.Fatalf("Releases should have been halted, got %v %v", resp.StatusCode, resp.Body)
+		}
+
+		resp, _ = app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.0"}`,
+		})
+		if resp.StatusCode != 403 || !strings.Contains(resp.Body, "halted by admin@example.com") {
+			t.Fatalf("Release should have been halted, got %v %v", resp.StatusCode, resp.Body)
+		}
+
+		_, _ = app.handler(adminRequest("/releases/halt", `{"halted": false}`))
+		resp, _ = app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.0"}`,
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Release should have succeeded once resumed, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Hotfix overridden by an admin is released and recorded", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+		items := frozen()
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: items}
+
+		resp, _ := app.handler(adminRequest("/releases/create", `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.1", "hotfix": true, "freeze_override": true}`))
+		if resp.StatusCode != 200 {
+			t.Fatalf("Hotfix should have been released, got %v %v", resp.StatusCode, resp.Body)
+		}
+
+		records := historyRecords(items)
+		if len(records) != 1 || !strings.Contains(records[0].FreezeOverride, "incident") {
+			t.Fatalf("Freeze override should have been recorded, got %+v", records)
+		}
+	})
+
+	t.Run("Hotfix without an override is rejected", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: frozen()}
+
+		resp, _ := app.handler(adminRequest("/releases/create", `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.1", "hotfix": true}`))
+		if resp.StatusCode != 403 {
+			t.Fatalf("Hotfix should have been frozen, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Override by a user who is not an admin is rejected", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: frozen()}
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.1", "hotfix": true, "freeze_override": true}`,
+		})
+		if resp.StatusCode != 403 || len(provider.Releases) != 0 {
+			t.Fatalf("Override should have been rejected, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+}
+
+func TestFreezeHandlers(t *testing.T) {
+	t.Run("Successfully created and listed freeze window", func(t *testing.T) {
+		app := newTestApplication(&fakeProvider{})
+		items := map[string]map[string]*dynamodb.AttributeValue{}
+		app.AWS.DB = mockDynamoDB{Items: items}
+
+		resp, _ := app.handler(adminRequest("/releases/freeze/create", `{"name": "weekends", "days": ["Saturday", "Sunday"], "time_zone": "Europe/London"}`))
+		if resp.StatusCode != 200 {
+			t.Fatalf("Freeze window should have been created, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if items["freeze|global#weekends"] == nil {
+			t.Fatal("Freeze window should have been written as a global window")
+		}
+
+		resp, _ = app.handler(adminRequest("/releases/freeze/list", ""))
+		if resp.StatusCode != 200 || !strings.Contains(resp.Body, "admin@example.com") {
+			t.Fatalf("Freeze window should have been listed, got %v %v", resp.StatusCode, resp.Body)
+		}
+
+		resp, _ = app.handler(adminRequest("/releases/freeze/delete", `{"name": "weekends"}`))
+		if resp.StatusCode != 200 || items["freeze|global#weekends"] != nil {
+			t.Fatalf("Freeze window should have been deleted, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Freeze window created by a user who is not an admin is rejected", func(t *testing.T) {
+		app := newTestApplication(&fakeProvider{})
+		app.AWS.DB = mockDynamoDB{Items: map[string]map[string]*dynamodb.AttributeValue{}}
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/freeze/create",
+			Body:    `{"name": "weekends", "days": ["Saturday"]}`,
+		})
+		if resp.StatusCode != 403 {
+			t.Fatalf("Freeze window should have been rejected, got %v", resp.StatusCode)
+		}
+	})
+}
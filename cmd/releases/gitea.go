@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/seanturner026/moot/internal/gitea"
+	log "github.com/sirupsen/logrus"
+)
+
+type giteaController struct {
+	Client              *gitea.Client
+	MergeCommitTemplate string
+}
+
+func newGiteaController(e releaseEvent, repo repository, token string) (releaseProvider, error) {
+	if repo.BaseURL == "" {
+		log.Error(fmt.Sprintf("%v does not have a gitea base url", e.RepoName))
+		return nil, errors.New("gitea repositories require a base url")
+	}
+
+	client, err := gitea.NewClient(repo.BaseURL, token)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create gitea client, %v", err))
+		return nil, err
+	}
+
+	return giteaController{Client: client, MergeCommitTemplate: repo.MergeCommitTemplate}, nil
+}
+
+// compareBranches returns the commits on head which are not on base, and the files they change.
+// Gitea does not return the commits on base which are not on head, so head is also compared with
+// base.
+func (app giteaController) compareBranches(e releaseEvent, base, head string) (comparison, error) {
+	log.Info(fmt.Sprintf("comparing %v %v...%v...", e.RepoName, base, head))
+	resp, err := app.Client.CompareBranches(e.RepoOwner, e.RepoName, base, head)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to compare %v %v...%v, %v", e.RepoName, base, head, err))
+		return comparison{}, err
+	}
+
+	behind, err := app.Client.CompareBranches(e.RepoOwner, e.RepoName, head, base)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to compare %v %v...%v, %v", e.RepoName, head, base, err))
+		return comparison{}, err
+	}
+
+	c := comparison{AheadBy: resp.TotalCommits, BehindBy: behind.TotalCommits}
+	for _, file := range resp.Files {
+		c.Files = append(c.Files, changedFile{Filename: file.Filename, Status: file.Status})
+	}
+	for _, giteaCommit := range resp.Commits {
+		c.Commits = append(c.Commits, commit{
+			SHA:     giteaCommit.SHA,
+			Message: giteaCommit.Commit.Message,
+			Author:  giteaCommit.Commit.Author.Name,
+			Merge:   len(giteaCommit.Parents) > 1,
+		})
+	}
+	return c, nil
+}
+
+// createPullRequest opens a Gitea pull request which merges BranchHead into BranchBase. Gitea rejects
+// a second pull request between the same branches, so an open one is updated and reused.
+func (app giteaController) createPullRequest(e releaseEvent) (pullRequest, error) {
+	existing, err := app.Client.ListOpenPullRequests(e.RepoOwner, e.RepoName, e.BranchHead, e.BranchBase)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to list %v pull requests, %v", e.RepoName, err))
+		return pullRequest{}, err
+	}
+	if len(existing) > 0 {
+		log.Info(fmt.Sprintf("updating existing %v pull request %v...", e.RepoName, existing[0].Number))
+		resp, err := app.Client.UpdatePullRequest(e.RepoOwner, e.RepoName, existing[0].Number, e.pullRequestTitle(), e.ReleaseBody)
+		if err != nil {
+			log.Error(fmt.Sprintf("unable to update %v pull request %v, %v", e.RepoName, existing[0].Number, err))
+			return pullRequest{}, err
+		}
+		return pullRequest{Number: resp.Number, URL: resp.HTMLURL}, nil
+	}
+
+	log.Info(fmt.Sprintf("creating %v pull request...", e.RepoName))
+	resp, err := app.Client.CreatePullRequest(
+		e.RepoOwner,
+		e.RepoName,
+		e.pullRequestTitle(),
+		e.ReleaseBody,
+		e.BranchHead,
+		e.BranchBase,
+	)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create %v pull request, %v", e.RepoName, err))
+		return pullRequest{}, err
+	}
+
+	return pullRequest{Number: resp.Number, URL: resp.HTMLURL}, nil
+}
+
+// waitForMergeable returns an error if Gitea reports that the pull request cannot be merged
+func (app giteaController) waitForMergeable(e releaseEvent, pr pullRequest) error {
+	log.Info(fmt.Sprintf("checking %v pull request %v mergability...", e.RepoName, pr.Number))
+	resp, err := app.Client.GetPullRequest(e.RepoOwner, e.RepoName, pr.Number)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to check %v pull request %v mergability, %v", e.RepoName, pr.Number, err))
+		return err
+	}
+
+	if !resp.Mergeable {
+		return errors.New("pull request has merge conflicts")
+	}
+	return nil
+}
+
+// listChecks does not gate merges on Gitea commit statuses
+func (app giteaController) listChecks(e releaseEvent, pr pullRequest) ([]check, error) {
+	return []check{}, nil
+}
+
+// mergePullRequest merges the pull request created by createPullRequest, and returns the SHA of the
+// merge commit
+func (app giteaController) mergePullRequest(e releaseEvent, pr pullRequest) (string, error) {
+	log.Info(fmt.Sprintf("merging pull request %v...", pr.Number))
+	err := app.Client.MergePullRequest(
+		e.RepoOwner,
+		e.RepoName,
+		pr.Number,
+		mergeCommitMessage(app.MergeCommitTemplate, e, pr),
+	)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to merge %v pull request %v, %v", e.RepoName, pr.Number, err))
+		return "", err
+	}
+
+	resp, err := app.Client.GetPullRequest(e.RepoOwner, e.RepoName, pr.Number)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to read merge commit of %v pull request %v, %v", e.RepoName, pr.Number, err))
+		return "", nil
+	}
+	return resp.MergeCommitSHA, nil
+}
+
+// createRelease creates a release on Gitea according to the ReleaseEvent
+func (app giteaController) createRelease(e releaseEvent) error {
+	log.Info(fmt.Sprintf("creating %v release version %v...", e.RepoName, e.ReleaseVersion))
+	_, err := app.Client.CreateRelease(
+		e.RepoOwner,
+		e.RepoName,
+		e.ReleaseVersion,
+		e.releaseTarget(),
+		e.ReleaseVersion,
+		e.ReleaseBody,
+		e.Prerelease,
+	)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create %v release version %v, %v", e.RepoName, e.ReleaseVersion, err))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGiteaRelease(t *testing.T) {
+	t.Run("Successfully released to Gitea", func(t *testing.T) {
+		releases := []string{}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v1/repos/owner/repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "token token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.Method == http.MethodGet {
+				w.Write([]byte(`[]`))
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"number": 3, "html_url": "https://gitea.example.com/owner/repo/pulls/3"}`))
+		})
+		mux.HandleFunc("/api/v1/repos/owner/repo/pulls/3", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"number": 3, "mergeable": true}`))
+		})
+		mux.HandleFunc("/api/v1/repos/owner/repo/pulls/3/merge", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		mux.HandleFunc("/api/v1/repos/owner/repo/releases", func(w http.ResponseWriter, r *http.Request) {
+			input := map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&input)
+			if input["target_commitish"] != "main" {
+				t.Errorf("release should target main, got %v", input["target_commitish"])
+			}
+			releases = append(releases, input["tag_name"].(string))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": 1}`))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		e := releaseEvent{
+			RepoOwner:      "owner",
+			RepoName:       "repo",
+			RepoProvider:   "gitea",
+			BranchBase:     "main",
+			BranchHead:     "develop",
+			ReleaseVersion: "1.0.0",
+		}
+		provider, err := newGiteaController(e, repository{BaseURL: server.URL}, "token")
+		if err != nil {
+			t.Fatalf("Provider should have been created, %v", err)
+		}
+
+		app := application{AWS: awsController{TableName: "test", DB: mockDynamoDB{}}}
+		_, statusCode := app.runRelease(provider, e, &releaseState{Step: "started"})
+		if statusCode != 200 {
+			t.Fatalf("Release should have succeeded, got %v", statusCode)
+		}
+		if len(releases) != 1 || releases[0] != "1.0.0" {
+			t.Fatalf("Release 1.0.0 should have been created, got %v", releases)
+		}
+	})
+
+	t.Run("Repository without a base url is rejected", func(t *testing.T) {
+		_, err := newGiteaController(releaseEvent{RepoName: "repo"}, repository{}, "token")
+		if err == nil {
+			t.Fatal("Provider should not have been created without a base url")
+		}
+	})
+}
@@ -2,22 +2,135 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/google/go-github/github"
+	"github.com/seanturner026/moot/internal/util"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/oauth2"
 )
 
+// githubMergeableTimeout is how long the release waits for Github to compute whether a pull request
+// can be merged, which Github does in the background after the pull request is opened or updated
+const githubMergeableTimeout = 5 * time.Second
+
 type githubController struct {
-	Client    *github.Client
-	GithubCtx context.Context
+	Client              *github.Client
+	GithubCtx           context.Context
+	MergeMethod         string
+	MergeCommitTemplate string
+	DeleteSourceBranch  bool
+	PollInterval        time.Duration
+	MergeableTimeout    time.Duration
+}
+
+func newGithubController(e releaseEvent, repo repository, token string) (releaseProvider, error) {
+	githubCtx := context.Background()
+	client, err := util.NewGithubClient(githubCtx, token, repo.BaseURL)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create github client, %v", err))
+		return nil, err
+	}
+
+	return githubController{
+		Client:              client,
+		GithubCtx:           githubCtx,
+		MergeMethod:         repo.MergeMethod,
+		MergeCommitTemplate: repo.MergeCommitTemplate,
+		DeleteSourceBranch:  repo.DeleteSourceBranch != nil && *repo.DeleteSourceBranch,
+		PollInterval:        time.Second,
+		MergeableTimeout:    githubMergeableTimeout,
+	}, nil
+}
+
+// compareBranches returns the commits on head which are not on base, and the files they change.
+// Github returns at most 250 commits and 300 files.
+func (app githubController) compareBranches(e releaseEvent, base, head string) (comparison, error) {
+	log.Info(fmt.Sprintf("comparing %v %v...%v...", e.RepoName, base, head))
+	resp, _, err := app.Client.Repositories.CompareCommits(app.GithubCtx, e.RepoOwner, e.RepoName, base, head)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to compare %v %v...%v, %v", e.RepoName, base, head, err))
+		return comparison{}, err
+	}
+
+	c := comparison{AheadBy: resp.GetAheadBy(), BehindBy: resp.GetBehindBy()}
+	for _, file := range resp.Files {
+		c.Files = append(c.Files, changedFile{
+			Filename:  file.GetFilename(),
+			Status:    file.GetStatus(),
+			Additions: file.GetAdditions(),
+			Deletions: file.GetDeletions(),
+		})
+	}
+	for _, repoCommit := range resp.Commits {
+		c.Commits = append(c.Commits, commit{
+			SHA:     repoCommit.GetSHA(),
+			Message: repoCommit.GetCommit().GetMessage(),
+			Author:  repoCommit.GetCommit().GetAuthor().GetName(),
+			Merge:   len(repoCommit.Parents) > 1,
+		})
+	}
+	return c, nil
 }
 
-// CreatePullRequest generates a pull request on Github according to the ReleaseEvent
-func (app githubController) CreatePullRequest(e releaseEvent) (github.PullRequest, error) {
+// pathCommits returns the SHAs of the latest commits of ref which touch path
+func (app githubController) pathCommits(e releaseEvent, ref, path string, limit int) (map[string]bool, error) {
+	shas := map[string]bool{}
+	input := &github.CommitsListOptions{
+		SHA:         ref,
+		Path:        path,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	log.Info(fmt.Sprintf("listing %v commits of %v which touch %v...", e.RepoName, ref, path))
+	for len(shas) < limit {
+		commits, resp, err := app.Client.Repositories.ListCommits(app.GithubCtx, e.RepoOwner, e.RepoName, input)
+		if err != nil {
+			log.Error(fmt.Sprintf("unable to list %v commits which touch %v, %v", e.RepoName, path, err))
+			return nil, err
+		}
+		for _, c := range commits {
+			shas[c.GetSHA()] = true
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		input.Page = resp.NextPage
+	}
+	return shas, nil
+}
+
+// createPullRequest generates a pull request on Github according to the ReleaseEvent. Github refuses
+// to open a second pull request between the same branches, so an open one is reused with its title
+// and body updated for the release.
+func (app githubController) createPullRequest(e releaseEvent) (pullRequest, error) {
+	existing, _, err := app.Client.PullRequests.List(app.GithubCtx, e.RepoOwner, e.RepoName, &github.PullRequestListOptions{
+		State: "open",
+		Head:  fmt.Sprintf("%s:%s", e.RepoOwner, e.BranchHead),
+		Base:  e.BranchBase,
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to list %v pull requests, %v", e.RepoName, err))
+		return pullRequest{}, err
+	}
+	if len(existing) > 0 {
+		log.Info(fmt.Sprintf("updating existing %v pull request %v...", e.RepoName, existing[0].GetNumber()))
+		resp, _, err := app.Client.PullRequests.Edit(app.GithubCtx, e.RepoOwner, e.RepoName, existing[0].GetNumber(), &github.PullRequest{
+			Title: github.String(e.pullRequestTitle()),
+			Body:  github.String(e.ReleaseBody),
+		})
+		if err != nil {
+			log.Error(fmt.Sprintf("unable to update %v pull request %v, %v", e.RepoName, existing[0].GetNumber(), err))
+			return pullRequest{}, err
+		}
+		return pullRequest{Number: resp.GetNumber(), URL: resp.GetHTMLURL()}, nil
+	}
+
 	input := &github.NewPullRequest{
-		Title: github.String(e.ReleaseVersion),
+		Title: github.String(e.pullRequestTitle()),
 		Base:  github.String(e.BranchBase),
 		Head:  github.String(e.BranchHead),
 		Body:  github.String(e.ReleaseBody),
@@ -33,43 +146,205 @@ func (app githubController) CreatePullRequest(e releaseEvent) (github.PullReques
 
 	if err != nil {
 		log.Error(fmt.Sprintf("unable to create %v pull request, %v", e.RepoName, err))
-		return *resp, err
+		return pullRequest{}, err
+	}
+
+	return pullRequest{Number: resp.GetNumber(), URL: resp.GetHTMLURL()}, nil
+}
+
+// waitForMergeable waits up to MergeableTimeout for Github to compute whether the pull request can be
+// merged, and returns an error if it has conflicts
+func (app githubController) waitForMergeable(e releaseEvent, pr pullRequest) error {
+	log.Info(fmt.Sprintf("checking %v pull request %v mergability...", e.RepoName, pr.Number))
+	deadline := time.Now().Add(app.MergeableTimeout)
+	for {
+		resp, _, err := app.Client.PullRequests.Get(app.GithubCtx, e.RepoOwner, e.RepoName, pr.Number)
+		if err != nil {
+			log.Error(fmt.Sprintf("unable to check %v pull request %v mergability, %v", e.RepoName, pr.Number, err))
+			return err
+		}
+		if resp.Mergeable != nil && !resp.GetMergeable() {
+			return errors.New("pull request has merge conflicts")
+		} else if resp.Mergeable != nil {
+			return nil
+		}
+
+		if !time.Now().Add(app.PollInterval).Before(deadline) {
+			return errors.New("pull request mergability was never computed")
+		}
+		time.Sleep(app.PollInterval)
+	}
+}
+
+// listChecks returns the commit statuses and check runs on the head of the pull request
+func (app githubController) listChecks(e releaseEvent, pr pullRequest) ([]check, error) {
+	log.Info(fmt.Sprintf("checking %v pull request %v status checks...", e.RepoName, pr.Number))
+	resp, _, err := app.Client.PullRequests.Get(app.GithubCtx, e.RepoOwner, e.RepoName, pr.Number)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to get %v pull request %v, %v", e.RepoName, pr.Number, err))
+		return nil, err
 	}
+	sha := resp.GetHead().GetSHA()
 
-	return *resp, nil
+	status, _, err := app.Client.Repositories.GetCombinedStatus(app.GithubCtx, e.RepoOwner, e.RepoName, sha, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to get %v %v combined status, %v", e.RepoName, sha, err))
+		return nil, err
+	}
+
+	checks := []check{}
+	for _, s := range status.Statuses {
+		state := s.GetState()
+		if state == "error" {
+			state = "failure"
+		}
+		checks = append(checks, check{Name: s.GetContext(), State: state, URL: s.GetTargetURL()})
+	}
+
+	runs, _, err := app.Client.Checks.ListCheckRunsForRef(app.GithubCtx, e.RepoOwner, e.RepoName, sha, &github.ListCheckRunsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to list %v %v check runs, %v", e.RepoName, sha, err))
+		return nil, err
+	}
+
+	for _, run := range runs.CheckRuns {
+		c := check{Name: run.GetName(), State: "pending", URL: run.GetHTMLURL()}
+		if run.GetStatus() == "completed" {
+			switch run.GetConclusion() {
+			case "success", "neutral", "skipped":
+				c.State = "success"
+			default:
+				c.State = "failure"
+			}
+		}
+		checks = append(checks, c)
+	}
+	return checks, nil
 }
 
-// MergePullRequest merges the pull request created by ghCreatePullRequest
-func (app githubController) MergePullRequest(prNumber int, e releaseEvent) (github.PullRequestMergeResult, error) {
-	log.Info(fmt.Sprintf("merging pull request %v...", prNumber))
+// mergePullRequest merges the pull request created by createPullRequest with the repository's merge
+// method, and returns the SHA of the merge commit. Github does not delete the head branch on merge,
+// so it is deleted afterwards when the repository asks for it.
+func (app githubController) mergePullRequest(e releaseEvent, pr pullRequest) (string, error) {
+	log.Info(fmt.Sprintf("merging pull request %v...", pr.Number))
 	mergeResult, _, err := app.Client.PullRequests.Merge(
 		app.GithubCtx,
 		e.RepoOwner,
 		e.RepoName,
-		prNumber,
-		fmt.Sprintf("Merging pull request number %v", prNumber),
-		&github.PullRequestOptions{},
+		pr.Number,
+		mergeCommitMessage(app.MergeCommitTemplate, e, pr),
+		&github.PullRequestOptions{MergeMethod: app.MergeMethod},
 	)
 
 	if err != nil {
-		log.Error(fmt.Sprintf("unable to merge %v pull request %v, %v", e.RepoName, prNumber, err))
-		return *mergeResult, err
+		log.Error(fmt.Sprintf("unable to merge %v pull request %v, %v", e.RepoName, pr.Number, err))
+		return "", err
 	}
-	return *mergeResult, nil
+
+	if !mergeResult.GetMerged() {
+		log.Error(fmt.Sprintf("%v pull request %v not merged", e.RepoName, pr.Number))
+		return "", errors.New("pull request was not merged")
+	}
+
+	if app.DeleteSourceBranch && !e.BackMerge {
+		log.Info(fmt.Sprintf("deleting %v branch %v...", e.RepoName, e.BranchHead))
+		_, err = app.Client.Git.DeleteRef(app.GithubCtx, e.RepoOwner, e.RepoName, fmt.Sprintf("heads/%s", e.BranchHead))
+		if err != nil {
+			// NOTE(SMT): the pull request has been merged, so the release carries on without deleting the branch
+			log.Error(fmt.Sprintf("unable to delete %v branch %v, %v", e.RepoName, e.BranchHead, err))
+		}
+	}
+	return mergeResult.GetSHA(), nil
+}
+
+// tagCommit returns the SHA of the commit which the tag points at
+func (app githubController) tagCommit(e releaseEvent, tag string) (string, error) {
+	sha, _, err := app.Client.Repositories.GetCommitSHA1(app.GithubCtx, e.RepoOwner, e.RepoName, fmt.Sprintf("refs/tags/%s", tag), "")
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to get %v tag %v, %v", e.RepoName, tag, err))
+		return "", err
+	}
+	return sha, nil
 }
 
-// CreateRelease creates a release on Github according to the ReleaseEvent
-func (app githubController) CreateRelease(e releaseEvent) error {
+// createRevertBranch creates branch with a commit which reverts the merge commit sha. Github has no API
+// to revert a commit, so the revert commit restores the tree of the merge commit's first parent, which
+// is only a revert while the merge commit is still the head of BranchBase.
+func (app githubController) createRevertBranch(e releaseEvent, branch, sha string) error {
+	base, _, err := app.Client.Repositories.GetBranch(app.GithubCtx, e.RepoOwner, e.RepoName, e.BranchBase)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to get %v branch %v, %v", e.RepoName, e.BranchBase, err))
+		return err
+	}
+	if base.GetCommit().GetSHA() != sha {
+		return fmt.Errorf("%v has changed since %v was merged, revert it by hand", e.BranchBase, sha)
+	}
+
+	merge, _, err := app.Client.Git.GetCommit(app.GithubCtx, e.RepoOwner, e.RepoName, sha)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to get %v commit %v, %v", e.RepoName, sha, err))
+		return err
+	}
+	if len(merge.Parents) == 0 {
+		return fmt.Errorf("commit %v has no parent to revert to", sha)
+	}
+
+	parent, _, err := app.Client.Git.GetCommit(app.GithubCtx, e.RepoOwner, e.RepoName, merge.Parents[0].GetSHA())
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to get %v commit %v, %v", e.RepoName, merge.Parents[0].GetSHA(), err))
+		return err
+	}
+
+	revert, _, err := app.Client.Git.CreateCommit(app.GithubCtx, e.RepoOwner, e.RepoName, &github.Commit{
+		Message: github.String(fmt.Sprintf("Revert %v to roll back to %v", sha, e.Rollback)),
+		Tree:    parent.Tree,
+		Parents: []github.Commit{{SHA: github.String(sha)}},
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create %v revert commit, %v", e.RepoName, err))
+		return err
+	}
+
+	_, _, err = app.Client.Git.CreateRef(app.GithubCtx, e.RepoOwner, e.RepoName, &github.Reference{
+		Ref:    github.String(fmt.Sprintf("refs/heads/%s", branch)),
+		Object: &github.GitObject{SHA: revert.SHA},
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create %v branch %v, %v", e.RepoName, branch, err))
+		return err
+	}
+	return nil
+}
+
+// createRelease creates a release on Github according to the ReleaseEvent
+func (app githubController) createRelease(e releaseEvent) error {
+	_, err := app.createGithubRelease(e, false)
+	return err
+}
+
+// createDraftRelease creates the release as a draft, which Github does not tag until it is published
+func (app githubController) createDraftRelease(e releaseEvent) (string, error) {
+	release, err := app.createGithubRelease(e, true)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(release.GetID(), 10), nil
+}
+
+func (app githubController) createGithubRelease(e releaseEvent, draft bool) (*github.RepositoryRelease, error) {
 	input := &github.RepositoryRelease{
-		TargetCommitish: github.String(e.BranchBase),
+		TargetCommitish: github.String(e.releaseTarget()),
 		TagName:         github.String(e.ReleaseVersion),
 		Name:            github.String(e.ReleaseVersion),
 		Body:            github.String(e.ReleaseBody),
-		Prerelease:      github.Bool(false),
+		Draft:           github.Bool(draft),
+		Prerelease:      github.Bool(e.Prerelease),
 	}
 
 	log.Info(fmt.Sprintf("creating %v release version %v...", e.RepoName, e.ReleaseVersion))
-	_, _, err := app.Client.Repositories.CreateRelease(
+	release, _, err := app.Client.Repositories.CreateRelease(
 		app.GithubCtx,
 		e.RepoOwner,
 		e.RepoName,
@@ -78,65 +353,69 @@ func (app githubController) CreateRelease(e releaseEvent) error {
 
 	if err != nil {
 		log.Error(fmt.Sprintf("unable to create %v release version %v, %v", e.RepoName, e.ReleaseVersion, err))
+		return nil, err
+	}
+	return release, nil
+}
+
+func (app githubController) editDraftRelease(e releaseEvent, id string, input *github.RepositoryRelease) error {
+	releaseID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("draft release id %v is not a number", id)
+	}
+
+	_, _, err = app.Client.Repositories.EditRelease(app.GithubCtx, e.RepoOwner, e.RepoName, releaseID, input)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to edit %v draft release %v, %v", e.RepoName, id, err))
 		return err
 	}
 	return nil
 }
 
-func (app application) releasesGithubHandler(e releaseEvent, token string) (string, int) {
-	githubCtx := context.Background()
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
-	tc := oauth2.NewClient(githubCtx, ts)
+func (app githubController) publishRelease(e releaseEvent, id string) error {
+	log.Info(fmt.Sprintf("publishing %v release version %v...", e.RepoName, e.ReleaseVersion))
+	return app.editDraftRelease(e, id, &github.RepositoryRelease{Draft: github.Bool(false)})
+}
 
-	app.GH = githubController{
-		Client:    github.NewClient(tc),
-		GithubCtx: githubCtx,
-	}
+func (app githubController) updateDraftRelease(e releaseEvent, id string) error {
+	log.Info(fmt.Sprintf("updating %v draft release version %v...", e.RepoName, e.ReleaseVersion))
+	return app.editDraftRelease(e, id, &github.RepositoryRelease{Body: github.String(e.ReleaseBody)})
+}
 
-	var err error
-	if !e.Hotfix {
-		prResp, err := app.GH.CreatePullRequest(e)
+// uploadArtifact uploads content as an asset of the release
+func (app githubController) uploadArtifact(e releaseEvent, draftID, name string, content []byte) (string, error) {
+	var releaseID int64
+	if draftID != "" {
+		id, err := strconv.ParseInt(draftID, 10, 64)
 		if err != nil {
-			message := fmt.Sprintf("Could not create Github pull request for %v version %v, please check github for further details.",
-				e.RepoName,
-				e.ReleaseVersion)
-			statusCode := 400
-			return message, statusCode
+			return "", fmt.Errorf("draft release id %v is not a number", draftID)
 		}
-
-		mergeResp, err := app.GH.MergePullRequest(*prResp.Number, e)
+		releaseID = id
+	} else {
+		release, _, err := app.Client.Repositories.GetReleaseByTag(app.GithubCtx, e.RepoOwner, e.RepoName, e.ReleaseVersion)
 		if err != nil {
-			message := fmt.Sprintf("API request to merge github pull request %v for %v version %v failed, please check the pull request on github for further details.",
-				*prResp.Number,
-				e.RepoName,
-				e.ReleaseVersion)
-			statusCode := 400
-			return message, statusCode
+			log.Error(fmt.Sprintf("unable to find %v release %v, %v", e.RepoName, e.ReleaseVersion, err))
+			return "", err
 		}
+		releaseID = release.GetID()
+	}
 
-		if !*mergeResp.Merged {
-			log.Error(fmt.Sprintf("%v pull request %v not merged", e.RepoName, *prResp.Number))
-			message := fmt.Sprintf("API request to merge github pull request %v for %v version %v failed, please check the pull request on github for further details.",
-				*prResp.Number,
-				e.RepoName,
-				e.ReleaseVersion)
-			statusCode := 400
-			return message, statusCode
-		}
+	path, cleanup, err := writeArtifactFile(name, content)
+	if err != nil {
+		return "", err
 	}
+	defer cleanup()
 
-	err = app.GH.CreateRelease(e)
+	f, err := os.Open(path)
 	if err != nil {
-		message := fmt.Sprintf("Unable to create %v release version %v on Github.",
-			e.RepoName,
-			e.ReleaseVersion)
-		statusCode := 400
-		return message, statusCode
+		return "", err
 	}
+	defer f.Close()
 
-	message := fmt.Sprintf("Created %v release version %v on Github.",
-		e.RepoName,
-		e.ReleaseVersion)
-	statusCode := 200
-	return message, statusCode
+	asset, _, err := app.Client.Repositories.UploadReleaseAsset(app.GithubCtx, e.RepoOwner, e.RepoName, releaseID, &github.UploadOptions{Name: name}, f)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to upload %v release %v asset %v, %v", e.RepoName, e.ReleaseVersion, name, err))
+		return "", err
+	}
+	return asset.GetBrowserDownloadURL(), nil
 }
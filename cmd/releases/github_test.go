@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGithubMergePullRequest(t *testing.T) {
+	t.Run("Successfully merged with the repository's merge settings", func(t *testing.T) {
+		merge := map[string]string{}
+		deleted := ""
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v3/repos/owner/repo/pulls/3/merge", func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewDecoder(r.Body).Decode(&merge)
+			w.Write([]byte(`{"merged": true, "sha": "abc"}`))
+		})
+		mux.HandleFunc("/api/v3/repos/owner/repo/git/refs/heads/develop", func(w http.ResponseWriter, r *http.Request) {
+			deleted = r.Method
+			w.WriteHeader(http.StatusNoContent)
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		deleteSourceBranch := true
+		repo := repository{
+			BaseURL:             server.URL,
+			MergeMethod:         "squash",
+			MergeCommitTemplate: "Release {{.Version}} (#{{.Number}})",
+			DeleteSourceBranch:  &deleteSourceBranch,
+		}
+		e := releaseEvent{RepoOwner: "owner", RepoName: "repo", BranchHead: "develop", ReleaseVersion: "1.2.0"}
+		provider, _ := newGithubController(e, repo, "token")
+
+		sha, err := provider.mergePullRequest(e, pullRequest{Number: 3})
+		if err != nil || sha != "abc" {
+			t.Fatalf("Pull request should have been merged, got %v %v", sha, err)
+		}
+		if merge["merge_method"] != "squash" || merge["commit_message"] != "Release 1.2.0 (#3)" {
+			t.Fatalf("Pull request should have been squashed with the templated message, got %+v", merge)
+		}
+		if deleted != http.MethodDelete {
+			t.Fatal("Head branch should have been deleted")
+		}
+	})
+
+	t.Run("Head branch is kept by default", func(t *testing.T) {
+		deleted := false
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v3/repos/owner/repo/pulls/3/merge", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"merged": true, "sha": "abc"}`))
+		})
+		mux.HandleFunc("/api/v3/repos/owner/repo/git/refs/heads/develop", func(w http.ResponseWriter, r *http.Request) {
+			deleted = true
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		e := releaseEvent{RepoOwner: "owner", RepoName: "repo", BranchHead: "develop"}
+		provider, _ := newGithubController(e, repository{BaseURL: server.URL}, "token")
+
+		_, err := provider.mergePullRequest(e, pullRequest{Number: 3})
+		if err != nil || deleted {
+			t.Fatalf("Pull request should have been merged without deleting develop, %v", err)
+		}
+	})
+}
+
+func TestGithubCreatePullRequest(t *testing.T) {
+	t.Run("Successfully reused an open pull request", func(t *testing.T) {
+		edit := map[string]string{}
+		created := false
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v3/repos/owner/repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				created = true
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+			if r.URL.Query().Get("head") != "owner:develop" || r.URL.Query().Get("base") != "main" {
+				t.Errorf("pull requests should be filtered by branch, got %v", r.URL.RawQuery)
+			}
+			w.Write([]byte(`[{"number": 5, "html_url": "https://github.com/owner/repo/pull/5"}]`))
+		})
+		mux.HandleFunc("/api/v3/repos/owner/repo/pulls/5", func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewDecoder(r.Body).Decode(&edit)
+			w.Write([]byte(`{"number": 5, "html_url": "https://github.com/owner/repo/pull/5"}`))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		e := releaseEvent{RepoOwner: "owner", RepoName: "repo", BranchBase: "main", BranchHead: "develop", ReleaseVersion: "1.2.0", ReleaseBody: "notes"}
+		provider, _ := newGithubController(e, repository{BaseURL: server.URL}, "token")
+
+		pr, err := provider.createPullRequest(e)
+		if err != nil || pr.Number != 5 || created {
+			t.Fatalf("Open pull request should have been reused, got %+v %v", pr, err)
+		}
+		if edit["title"] != "1.2.0" || edit["body"] != "notes" {
+			t.Fatalf("Pull request should have been updated for the release, got %+v", edit)
+		}
+	})
+}
+
+func TestGithubDraftRelease(t *testing.T) {
+	t.Run("Successfully drafted and published a release", func(t *testing.T) {
+		created := map[string]interface{}{}
+		edited := map[string]interface{}{}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v3/repos/owner/repo/releases", func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewDecoder(r.Body).Decode(&created)
+			w.Write([]byte(`{"id": 42, "draft": true}`))
+		})
+		mux.HandleFunc("/api/v3/repos/owner/repo/releases/42", func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewDecoder(r.Body).Decode(&edited)
+			w.Write([]byte(`{"id": 42}`))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		e := releaseEvent{RepoOwner: "owner", RepoName: "repo", BranchBase: "main", ReleaseVersion: "1.2.0", Draft: true}
+		provider, _ := newGithubController(e, repository{BaseURL: server.URL}, "token")
+		dp := provider.(draftProvider)
+
+		id, err := dp.createDraftRelease(e)
+		if err != nil || id != "42" || created["draft"] != true {
+			t.Fatalf("Release should have been created as a draft, got %v %v %+v", id, err, created)
+		}
+
+		err = dp.publishRelease(e, id)
+		if err != nil || edited["draft"] != false {
+			t.Fatalf("Draft should have been published, got %v %+v", err, edited)
+		}
+	})
+}
+
+func TestGithubUploadArtifact(t *testing.T) {
+	t.Run("Successfully uploaded an asset to the release", func(t *testing.T) {
+		uploaded, name := "", ""
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v3/repos/owner/repo/releases/tags/1.2.0", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"id": 42}`))
+		})
+		mux.HandleFunc("/api/uploads/repos/owner/repo/releases/42/assets", func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			uploaded, name = string(body), r.URL.Query().Get("name")
+			w.Write([]byte(`{"id": 7, "browser_download_url": "https://github.example.com/app.tar.gz"}`))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		e := releaseEvent{RepoOwner: "owner", RepoName: "repo", ReleaseVersion: "1.2.0"}
+		provider, _ := newGithubController(e, repository{BaseURL: server.URL}, "token")
+
+		url, err := provider.(artifactProvider).uploadArtifact(e, "", "app.tar.gz", []byte("binary"))
+		if err != nil || url != "https://github.example.com/app.tar.gz" {
+			t.Fatalf("Asset should have been uploaded, got %v %v", url, err)
+		}
+		if uploaded != "binary" || name != "app.tar.gz" {
+			t.Fatalf("Asset content should have been uploaded as app.tar.gz, got %q %q", name, uploaded)
+		}
+	})
+}
+
+func TestGithubPathCommits(t *testing.T) {
+	t.Run("Successfully listed every page of the commits which touch a path", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v3/repos/owner/repo/commits", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("path") != "services/billing" || r.URL.Query().Get("sha") != "develop" {
+				t.Errorf("Commits should have been listed by path and ref, got %v", r.URL.RawQuery)
+			}
+			if r.URL.Query().Get("page") == "" {
+				w.Header().Set("Link", `<https://api.github.com/repos/owner/repo/commits?page=2>; rel="next"`)
+				w.Write([]byte(`[{"sha": "a"}]`))
+				return
+			}
+			w.Write([]byte(`[{"sha": "b"}]`))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		e := releaseEvent{RepoOwner: "owner", RepoName: "repo"}
+		provider, _ := newGithubController(e, repository{BaseURL: server.URL}, "token")
+
+		shas, err := provider.(pathFilterProvider).pathCommits(e, "develop", "services/billing", 10)
+		if err != nil || len(shas) != 2 || !shas["a"] || !shas["b"] {
+			t.Fatalf("Commits of both pages should have been listed, got %v %v", shas, err)
+		}
+	})
+}
+
+func TestGithubWaitForMergeable(t *testing.T) {
+	newController := func(responses ...string) (githubController, *int, func()) {
+		requests := 0
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v3/repos/owner/repo/pulls/7", func(w http.ResponseWriter, r *http.Request) {
+			response := responses[len(responses)-1]
+			if requests < len(responses) {
+				response = responses[requests]
+			}
+			requests++
+			w.Write([]byte(response))
+		})
+		server := httptest.NewServer(mux)
+
+		provider, _ := newGithubController(releaseEvent{}, repository{BaseURL: server.URL}, "token")
+		controller := provider.(githubController)
+		controller.PollInterval = time.Millisecond
+		controller.MergeableTimeout = 50 * time.Millisecond
+		return controller, &requests, server.Close
+	}
+	e := releaseEvent{RepoOwner: "owner", RepoName: "repo"}
+
+	t.Run("Successfully waited for Github to compute mergability", func(t *testing.T) {
+		controller, requests, closeServer := newController(`{"number": 7}`, `{"number": 7}`, `{"number": 7, "mergeable": true}`)
+		defer closeServer()
+
+		err := controller.waitForMergeable(e, pullRequest{Number: 7})
+		if err != nil || *requests != 3 {
+			t.Fatalf("Pull request should have become mergeable on the third request, got %v after %v requests", err, *requests)
+		}
+	})
+
+	t.Run("Mergability which is never computed times out", func(t *testing.T) {
+		controller, requests, closeServer := newController(`{"number": 7}`)
+		defer closeServer()
+
+		start := time.Now()
+		err := controller.waitForMergeable(e, pullRequest{Number: 7})
+		if err == nil || time.Since(start) < 40*time.Millisecond || *requests < 2 {
+			t.Fatalf("Wait should have polled until the timeout, got %v after %v requests", err, *requests)
+		}
+	})
+}
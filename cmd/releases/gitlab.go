@@ -3,36 +3,237 @@ package main
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/seanturner026/moot/internal/util"
 	log "github.com/sirupsen/logrus"
 	"github.com/xanzy/go-gitlab"
 )
 
+// gitlabUpcomingRelease is how far in the future release candidates and draft releases are dated, so
+// that Gitlab lists them as upcoming releases
+const gitlabUpcomingRelease = 365 * 24 * time.Hour
+
+// gitlabMergeableTimeout is how long the release waits for Gitlab to check whether a merge request can
+// be merged
+const gitlabMergeableTimeout = 5 * time.Second
+
 type gitlabController struct {
-	MergeRequestSquash bool
-	RemoveSourceBranch bool
-	ProjectID          string
-	Client             *gitlab.Client
+	MergeRequestSquash  bool
+	MergeRequestRebase  bool
+	RemoveSourceBranch  bool
+	MergeCommitTemplate string
+	ProjectID           string
+	Client              *gitlab.Client
+	PollInterval        time.Duration
+	MergeableTimeout    time.Duration
+}
+
+func newGitlabController(e releaseEvent, repo repository, token string) (releaseProvider, error) {
+	clientGitlab, err := util.NewGitlabClient(token, repo.BaseURL)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create gitlab client, %v", err))
+		return nil, err
+	}
+
+	// NOTE(SMT): Gitlab removed the source branch before it could be configured, so it still does by default
+	removeSourceBranch := true
+	if repo.DeleteSourceBranch != nil {
+		removeSourceBranch = *repo.DeleteSourceBranch
+	}
+
+	return gitlabController{
+		ProjectID:           e.GitlabProjectID,
+		MergeRequestSquash:  repo.MergeMethod == "squash",
+		MergeRequestRebase:  repo.MergeMethod == "rebase",
+		RemoveSourceBranch:  removeSourceBranch,
+		MergeCommitTemplate: repo.MergeCommitTemplate,
+		Client:              clientGitlab,
+		PollInterval:        time.Second,
+		MergeableTimeout:    gitlabMergeableTimeout,
+	}, nil
+}
+
+// compareBranches returns the commits on head which are not on base, and the files they change.
+// Gitlab does not return the commits on base which are not on head, so head is also compared with
+// base.
+func (app gitlabController) compareBranches(e releaseEvent, base, head string) (comparison, error) {
+	input := &gitlab.CompareOptions{
+		From:     gitlab.String(base),
+		To:       gitlab.String(head),
+		Straight: gitlab.Bool(false),
+	}
+
+	log.Info(fmt.Sprintf("comparing %v %v...%v...", e.RepoName, base, head))
+	resp, _, err := app.Client.Repositories.Compare(e.GitlabProjectID, input)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to compare %v %v...%v, %v", e.RepoName, base, head, err))
+		return comparison{}, err
+	}
+
+	behind, _, err := app.Client.Repositories.Compare(e.GitlabProjectID, &gitlab.CompareOptions{
+		From:     gitlab.String(head),
+		To:       gitlab.String(base),
+		Straight: gitlab.Bool(false),
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to compare %v %v...%v, %v", e.RepoName, head, base, err))
+		return comparison{}, err
+	}
+
+	c := comparison{AheadBy: len(resp.Commits), BehindBy: len(behind.Commits)}
+	for _, diff := range resp.Diffs {
+		c.Files = append(c.Files, gitlabChangedFile(diff))
+	}
+	for _, gitlabCommit := range resp.Commits {
+		c.Commits = append(c.Commits, commit{
+			SHA:     gitlabCommit.ID,
+			Message: gitlabCommit.Message,
+			Author:  gitlabCommit.AuthorName,
+			Merge:   len(gitlabCommit.ParentIDs) > 1,
+		})
+	}
+	return c, nil
 }
 
-func (app gitlabController) createMergeRequest(e releaseEvent) (gitlab.MergeRequest, error) {
+// pathCommits returns the SHAs of the latest commits of ref which touch path
+func (app gitlabController) pathCommits(e releaseEvent, ref, path string, limit int) (map[string]bool, error) {
+	shas := map[string]bool{}
+	input := &gitlab.ListCommitsOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		RefName:     gitlab.String(ref),
+		Path:        gitlab.String(path),
+	}
+
+	log.Info(fmt.Sprintf("listing %v commits of %v which touch %v...", e.RepoName, ref, path))
+	for len(shas) < limit {
+		commits, resp, err := app.Client.Commits.ListCommits(e.GitlabProjectID, input)
+		if err != nil {
+			log.Error(fmt.Sprintf("unable to list %v commits which touch %v, %v", e.RepoName, path, err))
+			return nil, err
+		}
+		for _, c := range commits {
+			shas[c.ID] = true
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		input.Page = resp.NextPage
+	}
+	return shas, nil
+}
+
+// gitlabChangedFile counts the lines added and removed by the unified diff of a file
+func gitlabChangedFile(diff *gitlab.Diff) changedFile {
+	file := changedFile{Filename: diff.NewPath, Status: "modified"}
+	switch {
+	case diff.NewFile:
+		file.Status = "added"
+	case diff.DeletedFile:
+		file.Status = "removed"
+		file.Filename = diff.OldPath
+	case diff.RenamedFile:
+		file.Status = "renamed"
+	}
+
+	for _, line := range strings.Split(diff.Diff, "\n") {
+		if strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "+++") {
+			file.Additions++
+		} else if strings.HasPrefix(line, "-") && !strings.HasPrefix(line, "---") {
+			file.Deletions++
+		}
+	}
+	return file
+}
+
+// createPullRequest opens a merge request which merges BranchHead into BranchBase, or reuses the open
+// merge request between them with its title and description updated for the release
+func (app gitlabController) createPullRequest(e releaseEvent) (pullRequest, error) {
+	existing, _, err := app.Client.MergeRequests.ListProjectMergeRequests(e.GitlabProjectID, &gitlab.ListProjectMergeRequestsOptions{
+		State:        gitlab.String("opened"),
+		SourceBranch: gitlab.String(e.BranchHead),
+		TargetBranch: gitlab.String(e.BranchBase),
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to list %v merge requests, %v", e.RepoName, err))
+		return pullRequest{}, err
+	}
+	if len(existing) > 0 {
+		log.Info(fmt.Sprintf("updating existing %v merge request %v...", e.RepoName, existing[0].IID))
+		resp, _, err := app.Client.MergeRequests.UpdateMergeRequest(e.GitlabProjectID, existing[0].IID, &gitlab.UpdateMergeRequestOptions{
+			Title:       gitlab.String(e.pullRequestTitle()),
+			Description: gitlab.String(e.ReleaseBody),
+		})
+		if err != nil {
+			log.Error(fmt.Sprintf("unable to update %v merge request %v, %v", e.RepoName, existing[0].IID, err))
+			return pullRequest{}, err
+		}
+		return pullRequest{Number: resp.IID, URL: resp.WebURL}, nil
+	}
+
 	input := &gitlab.CreateMergeRequestOptions{
-		Title:              gitlab.String(e.ReleaseVersion),
+		Title:              gitlab.String(e.pullRequestTitle()),
 		Description:        gitlab.String(e.ReleaseBody),
 		SourceBranch:       gitlab.String(e.BranchHead),
 		TargetBranch:       gitlab.String(e.BranchBase),
-		RemoveSourceBranch: gitlab.Bool(app.RemoveSourceBranch),
-		Squash:             gitlab.Bool(false),
+		RemoveSourceBranch: gitlab.Bool(app.RemoveSourceBranch && !e.BackMerge),
+		Squash:             gitlab.Bool(app.MergeRequestSquash),
 	}
 
 	log.Info(fmt.Sprintf("creating %v merge request...", e.RepoName))
 	resp, _, err := app.Client.MergeRequests.CreateMergeRequest(e.GitlabProjectID, input)
 	if err != nil {
-		log.Error(fmt.Sprintf("unable to create %v pull request, %v", e.RepoName, err))
-		return *resp, err
+		log.Error(fmt.Sprintf("unable to create %v merge request, %v", e.RepoName, err))
+		return pullRequest{}, err
 	}
 
-	return *resp, nil
+	return pullRequest{Number: resp.IID, URL: resp.WebURL}, nil
+}
+
+// waitForMergeable waits up to MergeableTimeout for the merge request to become mergeable
+func (app gitlabController) waitForMergeable(e releaseEvent, pr pullRequest) error {
+	return app.pollMergeRequestStatus(e, pr.Number)
+}
+
+// mergePullRequest accepts the merge request, returning the SHA of the merge commit. Repositories which
+// merge by rebasing have the merge request rebased onto the target branch first.
+func (app gitlabController) mergePullRequest(e releaseEvent, pr pullRequest) (string, error) {
+	if app.MergeRequestRebase {
+		err := app.rebaseMergeRequest(e, pr.Number)
+		if err != nil {
+			return "", err
+		}
+	}
+	return app.acceptMergeRequest(e, pr.Number, mergeCommitMessage(app.MergeCommitTemplate, e, pr))
+}
+
+// rebaseMergeRequest rebases the merge request onto its target branch, waiting for Gitlab to finish
+// the rebase
+func (app gitlabController) rebaseMergeRequest(e releaseEvent, mergeRequestID int) error {
+	log.Info(fmt.Sprintf("rebasing %v merge request %v...", e.RepoName, mergeRequestID))
+	_, err := app.Client.MergeRequests.RebaseMergeRequest(e.GitlabProjectID, mergeRequestID)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to rebase %v merge request %v, %v", e.RepoName, mergeRequestID, err))
+		return err
+	}
+
+	input := &gitlab.GetMergeRequestsOptions{IncludeRebaseInProgress: gitlab.Bool(true)}
+	for i := 0; i < 7; i++ {
+		resp, _, err := app.Client.MergeRequests.GetMergeRequest(e.GitlabProjectID, mergeRequestID, input)
+		if err != nil {
+			log.Error(fmt.Sprintf("unable to check %v merge request %v rebase, %v", e.RepoName, mergeRequestID, err))
+			return err
+		}
+		if resp.MergeError != "" {
+			return fmt.Errorf("merge request could not be rebased, %v", resp.MergeError)
+		} else if !resp.RebaseInProgress {
+			return nil
+		}
+		time.Sleep(app.PollInterval)
+	}
+
+	return errors.New("merge request rebase never finished")
 }
 
 func (app gitlabController) pollMergeRequestStatus(e releaseEvent, mergeRequestID int) error {
@@ -43,7 +244,8 @@ func (app gitlabController) pollMergeRequestStatus(e releaseEvent, mergeRequestI
 	}
 
 	log.Info(fmt.Sprintf("checking %v merge request %v mergability...", e.RepoName, mergeRequestID))
-	for i := 0; i < 7; i++ {
+	deadline := time.Now().Add(app.MergeableTimeout)
+	for {
 		resp, _, err := app.Client.MergeRequests.GetMergeRequest(e.GitlabProjectID, mergeRequestID, input)
 		if err != nil {
 			log.Error(fmt.Sprintf("unable to check %v merge request %v mergability, %v", e.RepoName, mergeRequestID, err))
@@ -54,25 +256,126 @@ func (app gitlabController) pollMergeRequestStatus(e releaseEvent, mergeRequestI
 		} else if resp.MergeStatus == "cannot_be_merged" {
 			return errors.New("merge request has merge conflicts")
 		}
+
+		if !time.Now().Add(app.PollInterval).Before(deadline) {
+			return errors.New("merge request status never turned mergable")
+		}
+		time.Sleep(app.PollInterval)
 	}
+}
+
+// listChecks returns the jobs of the latest pipeline on the head of the merge request which have not
+// succeeded, or the pipeline itself once it has succeeded. Jobs which are allowed to fail are ignored.
+func (app gitlabController) listChecks(e releaseEvent, pr pullRequest) ([]check, error) {
+	log.Info(fmt.Sprintf("checking %v merge request %v pipeline...", e.RepoName, pr.Number))
+	resp, _, err := app.Client.MergeRequests.GetMergeRequest(e.GitlabProjectID, pr.Number, &gitlab.GetMergeRequestsOptions{})
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to get %v merge request %v, %v", e.RepoName, pr.Number, err))
+		return nil, err
+	}
+
+	pipeline := resp.HeadPipeline
+	if pipeline == nil {
+		return []check{}, nil
+	}
+
+	state := gitlabCheckState(pipeline.Status)
+	if state == "success" {
+		return []check{{Name: fmt.Sprintf("pipeline %d", pipeline.ID), State: state, URL: pipeline.WebURL}}, nil
+	}
+
+	jobs, _, err := app.Client.Jobs.ListPipelineJobs(e.GitlabProjectID, pipeline.ID, &gitlab.ListJobsOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to list %v pipeline %d jobs, %v", e.RepoName, pipeline.ID, err))
+		return nil, err
+	}
+
+	checks := []check{}
+	for _, job := range jobs {
+		jobState := gitlabCheckState(job.Status)
+		if jobState == "success" || job.AllowFailure {
+			continue
+		}
+		checks = append(checks, check{Name: job.Name, State: jobState, URL: job.WebURL})
+	}
+
+	// NOTE(SMT): a pipeline can fail or be pending without any of its jobs doing so, e.g. with YAML errors
+	if len(checks) == 0 {
+		checks = append(checks, check{Name: fmt.Sprintf("pipeline %d", pipeline.ID), State: state, URL: pipeline.WebURL})
+	}
+	return checks, nil
+}
 
-	return errors.New("merge request status never turned mergable")
+// gitlabCheckState maps the status of a Gitlab pipeline or job to the state of a check
+func gitlabCheckState(status string) string {
+	switch status {
+	case "success", "skipped", "manual":
+		return "success"
+	case "failed", "canceled":
+		return "failure"
+	default:
+		return "pending"
+	}
 }
 
-func (app gitlabController) acceptMergeRequest(e releaseEvent, mergeRequestID int) error {
+func (app gitlabController) acceptMergeRequest(e releaseEvent, mergeRequestID int, message string) (string, error) {
 	input := &gitlab.AcceptMergeRequestOptions{
-		MergeCommitMessage:       gitlab.String(fmt.Sprintf("Merging pull request number %v", mergeRequestID)),
-		Squash:                   gitlab.Bool(false),
-		ShouldRemoveSourceBranch: gitlab.Bool(true),
+		MergeCommitMessage:       gitlab.String(message),
+		Squash:                   gitlab.Bool(app.MergeRequestSquash),
+		ShouldRemoveSourceBranch: gitlab.Bool(app.RemoveSourceBranch && !e.BackMerge),
+	}
+	if app.MergeRequestSquash {
+		input.SquashCommitMessage = gitlab.String(message)
 	}
 
 	log.Info(fmt.Sprintf("completing %v merge request %v...", e.RepoName, mergeRequestID))
-	_, _, err := app.Client.MergeRequests.AcceptMergeRequest(e.GitlabProjectID, mergeRequestID, input)
+	resp, _, err := app.Client.MergeRequests.AcceptMergeRequest(e.GitlabProjectID, mergeRequestID, input)
 	if err != nil {
 		log.Error(fmt.Sprintf("unable to merge %v merge request %v, %v", e.RepoName, mergeRequestID, err))
+		return "", err
+	}
+
+	// NOTE(SMT): projects which fast-forward merge have no merge commit, so the squashed commit or head of
+	// the merge request is what landed on the target branch
+	if resp.MergeCommitSHA == "" && resp.SquashCommitSHA != "" {
+		return resp.SquashCommitSHA, nil
+	} else if resp.MergeCommitSHA == "" {
+		return resp.SHA, nil
+	}
+	return resp.MergeCommitSHA, nil
+}
+
+// tagCommit returns the ID of the commit which the tag points at
+func (app gitlabController) tagCommit(e releaseEvent, tag string) (string, error) {
+	resp, _, err := app.Client.Tags.GetTag(e.GitlabProjectID, tag)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to get %v tag %v, %v", e.RepoName, tag, err))
+		return "", err
+	}
+	if resp.Commit == nil {
+		return "", fmt.Errorf("tag %v does not point at a commit", tag)
+	}
+	return resp.Commit.ID, nil
+}
+
+// createRevertBranch creates branch from BranchBase, and reverts the merge commit sha on it
+func (app gitlabController) createRevertBranch(e releaseEvent, branch, sha string) error {
+	_, _, err := app.Client.Branches.CreateBranch(e.GitlabProjectID, &gitlab.CreateBranchOptions{
+		Branch: gitlab.String(branch),
+		Ref:    gitlab.String(e.BranchBase),
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create %v branch %v, %v", e.RepoName, branch, err))
 		return err
 	}
 
+	_, _, err = app.Client.Commits.RevertCommit(e.GitlabProjectID, sha, &gitlab.RevertCommitOptions{Branch: gitlab.String(branch)})
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to revert %v commit %v, %v", e.RepoName, sha, err))
+		return err
+	}
 	return nil
 }
 
@@ -81,7 +384,13 @@ func (app gitlabController) createRelease(e releaseEvent) error {
 		Name:        gitlab.String(e.ReleaseVersion),
 		TagName:     gitlab.String(e.ReleaseVersion),
 		Description: gitlab.String(e.ReleaseBody),
-		Ref:         gitlab.String(e.BranchBase),
+		Ref:         gitlab.String(e.releaseTarget()),
+	}
+
+	// NOTE(SMT): Gitlab has no prerelease or draft flag, and lists releases whose release date is in the
+	// future as upcoming releases instead
+	if e.Prerelease || e.Draft {
+		input.ReleasedAt = gitlab.Time(time.Now().Add(gitlabUpcomingRelease))
 	}
 
 	log.Info(fmt.Sprintf("releasing %v version %v...", e.RepoName, e.ReleaseVersion))
@@ -94,55 +403,93 @@ func (app gitlabController) createRelease(e releaseEvent) error {
 	return nil
 }
 
-func (app application) releasesGitlabHandler(e releaseEvent, token string) (string, int) {
-	clientGitlab, err := gitlab.NewClient(token)
+// createDraftRelease creates the release as an upcoming release. Gitlab identifies releases by their
+// tag, which it creates straight away.
+func (app gitlabController) createDraftRelease(e releaseEvent) (string, error) {
+	err := app.createRelease(e)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		return "", err
 	}
-	app.GL = gitlabController{
-		ProjectID:          e.GitlabProjectID,
-		MergeRequestSquash: false,
-		RemoveSourceBranch: true,
-		Client:             clientGitlab,
+	return e.ReleaseVersion, nil
+}
+
+func (app gitlabController) updateRelease(e releaseEvent, tag string, input *gitlab.UpdateReleaseOptions) error {
+	// NOTE(SMT): Gitlab clears the name and description of a release when they are not sent
+	input.Name = gitlab.String(e.ReleaseVersion)
+	input.Description = gitlab.String(e.ReleaseBody)
+
+	_, _, err := app.Client.Releases.UpdateRelease(e.GitlabProjectID, tag, input)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to update %v release %v, %v", e.RepoName, tag, err))
+		return err
 	}
+	return nil
+}
 
-	if !e.Hotfix {
-		createMergeRequestResp, err := app.GL.createMergeRequest(e)
-		if err != nil {
-			message := fmt.Sprintf("Unable to create %v merge request, please check the merge request on gitlab for further details", e.RepoName)
-			statusCode := 400
-			return message, statusCode
-		}
+// publishRelease dates the upcoming release now
+func (app gitlabController) publishRelease(e releaseEvent, id string) error {
+	log.Info(fmt.Sprintf("publishing %v release version %v...", e.RepoName, e.ReleaseVersion))
+	return app.updateRelease(e, id, &gitlab.UpdateReleaseOptions{ReleasedAt: gitlab.Time(time.Now())})
+}
 
-		err = app.GL.pollMergeRequestStatus(e, createMergeRequestResp.IID)
-		if err != nil {
-			message := fmt.Sprintf("Unable to merge %v merge request %v, please check the merge request on gitlab for further details",
-				e.RepoName,
-				createMergeRequestResp.IID)
-			statusCode := 400
-			return message, statusCode
-		}
+// publishCandidate dates the upcoming release of a promoted release candidate now
+func (app gitlabController) publishCandidate(e releaseEvent, tag string) error {
+	log.Info(fmt.Sprintf("publishing %v release candidate %v...", e.RepoName, tag))
+	release, _, err := app.Client.Releases.GetRelease(e.GitlabProjectID, tag)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to get %v release %v, %v", e.RepoName, tag, err))
+		return err
+	}
 
-		err = app.GL.acceptMergeRequest(e, createMergeRequestResp.IID)
-		if err != nil {
-			message := fmt.Sprintf("Unable to complete %v merge request %v, please check the merge request on gitlab for further details",
-				e.RepoName,
-				createMergeRequestResp.IID)
-			statusCode := 400
-			return message, statusCode
-		}
+	// NOTE(SMT): the candidate keeps its own name and notes, which Gitlab clears when they are not sent
+	input := &gitlab.UpdateReleaseOptions{
+		Name:        gitlab.String(release.Name),
+		Description: gitlab.String(release.Description),
+		ReleasedAt:  gitlab.Time(time.Now()),
+	}
+	_, _, err = app.Client.Releases.UpdateRelease(e.GitlabProjectID, tag, input)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to update %v release %v, %v", e.RepoName, tag, err))
+		return err
+	}
+	return nil
+}
+
+func (app gitlabController) updateDraftRelease(e releaseEvent, id string) error {
+	log.Info(fmt.Sprintf("updating %v draft release version %v...", e.RepoName, e.ReleaseVersion))
+	return app.updateRelease(e, id, &gitlab.UpdateReleaseOptions{})
+}
+
+// uploadArtifact uploads content to the project and links it from the release, as Gitlab releases
+// only hold links
+func (app gitlabController) uploadArtifact(e releaseEvent, draftID, name string, content []byte) (string, error) {
+	path, cleanup, err := writeArtifactFile(name, content)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	file, _, err := app.Client.Projects.UploadFile(e.GitlabProjectID, path)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to upload %v file %v, %v", e.RepoName, name, err))
+		return "", err
 	}
 
-	err = app.GL.createRelease(e)
+	// NOTE(SMT): uploads are addressed relative to the project's web URL
+	project, _, err := app.Client.Projects.GetProject(e.GitlabProjectID, nil)
 	if err != nil {
-		message := fmt.Sprintf("Unable to create %v release", e.RepoName)
-		statusCode := 400
-		return message, statusCode
+		log.Error(fmt.Sprintf("unable to read %v project, %v", e.RepoName, err))
+		return "", err
 	}
+	downloadURL := strings.TrimSuffix(project.WebURL, "/") + file.URL
 
-	message := fmt.Sprintf("Created %v release version %v on Gitlab.",
-		e.RepoName,
-		e.ReleaseVersion)
-	statusCode := 200
-	return message, statusCode
+	_, _, err = app.Client.ReleaseLinks.CreateReleaseLink(e.GitlabProjectID, e.ReleaseVersion, &gitlab.CreateReleaseLinkOptions{
+		Name: gitlab.String(name),
+		URL:  gitlab.String(downloadURL),
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to link %v release %v file %v, %v", e.RepoName, e.ReleaseVersion, name, err))
+		return "", err
+	}
+	return downloadURL, nil
 }
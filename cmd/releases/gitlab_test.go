@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGitlabMergePullRequest(t *testing.T) {
+	t.Run("Successfully squashed without removing the source branch", func(t *testing.T) {
+		accept := map[string]interface{}{}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v4/projects/1/merge_requests/3/merge", func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewDecoder(r.Body).Decode(&accept)
+			w.Write([]byte(`{"iid": 3, "merge_commit_sha": "abc"}`))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		deleteSourceBranch := false
+		repo := repository{
+			BaseURL:             server.URL,
+			MergeMethod:         "squash",
+			MergeCommitTemplate: "Release {{.Version}}",
+			DeleteSourceBranch:  &deleteSourceBranch,
+		}
+		e := releaseEvent{RepoName: "repo", GitlabProjectID: "1", ReleaseVersion: "1.2.0"}
+		provider, _ := newGitlabController(e, repo, "token")
+
+		sha, err := provider.mergePullRequest(e, pullRequest{Number: 3})
+		if err != nil || sha != "abc" {
+			t.Fatalf("Merge request should have been merged, got %v %v", sha, err)
+		}
+		if accept["squash"] != true || accept["should_remove_source_branch"] != false || accept["squash_commit_message"] != "Release 1.2.0" {
+			t.Fatalf("Merge request should have been squashed and kept its source branch, got %+v", accept)
+		}
+	})
+
+	t.Run("Successfully rebased before merging", func(t *testing.T) {
+		rebased := false
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v4/projects/1/merge_requests/3/rebase", func(w http.ResponseWriter, r *http.Request) {
+			rebased = true
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"rebase_in_progress": true}`))
+		})
+		mux.HandleFunc("/api/v4/projects/1/merge_requests/3", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"iid": 3, "rebase_in_progress": false}`))
+		})
+		mux.HandleFunc("/api/v4/projects/1/merge_requests/3/merge", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"iid": 3, "sha": "def"}`))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		e := releaseEvent{RepoName: "repo", GitlabProjectID: "1"}
+		provider, _ := newGitlabController(e, repository{BaseURL: server.URL, MergeMethod: "rebase"}, "token")
+
+		sha, err := provider.mergePullRequest(e, pullRequest{Number: 3})
+		if err != nil || !rebased || sha != "def" {
+			t.Fatalf("Merge request should have been rebased and fast-forwarded, got %v %v", sha, err)
+		}
+	})
+}
+
+func TestGitlabCreatePullRequest(t *testing.T) {
+	t.Run("Successfully reused an open merge request", func(t *testing.T) {
+		update := map[string]string{}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v4/projects/1/merge_requests", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				t.Error("merge request should not have been created")
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			if r.URL.Query().Get("source_branch") != "develop" || r.URL.Query().Get("state") != "opened" {
+				t.Errorf("merge requests should be filtered by branch, got %v", r.URL.RawQuery)
+			}
+			w.Write([]byte(`[{"iid": 4}]`))
+		})
+		mux.HandleFunc("/api/v4/projects/1/merge_requests/4", func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewDecoder(r.Body).Decode(&update)
+			w.Write([]byte(`{"iid": 4, "web_url": "https://gitlab.com/owner/repo/-/merge_requests/4"}`))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		e := releaseEvent{RepoName: "repo", GitlabProjectID: "1", BranchBase: "main", BranchHead: "develop", ReleaseVersion: "1.2.0", ReleaseBody: "notes"}
+		provider, _ := newGitlabController(e, repository{BaseURL: server.URL}, "token")
+
+		pr, err := provider.createPullRequest(e)
+		if err != nil || pr.Number != 4 {
+			t.Fatalf("Open merge request should have been reused, got %+v %v", pr, err)
+		}
+		if update["title"] != "1.2.0" || update["description"] != "notes" {
+			t.Fatalf("Merge request should have been updated for the release, got %+v", update)
+		}
+	})
+}
+
+func TestGitlabUploadArtifact(t *testing.T) {
+	t.Run("Successfully uploaded a file and linked it from the release", func(t *testing.T) {
+		uploaded, filename := "", ""
+		link := map[string]string{}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v4/projects/1/uploads", func(w http.ResponseWriter, r *http.Request) {
+			file, header, err := r.FormFile("file")
+			if err == nil {
+				body, _ := ioutil.ReadAll(file)
+				uploaded, filename = string(body), header.Filename
+			}
+			w.Write([]byte(`{"url": "/uploads/secret/app.tar.gz"}`))
+		})
+		mux.HandleFunc("/api/v4/projects/1", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"id": 1, "web_url": "https://gitlab.example.com/group/repo"}`))
+		})
+		mux.HandleFunc("/api/v4/projects/1/releases/1.2.0/assets/links", func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewDecoder(r.Body).Decode(&link)
+			w.Write([]byte(`{"id": 5}`))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		e := releaseEvent{RepoName: "repo", GitlabProjectID: "1", ReleaseVersion: "1.2.0"}
+		provider, _ := newGitlabController(e, repository{BaseURL: server.URL}, "token")
+
+		url, err := provider.(artifactProvider).uploadArtifact(e, "", "app.tar.gz", []byte("binary"))
+		want := "https://gitlab.example.com/group/repo/uploads/secret/app.tar.gz"
+		if err != nil || url != want {
+			t.Fatalf("File should have been uploaded, got %v %v", url, err)
+		}
+		if uploaded != "binary" || filename != "app.tar.gz" {
+			t.Fatalf("File content should have been uploaded as app.tar.gz, got %q %q", filename, uploaded)
+		}
+		if link["name"] != "app.tar.gz" || link["url"] != want {
+			t.Fatalf("Release should have linked the upload, got %+v", link)
+		}
+	})
+}
+
+func TestGitlabWaitForMergeable(t *testing.T) {
+	newController := func(responses ...string) (gitlabController, *int, func()) {
+		requests := 0
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v4/projects/1/merge_requests/3", func(w http.ResponseWriter, r *http.Request) {
+			response := responses[len(responses)-1]
+			if requests < len(responses) {
+				response = responses[requests]
+			}
+			requests++
+			w.Write([]byte(response))
+		})
+		server := httptest.NewServer(mux)
+
+		provider, _ := newGitlabController(releaseEvent{}, repository{BaseURL: server.URL}, "token")
+		controller := provider.(gitlabController)
+		controller.PollInterval = 10 * time.Millisecond
+		controller.MergeableTimeout = 100 * time.Millisecond
+		return controller, &requests, server.Close
+	}
+	e := releaseEvent{RepoName: "repo", GitlabProjectID: "1"}
+
+	t.Run("Successfully waited for Gitlab to check mergability", func(t *testing.T) {
+		controller, requests, closeServer := newController(`{"iid": 3, "merge_status": "checking"}`, `{"iid": 3, "merge_status": "checking"}`, `{"iid": 3, "merge_status": "can_be_merged"}`)
+		defer closeServer()
+
+		start := time.Now()
+		err := controller.waitForMergeable(e, pullRequest{Number: 3})
+		if err != nil || *requests != 3 {
+			t.Fatalf("Merge request should have become mergeable on the third request, got %v after %v requests", err, *requests)
+		}
+		if time.Since(start) < 2*controller.PollInterval {
+			t.Fatalf("Requests should have been a poll interval apart, took %v", time.Since(start))
+		}
+	})
+
+	t.Run("Merge request with conflicts is not mergeable", func(t *testing.T) {
+		controller, requests, closeServer := newController(`{"iid": 3, "merge_status": "cannot_be_merged"}`)
+		defer closeServer()
+
+		err := controller.waitForMergeable(e, pullRequest{Number: 3})
+		if err == nil || *requests != 1 {
+			t.Fatalf("Merge request should have had conflicts, got %v after %v requests", err, *requests)
+		}
+	})
+
+	t.Run("Mergability which is never checked times out", func(t *testing.T) {
+		controller, requests, closeServer := newController(`{"iid": 3, "merge_status": "checking"}`)
+		defer closeServer()
+
+		start := time.Now()
+		err := controller.waitForMergeable(e, pullRequest{Number: 3})
+		if err == nil || time.Since(start) < 80*time.Millisecond || *requests > 11 {
+			t.Fatalf("Wait should have polled until the timeout, got %v after %v requests", err, *requests)
+		}
+	})
+}
+
+func TestGitlabPublishCandidate(t *testing.T) {
+	t.Run("Successfully dated the promoted release candidate now", func(t *testing.T) {
+		update := map[string]interface{}{}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v4/projects/1/releases/1.3.0-rc.2", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut {
+				_ = json.NewDecoder(r.Body).Decode(&update)
+			}
+			w.Write([]byte(`{"tag_name": "1.3.0-rc.2", "name": "1.3.0-rc.2", "description": "notes"}`))
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		e := releaseEvent{RepoName: "repo", GitlabProjectID: "1", ReleaseVersion: "1.3.0"}
+		provider, _ := newGitlabController(e, repository{BaseURL: server.URL}, "token")
+
+		err := provider.(gitlabController).publishCandidate(e, "1.3.0-rc.2")
+		if err != nil {
+			t.Fatalf("Release candidate should have been published, %v", err)
+		}
+		releasedAt, _ := time.Parse(time.RFC3339, fmt.Sprintf("%v", update["released_at"]))
+		if update["name"] != "1.3.0-rc.2" || update["description"] != "notes" || time.Since(releasedAt) > time.Minute {
+			t.Fatalf("Release candidate should have kept its notes and been dated now, got %+v", update)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	log "github.com/sirupsen/logrus"
+)
+
+// historyTimeFormat is fixed width so that sort keys order chronologically
+const historyTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// releaseRecord is a single release attempt, stored in DynamoDB as the release history of a
+// repository
+type releaseRecord struct {
+	PK                string `dynamodbav:"PK"                          json:"-"`
+	SK                string `dynamodbav:"SK"                          json:"-"`
+	RepoProvider      string `dynamodbav:"RepoProvider"                json:"repo_provider"`
+	RepoName          string `dynamodbav:"RepoName"                    json:"repo_name"`
+	Component         string `dynamodbav:"Component,omitempty"         json:"component,omitempty"`
+	ReleaseVersion    string `dynamodbav:"ReleaseVersion"              json:"release_version"`
+	Hotfix            bool   `dynamodbav:"Hotfix"                      json:"hotfix"`
+	Prerelease        bool   `dynamodbav:"Prerelease,omitempty"        json:"prerelease,omitempty"`
+	PromotedFrom      string `dynamodbav:"PromotedFrom,omitempty"      json:"promoted_from,omitempty"`
+	Draft             bool   `dynamodbav:"Draft,omitempty"             json:"draft,omitempty"`
+	DraftReleaseID    string `dynamodbav:"DraftReleaseID,omitempty"    json:"draft_release_id,omitempty"`
+	ReleaseBody       string `dynamodbav:"ReleaseBody,omitempty"       json:"release_body,omitempty"`
+	PublishedBy       string `dynamodbav:"PublishedBy,omitempty"       json:"published_by,omitempty"`
+	PublishedAt       string `dynamodbav:"PublishedAt,omitempty"       json:"published_at,omitempty"`
+	PullRequestNumber int    `dynamodbav:"PullRequestNumber,omitempty" json:"pull_request_number,omitempty"`
+	PullRequestURL    string `dynamodbav:"PullRequestURL,omitempty"    json:"pull_request_url,omitempty"`
+	MergeSHA          string `dynamodbav:"MergeSHA,omitempty"          json:"merge_sha,omitempty"`
+	BackMergeURL      string `dynamodbav:"BackMergeURL,omitempty"      json:"back_merge_url,omitempty"`
+	Actor             string `dynamodbav:"Actor,omitempty"             json:"actor,omitempty"`
+	FreezeOverride    string `dynamodbav:"FreezeOverride,omitempty"    json:"freeze_override,omitempty"`
+	RollbackTo        string `dynamodbav:"RollbackTo,omitempty"        json:"rollback_to,omitempty"`
+	ApprovalRequestID string `dynamodbav:"ApprovalRequestID,omitempty" json:"approval_request_id,omitempty"`
+	TrainID           string `dynamodbav:"TrainID,omitempty"           json:"train_id,omitempty"`
+	StartedAt         string `dynamodbav:"StartedAt"                   json:"started_at"`
+	CompletedAt       string `dynamodbav:"CompletedAt"                 json:"completed_at"`
+	Outcome           string `dynamodbav:"Outcome"                     json:"outcome"`
+	Message           string `dynamodbav:"Message"                     json:"message"`
+
+	Artifacts []releasedArtifact `dynamodbav:"Artifacts,omitempty" json:"artifacts,omitempty"`
+}
+
+// historyPartitionKey returns the partition which holds the release history of a repository
+func historyPartitionKey(provider, name string) string {
+	return fmt.Sprintf("release#%s#%s", provider, name)
+}
+
+// requestActor returns the Cognito user who made the request
+func requestActor(event events.APIGatewayV2HTTPRequest) string {
+	if event.RequestContext.Authorizer == nil || event.RequestContext.Authorizer.JWT == nil {
+		return ""
+	}
+
+	claims := event.RequestContext.Authorizer.JWT.Claims
+	for _, claim := range []string{"email", "username", "sub"} {
+		if claims[claim] != "" {
+			return claims[claim]
+		}
+	}
+	return ""
+}
+
+func newReleaseRecord(e releaseEvent, actor string, startedAt time.Time) releaseRecord {
+	started := startedAt.UTC().Format(historyTimeFormat)
+	return releaseRecord{
+		PK:                historyPartitionKey(e.RepoProvider, e.RepoName),
+		SK:                fmt.Sprintf("%s#%s", started, e.ReleaseVersion),
+		RepoProvider:      e.RepoProvider,
+		RepoName:          e.RepoName,
+		Component:         e.Component,
+		ReleaseVersion:    e.ReleaseVersion,
+		Hotfix:            e.Hotfix,
+		Prerelease:        e.Prerelease,
+		PromotedFrom:      e.Promote,
+		Draft:             e.Draft,
+		Actor:             actor,
+		StartedAt:         started,
+		ApprovalRequestID: e.ApprovalRequestID,
+	}
+}
+
+// complete sets the outcome of the release from the result of the release workflow
+func (r *releaseRecord) complete(message string, statusCode int, completedAt time.Time) {
+	r.CompletedAt = completedAt.UTC().Format(historyTimeFormat)
+	r.Message = message
+	r.Outcome = "succeeded"
+	if statusCode != 200 {
+		r.Outcome = "failed"
+	}
+}
+
+func (app awsController) putReleaseRecord(r releaseRecord) error {
+	item, err := dynamodbattribute.MarshalMap(r)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal release record, %v", err))
+		return err
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(app.TableName),
+	}
+
+	log.Info(fmt.Sprintf("recording %v release %v...", r.RepoName, r.ReleaseVersion))
+	_, err = app.DB.PutItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return err
+	}
+	return nil
+}
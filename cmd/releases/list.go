@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultListLimit = 25
+	maxListLimit     = 100
+)
+
+// releaseListQuery is read from the query string of a /releases/list request
+type releaseListQuery struct {
+	RepoProvider   string
+	RepoName       string
+	ReleaseVersion string
+	Limit          int64
+	NextToken      string
+}
+
+// releaseListResponse is a page of release history, newest first. NextToken is empty on the last page.
+type releaseListResponse struct {
+	Releases  []releaseRecord `json:"releases"`
+	NextToken string          `json:"next_token,omitempty"`
+}
+
+func parseReleaseListQuery(params map[string]string) (releaseListQuery, error) {
+	q := releaseListQuery{
+		RepoProvider:   params["repo_provider"],
+		RepoName:       params["repo_name"],
+		ReleaseVersion: params["release_version"],
+		Limit:          defaultListLimit,
+		NextToken:      params["next_token"],
+	}
+
+	if q.RepoProvider == "" || q.RepoName == "" {
+		return q, errors.New("repo_provider and repo_name are required")
+	}
+
+	if params["limit"] != "" {
+		limit, err := strconv.ParseInt(params["limit"], 10, 64)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// encodeNextToken hides the DynamoDB key of the last item in the page from the client
+func encodeNextToken(key map[string]*dynamodb.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+
+	k := map[string]string{}
+	err := dynamodbattribute.UnmarshalMap(key, &k)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(k)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+func decodeNextToken(token string) (map[string]*dynamodb.AttributeValue, error) {
+	b, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	k := map[string]string{}
+	err = json.Unmarshal(b, &k)
+	if err != nil {
+		return nil, err
+	}
+	return dynamodbattribute.MarshalMap(k)
+}
+
+func (app awsController) listReleases(q releaseListQuery) (dynamodb.QueryOutput, error) {
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":primary_key": {
+				S: aws.String(historyPartitionKey(q.RepoProvider, q.RepoName)),
+			}},
+		KeyConditionExpression: aws.String("PK = :primary_key"),
+		Limit:                  aws.Int64(q.Limit),
+		ScanIndexForward:       aws.Bool(false),
+		Select:                 aws.String("ALL_ATTRIBUTES"),
+		TableName:              aws.String(app.TableName),
+	}
+
+	// NOTE(SMT): the filter is applied after the limit, so a page can hold fewer items than the limit
+	// while still returning a next_token
+	if q.ReleaseVersion != "" {
+		input.ExpressionAttributeValues[":release_version"] = &dynamodb.AttributeValue{S: aws.String(q.ReleaseVersion)}
+		input.FilterExpression = aws.String("ReleaseVersion = :release_version")
+	}
+
+	if q.NextToken != "" {
+		key, err := decodeNextToken(q.NextToken)
+		if err != nil {
+			log.Error(fmt.Sprintf("unable to decode next_token, %v", err))
+			return dynamodb.QueryOutput{}, err
+		}
+		input.ExclusiveStartKey = key
+	}
+
+	resp, err := app.DB.Query(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return dynamodb.QueryOutput{}, err
+	}
+	return *resp, err
+}
+
+// findRelease returns the newest release history record of the version which matches. Every page of
+// the history is read until a record matches, as the version filter is applied after each page's limit.
+func (app awsController) findRelease(provider, name, version string, match func(releaseRecord) bool) (releaseRecord, bool, error) {
+	q := releaseListQuery{
+		RepoProvider:   provider,
+		RepoName:       name,
+		ReleaseVersion: version,
+		Limit:          maxListLimit,
+	}
+
+	for {
+		output, err := app.listReleases(q)
+		if err != nil {
+			return releaseRecord{}, false, err
+		}
+
+		records := []releaseRecord{}
+		err = dynamodbattribute.UnmarshalListOfMaps(output.Items, &records)
+		if err != nil {
+			return releaseRecord{}, false, err
+		}
+		for _, r := range records {
+			if match(r) {
+				return r, true, nil
+			}
+		}
+
+		if len(output.LastEvaluatedKey) == 0 {
+			return releaseRecord{}, false, nil
+		}
+		q.NextToken, err = encodeNextToken(output.LastEvaluatedKey)
+		if err != nil {
+			return releaseRecord{}, false, err
+		}
+	}
+}
+
+func (app application) releasesListHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	q, err := parseReleaseListQuery(event.QueryStringParameters)
+	if err != nil {
+		message := fmt.Sprintf("Unable to list releases, %v", err)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	output, err := app.AWS.listReleases(q)
+	if err != nil {
+		message := fmt.Sprintf("Failed to query releases for %v", q.RepoName)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	resp := releaseListResponse{Releases: []releaseRecord{}}
+	err = dynamodbattribute.UnmarshalListOfMaps(output.Items, &resp.Releases)
+	if err != nil {
+		message := "Failed to read releases response"
+		statusCode := 400
+		return message, statusCode
+	}
+
+	resp.NextToken, err = encodeNextToken(output.LastEvaluatedKey)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to encode next_token, %v", err))
+		message := "Failed to read releases response"
+		statusCode := 400
+		return message, statusCode
+	}
+
+	body, err := json.Marshal(resp)
+	statusCode := 200
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal json for response, %v", err))
+		statusCode = 400
+	}
+
+	var buf bytes.Buffer
+	json.HTMLEscape(&buf, body)
+	return buf.String(), statusCode
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/seanturner026/moot/internal/util"
+)
+
+func releaseListBody(t *testing.T, resp events.APIGatewayV2HTTPResponse) releaseListResponse {
+	body := util.ResponseBody{}
+	err := json.Unmarshal([]byte(resp.Body), &body)
+	if err != nil {
+		t.Fatalf("Unable to read response body, %v", err)
+	}
+
+	list := releaseListResponse{}
+	err = json.Unmarshal([]byte(body.Message), &list)
+	if err != nil {
+		t.Fatalf("Unable to read release list, %v", err)
+	}
+	return list
+}
+
+func TestReleasesListHandler(t *testing.T) {
+	t.Run("Successfully listed releases newest first with a next token", func(t *testing.T) {
+		record, _ := dynamodbattribute.MarshalMap(releaseRecord{
+			PK:             historyPartitionKey("github", "test"),
+			SK:             "2021-06-01T00:00:00.000000000Z#1.4.2",
+			RepoProvider:   "github",
+			RepoName:       "test",
+			ReleaseVersion: "1.4.2",
+			Actor:          "jane",
+			Outcome:        "succeeded",
+		})
+		queries := []*dynamodb.QueryInput{}
+		app := application{AWS: awsController{
+			TableName: "test",
+			DB: mockDynamoDB{
+				QueryInputs: &queries,
+				QueryResponse: &dynamodb.QueryOutput{
+					Items: []map[string]*dynamodb.AttributeValue{record},
+					LastEvaluatedKey: map[string]*dynamodb.AttributeValue{
+						"PK": {S: aws.String(historyPartitionKey("github", "test"))},
+						"SK": {S: aws.String("2021-06-01T00:00:00.000000000Z#1.4.2")},
+					},
+				},
+			},
+		}}
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath:               "/releases/list",
+			QueryStringParameters: map[string]string{"repo_provider": "github", "repo_name": "test", "limit": "1"},
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Listing releases should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+
+		list := releaseListBody(t, resp)
+		if len(list.Releases) != 1 || list.Releases[0].ReleaseVersion != "1.4.2" || list.Releases[0].Actor != "jane" {
+			t.Fatalf("Release 1.4.2 should have been listed, got %v", list.Releases)
+		}
+		if list.NextToken == "" {
+			t.Fatal("Next token should have been returned")
+		}
+		if aws.BoolValue(queries[0].ScanIndexForward) || aws.Int64Value(queries[0].Limit) != 1 {
+			t.Fatal("Query should have returned one release, newest first")
+		}
+
+		_, _ = app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath:               "/releases/list",
+			QueryStringParameters: map[string]string{"repo_provider": "github", "repo_name": "test", "next_token": list.NextToken},
+		})
+		if aws.StringValue(queries[1].ExclusiveStartKey["SK"].S) != "2021-06-01T00:00:00.000000000Z#1.4.2" {
+			t.Fatal("Next token should have been used as the exclusive start key")
+		}
+	})
+
+	t.Run("Repository is required", func(t *testing.T) {
+		app := application{AWS: awsController{TableName: "test", DB: mockDynamoDB{}}}
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath:               "/releases/list",
+			QueryStringParameters: map[string]string{"repo_provider": "github"},
+		})
+		if resp.StatusCode != 400 {
+			t.Fatalf("Listing releases without repo_name should have failed, got %v", resp.StatusCode)
+		}
+	})
+
+	t.Run("Limit out of range is rejected", func(t *testing.T) {
+		_, err := parseReleaseListQuery(map[string]string{"repo_provider": "github", "repo_name": "test", "limit": "500"})
+		if err == nil {
+			t.Fatal("Limit above the maximum should have been rejected")
+		}
+	})
+}
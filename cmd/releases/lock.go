@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	log "github.com/sirupsen/logrus"
+)
+
+// releaseLockMargin is how much longer than the Lambda timeout a release lock is held before it
+// expires, so the lock of a release which timed out before releasing it expires shortly after
+const releaseLockMargin = time.Second
+
+// defaultLambdaTimeout is the timeout of the releases lambda when LAMBDA_TIMEOUT_SECONDS is not set
+const defaultLambdaTimeout = 29 * time.Second
+
+var errReleaseLocked = errors.New("a release is already in progress")
+
+// releaseLock prevents concurrent releases of a repository. It is released when the release
+// finishes, or once ExpiresAt has passed if the Lambda timed out.
+type releaseLock struct {
+	PK         string `dynamodbav:"PK"`
+	SK         string `dynamodbav:"SK"`
+	LockID     string `dynamodbav:"LockID"`
+	Holder     string `dynamodbav:"Holder"`
+	AcquiredAt string `dynamodbav:"AcquiredAt"`
+	ExpiresAt  int64  `dynamodbav:"ExpiresAt"`
+}
+
+// validateTimeouts returns an error unless a release can wait for its checks and download its
+// artifacts within the Lambda timeout, which the lease of its lock is derived from
+func (c configuration) validateTimeouts() error {
+	if c.ChecksTimeout+artifactTimeout >= c.LambdaTimeout {
+		return fmt.Errorf("checks timeout of %v and artifact timeout of %v must fit within the lambda timeout of %v", c.ChecksTimeout, artifactTimeout, c.LambdaTimeout)
+	}
+	return nil
+}
+
+func newReleaseLock(e releaseEvent, lockID, holder string, now time.Time, lease time.Duration) releaseLock {
+	if holder == "" {
+		holder = "an unknown user"
+	}
+
+	return releaseLock{
+		PK:         "lock",
+		SK:         fmt.Sprintf("%s#%s", e.RepoProvider, e.RepoName),
+		LockID:     lockID,
+		Holder:     holder,
+		AcquiredAt: now.UTC().Format(time.RFC3339),
+		ExpiresAt:  now.Add(lease).Unix(),
+	}
+}
+
+// acquireReleaseLock writes the lock unless another unexpired lock is held for the repository, in
+// which case errReleaseLocked is returned along with the lock which is held
+func (app awsController) acquireReleaseLock(lock releaseLock, now time.Time) (releaseLock, error) {
+	item, err := dynamodbattribute.MarshalMap(lock)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal release lock, %v", err))
+		return lock, err
+	}
+
+	input := &dynamodb.PutItemInput{
+		ConditionExpression: aws.String("attribute_not_exists(PK) OR ExpiresAt < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {
+				N: aws.String(strconv.FormatInt(now.Unix(), 10)),
+			},
+		},
+		Item:      item,
+		TableName: aws.String(app.TableName),
+	}
+
+	log.Info(fmt.Sprintf("locking %v for release...", lock.SK))
+	_, err = app.DB.PutItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		held, err := app.getReleaseLock(lock)
+		if err != nil {
+			return lock, err
+		}
+		log.Info(fmt.Sprintf("%v is locked by %v since %v", lock.SK, held.Holder, held.AcquiredAt))
+		return held, errReleaseLocked
+	} else if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return lock, err
+	}
+	return lock, nil
+}
+
+func (app awsController) getReleaseLock(lock releaseLock) (releaseLock, error) {
+	input := &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String(lock.PK),
+			},
+			"SK": {
+				S: aws.String(lock.SK),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String(app.TableName),
+	}
+
+	held := releaseLock{}
+	resp, err := app.DB.GetItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return held, err
+	}
+
+	err = dynamodbattribute.UnmarshalMap(resp.Item, &held)
+	return held, err
+}
+
+// releaseReleaseLock deletes the lock, unless it has expired and been acquired by another release
+func (app awsController) releaseReleaseLock(lock releaseLock) error {
+	input := &dynamodb.DeleteItemInput{
+		ConditionExpression: aws.String("LockID = :lock_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":lock_id": {
+				S: aws.String(lock.LockID),
+			},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String(lock.PK),
+			},
+			"SK": {
+				S: aws.String(lock.SK),
+			},
+		},
+		TableName: aws.String(app.TableName),
+	}
+
+	log.Info(fmt.Sprintf("unlocking %v...", lock.SK))
+	_, err := app.DB.DeleteItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return err
+	}
+	return nil
+}
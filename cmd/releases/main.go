@@ -1,9 +1,14 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -11,15 +16,21 @@ import (
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	awslambda "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/seanturner026/moot/internal/githubapp"
 	"github.com/seanturner026/moot/internal/util"
 	log "github.com/sirupsen/logrus"
 )
 
 // releaseEvent is an API Gateway POST which contains information necessary to create a release on
-// github.com or gitlab.com
+// the repository's provider
 type releaseEvent struct {
 	RepoOwner       string `json:"repo_owner"`
 	RepoName        string `json:"repo_name"`
@@ -30,48 +41,211 @@ type releaseEvent struct {
 	ReleaseVersion  string `json:"release_version"`
 	GitlabProjectID string `json:"gitlab_project_id,omitempty"`
 	Hotfix          bool   `json:"hotfix"`
+	Bump            string `json:"bump,omitempty"`
+	IdempotencyKey  string `json:"idempotency_key,omitempty"`
+	FreezeOverride  bool   `json:"freeze_override,omitempty"`
+	Prerelease      bool   `json:"prerelease,omitempty"`
+	Promote         string `json:"promote,omitempty"`
+	Draft           bool   `json:"draft,omitempty"`
+	Component       string `json:"component,omitempty"`
+
+	Artifacts []artifact `json:"artifacts,omitempty"`
+
+	// NOTE(SMT): set when the release was approved through /releases/approve, never by the client
+	ApprovalRequestID string `json:"-"`
+
+	// NOTE(SMT): set on the pull request which merges a hotfix back into BranchHead, whose source branch is
+	// BranchBase and must never be deleted
+	BackMerge bool `json:"-"`
+
+	// NOTE(SMT): set by /releases/rollback to the version being rolled back to. ReleaseTarget is the branch
+	// which a release candidate is released from, or the commit which a rollback releases, which is
+	// only honoured by providers which implement rollbackProvider.
+	Rollback      string `json:"-"`
+	ReleaseTarget string `json:"-"`
+
+	// NOTE(SMT): set to the id of the release train which the release is part of, whose summary replaces
+	// the release's own Slack notification
+	Train string `json:"-"`
+}
+
+// pullRequestTitle is the title of the release pull request
+func (e releaseEvent) pullRequestTitle() string {
+	if e.BackMerge {
+		return fmt.Sprintf("Back-merge %v into %v", e.ReleaseVersion, e.BranchBase)
+	} else if e.Rollback != "" {
+		return fmt.Sprintf("%v (roll back to %v)", e.ReleaseVersion, e.Rollback)
+	}
+	return e.ReleaseVersion
+}
+
+// releaseTarget is the branch or commit which the release is created from
+func (e releaseEvent) releaseTarget() string {
+	if e.ReleaseTarget != "" {
+		return e.ReleaseTarget
+	}
+	return e.BranchBase
+}
+
+// repository is the repo item written to DynamoDB by the repositories lambda
+type repository struct {
+	RepoOwner         string `dynamodbav:"RepoOwner"`
+	BranchBase        string `dynamodbav:"BranchBase"`
+	BranchHead        string `dynamodbav:"BranchHead"`
+	CurrentVersion    string `dynamodbav:"CurrentVersion"`
+	CurrentPrerelease string `dynamodbav:"CurrentPrerelease,omitempty"`
+	GitlabProjectID   string `dynamodbav:"GitlabProjectID,omitempty"`
+	BaseURL           string `dynamodbav:"BaseURL,omitempty"`
+	AzureOrganization string `dynamodbav:"AzureOrganization,omitempty"`
+	AzureProject      string `dynamodbav:"AzureProject,omitempty"`
+	TagPrefix         string `dynamodbav:"TagPrefix,omitempty"`
+	AutoBackMerge     bool   `dynamodbav:"AutoBackMerge,omitempty"`
+	RollbackStrategy  string `dynamodbav:"RollbackStrategy,omitempty"`
+
+	// NOTE(SMT): MergeMethod and DeleteSourceBranch are only honoured by Github and Gitlab
+	MergeMethod         string `dynamodbav:"MergeMethod,omitempty"`
+	MergeCommitTemplate string `dynamodbav:"MergeCommitTemplate,omitempty"`
+	DeleteSourceBranch  *bool  `dynamodbav:"DeleteSourceBranch,omitempty"`
+
+	Components map[string]component `dynamodbav:"Components,omitempty"`
+
+	// NOTE(SMT): set by forComponent to the path of the component being released, whose release notes only
+	// include the commits which touch it
+	ComponentPath string `dynamodbav:"-"`
+}
+
+// component is an independently versioned part of a monorepo. Its releases are tagged with its own
+// prefix, e.g. billing/v1.2.3, and its versions are recorded apart from the repository's.
+type component struct {
+	Path              string `dynamodbav:"Path"`
+	TagPrefix         string `dynamodbav:"TagPrefix"`
+	CurrentVersion    string `dynamodbav:"CurrentVersion,omitempty"`
+	CurrentPrerelease string `dynamodbav:"CurrentPrerelease,omitempty"`
+}
+
+// forComponent returns the repository as seen by releases of the named component, whose tag prefix
+// and current versions replace the repository's own
+func (repo repository) forComponent(name string) (repository, error) {
+	c, ok := repo.Components[name]
+	if !ok {
+		return repo, errComponentNotFound
+	}
+
+	repo.TagPrefix = c.TagPrefix
+	repo.CurrentVersion = c.CurrentVersion
+	repo.CurrentPrerelease = c.CurrentPrerelease
+	repo.ComponentPath = c.Path
+	return repo, nil
 }
 
 type application struct {
-	AWS    awsController
-	GH     githubController
-	GL     gitlabController
-	Config configuration
+	AWS        awsController
+	Providers  map[string]providerFactory
+	GithubApps *githubapp.Installations
+	Config     configuration
+	// ArtifactClient downloads artifacts passed as URLs, see newArtifactClient
+	ArtifactClient *http.Client
 }
 
 type awsController struct {
 	TableName string
 	DB        dynamodbiface.DynamoDBAPI
 	SSM       ssmiface.SSMAPI
+	S3        s3iface.S3API
+	Lambda    lambdaiface.LambdaAPI
 }
 
+var errRepositoryNotFound = errors.New("repository has not been onboarded")
+
+var errComponentNotFound = errors.New("component has not been onboarded")
+
 type configuration struct {
-	DashboardName   string
-	SlackWebhookURL string
+	DashboardName     string
+	SlackWebhookURL   string
+	RequiredApprovals int
+	RequestTTL        time.Duration
+	ChecksTimeout     time.Duration
+	ArtifactsBucket   string
+	ArtifactHosts     []string
+	// LambdaTimeout is the timeout of the releases lambda, which release locks outlive
+	LambdaTimeout time.Duration
+	// FunctionName is the name of the releases lambda, which invokes itself to run release trains
+	FunctionName string
+}
+
+// getProviderToken returns a Github App installation token when a Github App has been configured,
+// otherwise the provider's personal access token
+func (app application) getProviderToken(e releaseEvent, repo repository) (string, error) {
+	if e.RepoProvider == "github" {
+		creds, err := githubapp.GetCredentials(app.AWS.SSM, app.Config.DashboardName, repo.BaseURL)
+		if err == nil {
+			return app.GithubApps.Token(context.Background(), creds, repo.BaseURL, e.RepoOwner, e.RepoName)
+		} else if err != githubapp.ErrNotConfigured {
+			return "", err
+		}
+	}
+
+	return util.GetProviderToken(app.AWS.SSM, app.Config.DashboardName, e.RepoProvider, repo.BaseURL)
 }
 
-func (app application) getProviderToken(e releaseEvent) (string, error) {
-	input := &ssm.GetParameterInput{
-		Name:           aws.String(fmt.Sprintf("/%s/%s_token", app.Config.DashboardName, e.RepoProvider)),
-		WithDecryption: aws.Bool(true),
+func (app awsController) getRepository(e releaseEvent) (repository, error) {
+	input := &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("repo"),
+			},
+			"SK": {
+				S: aws.String(fmt.Sprintf("%s#%s", e.RepoProvider, e.RepoName)),
+			},
+		},
+		TableName: aws.String(app.TableName),
 	}
 
-	resp, err := app.AWS.SSM.GetParameter(input)
+	repo := repository{}
+	resp, err := app.DB.GetItem(input)
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			log.Error(fmt.Sprintf("%v", aerr.Error()))
 		} else {
 			log.Error(fmt.Sprintf("%v", err.Error()))
 		}
-		return "", err
+		return repo, err
 	}
 
-	token := *resp.Parameter.Value
-	return token, nil
+	if len(resp.Item) == 0 {
+		log.Error(fmt.Sprintf("repository %v#%v does not exist", e.RepoProvider, e.RepoName))
+		return repo, errRepositoryNotFound
+	}
+
+	err = dynamodbattribute.UnmarshalMap(resp.Item, &repo)
+	if err != nil || e.Component == "" {
+		return repo, err
+	}
+
+	repo, err = repo.forComponent(e.Component)
+	if err != nil {
+		log.Error(fmt.Sprintf("component %v of %v#%v does not exist", e.Component, e.RepoProvider, e.RepoName))
+	}
+	return repo, err
 }
 
+// updateCurrentVersion records the version which was released. Release candidates are recorded as
+// the current prerelease, so that the current version is always the latest final release. Versions of
+// a component are recorded on the component.
 func (app awsController) updateCurrentVersion(e releaseEvent) error {
+	attribute := "CurrentVersion"
+	if e.Prerelease {
+		attribute = "CurrentPrerelease"
+	}
+
+	var names map[string]*string
+	if e.Component != "" {
+		attribute = "Components.#component." + attribute
+		names = map[string]*string{"#component": aws.String(e.Component)}
+	}
+
 	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: names,
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":cv": {
 				S: aws.String(e.ReleaseVersion),
@@ -86,7 +260,7 @@ func (app awsController) updateCurrentVersion(e releaseEvent) error {
 			},
 		},
 		TableName:        aws.String(app.TableName),
-		UpdateExpression: aws.String("SET CurrentVersion = :cv"),
+		UpdateExpression: aws.String(fmt.Sprintf("SET %s = :cv", attribute)),
 	}
 
 	log.Info(fmt.Sprintf("updating %v latest version to %v...", e.RepoName, e.ReleaseVersion))
@@ -102,76 +276,189 @@ func (app awsController) updateCurrentVersion(e releaseEvent) error {
 	return nil
 }
 
-// handler executes the release and notification workflow
+// handler routes the request to the release workflow, rollbacks, release candidate promotion, draft
+// releases, release trains, release approvals, scheduled releases, release preview, release history or
+// release freezes
 func (app application) handler(event events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
 	headers := map[string]string{"Content-Type": "application/json"}
 
-	e := releaseEvent{}
-	err := json.Unmarshal([]byte(event.Body), &e)
-	if err != nil {
-		log.Error(fmt.Sprintf("%v", err))
-	}
+	if event.RawPath == "/releases/create" || strings.HasPrefix(event.RawPath, "/releases/create/") {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesCreateHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
 
-	token, err := app.getProviderToken(e)
-	if err != nil {
-		message := fmt.Sprintf("Unable to release %s version %s, please double check the %s token", e.RepoName, e.ReleaseVersion, e.RepoProvider)
-		statusCode := 400
+	} else if event.RawPath == "/releases/rollback" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesRollbackHandler(event)
 		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
-	}
 
-	var message string
-	var statusCode int
-	if event.RawPath == "/releases/create/github" {
+	} else if event.RawPath == "/releases/promote" {
 		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
-		message, statusCode = app.releasesGithubHandler(e, token)
+		message, statusCode := app.releasesPromoteHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
 
-	} else if event.RawPath == "/releases/create/gitlab" {
+	} else if event.RawPath == "/releases/publish" {
 		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
-		message, statusCode = app.releasesGitlabHandler(e, token)
+		message, statusCode := app.releasesPublishHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
 
-	} else {
-		log.Error(fmt.Sprintf("path %v does not exist", event.RawPath))
-		return util.GenerateResponseBody(fmt.Sprintf("Path does not exist %v", event.RawPath), 404, nil, headers, []string{}), nil
-	}
+	} else if event.RawPath == "/releases/draft/update" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesDraftUpdateHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
 
-	if app.Config.SlackWebhookURL != "" {
-		err = util.PostToSlack(app.Config.SlackWebhookURL, fmt.Sprintf(
-			"Starting release for %v version %v...\n\n%v",
-			e.RepoName,
-			e.ReleaseVersion,
-			e.ReleaseBody,
-		))
-		if err != nil {
-			message := fmt.Sprintf("Released %v version %v successfully, unable to send slack notification and update latest version in backend", e.RepoName, e.ReleaseVersion)
-			statusCode := 200
-			return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
-		}
-	}
+	} else if event.RawPath == "/releases/train/create" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesTrainCreateHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
 
-	err = app.AWS.updateCurrentVersion(e)
-	if err != nil {
-		message := fmt.Sprintf("Released %v version %v successfully, unable to update latest version in backend", e.RepoName, e.ReleaseVersion)
-		statusCode := 200
-		return util.GenerateResponseBody(message, statusCode, err, headers, []string{}), nil
+	} else if event.RawPath == trainStepPath {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesTrainStepHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
+
+	} else if event.RawPath == trainExpirePath {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesTrainExpireHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
+
+	} else if event.RawPath == "/releases/train/list" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesTrainListHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
+
+	} else if event.RawPath == "/releases/request" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesRequestHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
+
+	} else if event.RawPath == "/releases/approve" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesApproveHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
+
+	} else if event.RawPath == "/releases/reject" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesRejectHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
+
+	} else if event.RawPath == "/releases/schedule/create" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesScheduleCreateHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
+
+	} else if event.RawPath == "/releases/schedule/list" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesScheduleListHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
+
+	} else if event.RawPath == "/releases/schedule/cancel" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesScheduleCancelHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
+
+	} else if event.RawPath == "/releases/list" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesListHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
+
+	} else if event.RawPath == "/releases/preview" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesPreviewHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
+
+	} else if event.RawPath == "/releases/freeze/create" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesFreezeCreateHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
+
+	} else if event.RawPath == "/releases/freeze/delete" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesFreezeDeleteHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
+
+	} else if event.RawPath == "/releases/freeze/list" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesFreezeListHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
+
+	} else if event.RawPath == "/releases/halt" {
+		log.Info(fmt.Sprintf("handling request on %v", event.RawPath))
+		message, statusCode := app.releasesHaltHandler(event)
+		return util.GenerateResponseBody(message, statusCode, nil, headers, []string{}), nil
 	}
 
-	return util.GenerateResponseBody(message, statusCode, err, headers, []string{}), nil
+	log.Error(fmt.Sprintf("path %v does not exist", event.RawPath))
+	return util.GenerateResponseBody(fmt.Sprintf("Path does not exist %v", event.RawPath), 404, nil, headers, []string{}), nil
 }
 
 func main() {
 	log.SetFormatter(&log.JSONFormatter{})
 
 	app := application{
+		Providers:  providerRegistry,
+		GithubApps: githubapp.NewInstallations(),
 		AWS: awsController{
 			TableName: os.Getenv("TABLE_NAME"),
 			DB:        dynamodb.New(session.Must(session.NewSession())),
 			SSM:       ssm.New(session.Must(session.NewSession())),
+			S3:        s3.New(session.Must(session.NewSession())),
+			Lambda:    awslambda.New(session.Must(session.NewSession())),
 		},
 		Config: configuration{
 			DashboardName:   os.Getenv("DASHBOARD_NAME"),
 			SlackWebhookURL: os.Getenv("SLACK_WEBHOOK_URL"),
+			RequestTTL:      defaultRequestTTL,
+			ChecksTimeout:   defaultChecksTimeout,
+			LambdaTimeout:   defaultLambdaTimeout,
+			ArtifactsBucket: os.Getenv("ARTIFACTS_BUCKET"),
+			FunctionName:    os.Getenv("AWS_LAMBDA_FUNCTION_NAME"),
 		},
 	}
 
+	if v := os.Getenv("REQUIRED_APPROVALS"); v != "" {
+		approvals, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("REQUIRED_APPROVALS must be a number, %v", err))
+		}
+		app.Config.RequiredApprovals = approvals
+	}
+
+	if v := os.Getenv("RELEASE_REQUEST_TTL_HOURS"); v != "" {
+		hours, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("RELEASE_REQUEST_TTL_HOURS must be a number, %v", err))
+		}
+		app.Config.RequestTTL = time.Duration(hours) * time.Hour
+	}
+
+	if v := os.Getenv("CHECKS_TIMEOUT_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("CHECKS_TIMEOUT_SECONDS must be a number, %v", err))
+		}
+		app.Config.ChecksTimeout = time.Duration(seconds) * time.Second
+	}
+
+	if v := os.Getenv("LAMBDA_TIMEOUT_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("LAMBDA_TIMEOUT_SECONDS must be a number, %v", err))
+		}
+		app.Config.LambdaTimeout = time.Duration(seconds) * time.Second
+	}
+
+	err := app.Config.validateTimeouts()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	for _, host := range strings.Split(os.Getenv("ARTIFACT_HOSTS"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			app.Config.ArtifactHosts = append(app.Config.ArtifactHosts, host)
+		}
+	}
+	app.ArtifactClient = newArtifactClient(app.Config.ArtifactHosts)
+
 	lambda.Start(app.handler)
 }
@@ -1 +1,828 @@
 package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// fakeProvider is an in-memory releaseProvider which records every call made by the release
+// workflow
+type fakeProvider struct {
+	Comparison   comparison
+	Checks       []check
+	Compared     []string
+	PullRequests []pullRequest
+	// PullRequestEvents are the events each pull request was opened with
+	PullRequestEvents []releaseEvent
+	Merged            []int
+	Releases          []string
+	ReleaseBodies     []string
+	// ReleaseTargets are the branch or commit each release was created from
+	ReleaseTargets []string
+	// Reverted are the revert branches created by createRevertBranch, as branch:sha
+	Reverted []string
+	// Drafts are the draft releases, whose ids are their versions, and DraftBodies their latest notes
+	Drafts      []string
+	DraftBodies map[string]string
+	Published   []string
+	// Candidates are the release candidates published by publishCandidate once they were promoted
+	Candidates []string
+	// Uploaded are the contents of the artifacts attached to releases, by artifact name
+	Uploaded map[string]string
+	// PathCommits are the SHAs returned by pathCommits, whatever the path
+	PathCommits []string
+	Errors      map[string]error
+}
+
+func (f *fakeProvider) pathCommits(e releaseEvent, ref, path string, limit int) (map[string]bool, error) {
+	if err := f.Errors["pathCommits"]; err != nil {
+		return nil, err
+	}
+	shas := map[string]bool{}
+	for _, sha := range f.PathCommits {
+		shas[sha] = true
+	}
+	return shas, nil
+}
+
+func (f *fakeProvider) compareBranches(e releaseEvent, base, head string) (comparison, error) {
+	if err := f.Errors["compareBranches"]; err != nil {
+		return comparison{}, err
+	}
+	f.Compared = append(f.Compared, base+"..."+head)
+	return f.Comparison, nil
+}
+
+func (f *fakeProvider) createPullRequest(e releaseEvent) (pullRequest, error) {
+	if err := f.Errors["createPullRequest"]; err != nil {
+		return pullRequest{}, err
+	}
+	pr := pullRequest{Number: len(f.PullRequests) + 1, URL: "https://example.com/pull"}
+	f.PullRequests = append(f.PullRequests, pr)
+	f.PullRequestEvents = append(f.PullRequestEvents, e)
+	return pr, nil
+}
+
+func (f *fakeProvider) waitForMergeable(e releaseEvent, pr pullRequest) error {
+	return f.Errors["waitForMergeable"]
+}
+
+func (f *fakeProvider) listChecks(e releaseEvent, pr pullRequest) ([]check, error) {
+	if err := f.Errors["listChecks"]; err != nil {
+		return nil, err
+	}
+	return f.Checks, nil
+}
+
+func (f *fakeProvider) mergePullRequest(e releaseEvent, pr pullRequest) (string, error) {
+	if err := f.Errors["mergePullRequest"]; err != nil {
+		return "", err
+	}
+	f.Merged = append(f.Merged, pr.Number)
+	return fmt.Sprintf("sha%d", pr.Number), nil
+}
+
+func (f *fakeProvider) createRelease(e releaseEvent) error {
+	if err := f.Errors["createRelease"]; err != nil {
+		return err
+	}
+	f.Releases = append(f.Releases, e.ReleaseVersion)
+	f.ReleaseBodies = append(f.ReleaseBodies, e.ReleaseBody)
+	f.ReleaseTargets = append(f.ReleaseTargets, e.releaseTarget())
+	return nil
+}
+
+func (f *fakeProvider) createDraftRelease(e releaseEvent) (string, error) {
+	if err := f.Errors["createDraftRelease"]; err != nil {
+		return "", err
+	}
+	f.Drafts = append(f.Drafts, e.ReleaseVersion)
+	return e.ReleaseVersion, nil
+}
+
+func (f *fakeProvider) publishRelease(e releaseEvent, id string) error {
+	if err := f.Errors["publishRelease"]; err != nil {
+		return err
+	}
+	f.Published = append(f.Published, id)
+	return nil
+}
+
+func (f *fakeProvider) publishCandidate(e releaseEvent, tag string) error {
+	if err := f.Errors["publishCandidate"]; err != nil {
+		return err
+	}
+	f.Candidates = append(f.Candidates, tag)
+	return nil
+}
+
+func (f *fakeProvider) updateDraftRelease(e releaseEvent, id string) error {
+	if err := f.Errors["updateDraftRelease"]; err != nil {
+		return err
+	}
+	if f.DraftBodies == nil {
+		f.DraftBodies = map[string]string{}
+	}
+	f.DraftBodies[id] = e.ReleaseBody
+	return nil
+}
+
+func (f *fakeProvider) uploadArtifact(e releaseEvent, draftID, name string, content []byte) (string, error) {
+	if err := f.Errors["uploadArtifact:"+name]; err != nil {
+		return "", err
+	}
+	if f.Uploaded == nil {
+		f.Uploaded = map[string]string{}
+	}
+	f.Uploaded[name] = string(content)
+	return "https://example.com/download/" + name, nil
+}
+
+func (f *fakeProvider) tagCommit(e releaseEvent, tag string) (string, error) {
+	if err := f.Errors["tagCommit"]; err != nil {
+		return "", err
+	}
+	return "sha-" + tag, nil
+}
+
+func (f *fakeProvider) createRevertBranch(e releaseEvent, branch, sha string) error {
+	if err := f.Errors["createRevertBranch"]; err != nil {
+		return err
+	}
+	f.Reverted = append(f.Reverted, branch+":"+sha)
+	return nil
+}
+
+type mockGetParameter struct {
+	ssmiface.SSMAPI
+	Response *ssm.GetParameterOutput
+	Error    error
+}
+
+func (m mockGetParameter) GetParameter(*ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	return m.Response, m.Error
+}
+
+// mockS3 returns the contents of Objects by key
+type mockS3 struct {
+	s3iface.S3API
+	Objects map[string]string
+}
+
+func (m mockS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	content, ok := m.Objects[aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(content))}, nil
+}
+
+// mockDynamoDB returns GetItemResponse for repo items and QueryResponse for queries when it is set.
+// Other items are read from Items, which PutItem writes to when it is not nil.
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	GetItemResponse *dynamodb.GetItemOutput
+	Items           map[string]map[string]*dynamodb.AttributeValue
+	QueryResponse   *dynamodb.QueryOutput
+	QueryInputs     *[]*dynamodb.QueryInput
+	PutItemInputs   *[]*dynamodb.PutItemInput
+	UpdateInputs    *[]*dynamodb.UpdateItemInput
+	Error           error
+}
+
+func mockItemKey(item map[string]*dynamodb.AttributeValue) string {
+	return aws.StringValue(item["PK"].S) + "|" + aws.StringValue(item["SK"].S)
+}
+
+func (m mockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	if aws.StringValue(input.Key["PK"].S) == "repo" {
+		return m.GetItemResponse, m.Error
+	}
+	return &dynamodb.GetItemOutput{Item: m.Items[mockItemKey(input.Key)]}, m.Error
+}
+
+func (m mockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	if m.UpdateInputs != nil {
+		*m.UpdateInputs = append(*m.UpdateInputs, input)
+	}
+	return &dynamodb.UpdateItemOutput{}, m.Error
+}
+
+func (m mockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	// NOTE(SMT): only the conditions of the release lock and of revisions are evaluated
+	if now, ok := input.ExpressionAttributeValues[":now"]; ok && m.Items != nil {
+		held, ok := m.Items[mockItemKey(input.Item)]
+		if ok && aws.StringValue(held["ExpiresAt"].N) >= aws.StringValue(now.N) {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+		}
+	}
+	if revision, ok := input.ExpressionAttributeValues[":revision"]; ok && m.Items != nil {
+		stored, ok := m.Items[mockItemKey(input.Item)]
+		if !ok || stored["Revision"] == nil || aws.StringValue(stored["Revision"].N) != aws.StringValue(revision.N) {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+		}
+	}
+	if m.PutItemInputs != nil {
+		*m.PutItemInputs = append(*m.PutItemInputs, input)
+	}
+	if m.Items != nil {
+		m.Items[mockItemKey(input.Item)] = input.Item
+	}
+	return &dynamodb.PutItemOutput{}, m.Error
+}
+
+func (m mockDynamoDB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	if m.Items != nil {
+		held, ok := m.Items[mockItemKey(input.Key)]
+		if lockID, locked := input.ExpressionAttributeValues[":lock_id"]; ok && locked && aws.StringValue(held["LockID"].S) != aws.StringValue(lockID.S) {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+		}
+		delete(m.Items, mockItemKey(input.Key))
+	}
+	return &dynamodb.DeleteItemOutput{}, m.Error
+}
+
+func (m mockDynamoDB) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	if m.QueryInputs != nil {
+		*m.QueryInputs = append(*m.QueryInputs, input)
+	}
+	if m.QueryResponse != nil {
+		return m.QueryResponse, m.Error
+	}
+
+	resp := &dynamodb.QueryOutput{}
+	for _, item := range m.Items {
+		if aws.StringValue(item["PK"].S) == aws.StringValue(input.ExpressionAttributeValues[":primary_key"].S) {
+			resp.Items = append(resp.Items, item)
+		}
+	}
+	return resp, m.Error
+}
+
+// historyRecords returns the release history items written to the mock table
+func historyRecords(items map[string]map[string]*dynamodb.AttributeValue) []releaseRecord {
+	records := []releaseRecord{}
+	for _, item := range items {
+		if !strings.HasPrefix(aws.StringValue(item["PK"].S), "release#") {
+			continue
+		}
+		record := releaseRecord{}
+		_ = dynamodbattribute.UnmarshalMap(item, &record)
+		records = append(records, record)
+	}
+	return records
+}
+
+func repositoryItem(repo repository) *dynamodb.GetItemOutput {
+	item, _ := dynamodbattribute.MarshalMap(repo)
+	return &dynamodb.GetItemOutput{Item: item}
+}
+
+func newTestApplication(provider *fakeProvider) application {
+	return application{
+		AWS: awsController{
+			TableName: "test",
+			DB: mockDynamoDB{GetItemResponse: repositoryItem(repository{
+				RepoOwner:  "test",
+				BranchBase: "main",
+				BranchHead: "develop",
+			})},
+			SSM: mockGetParameter{Response: &ssm.GetParameterOutput{
+				Parameter: &ssm.Parameter{Value: aws.String("token")},
+			}},
+		},
+		Providers: map[string]providerFactory{
+			"fake": func(e releaseEvent, repo repository, token string) (releaseProvider, error) {
+				return provider, nil
+			},
+		},
+		Config: configuration{DashboardName: "test"},
+	}
+}
+
+func TestHandler(t *testing.T) {
+	t.Run("Successfully released through pull request", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "branch_base": "main", "branch_head": "develop", "release_version": "1.0.0"}`,
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.PullRequests) != 1 || len(provider.Merged) != 1 {
+			t.Fatal("Pull request should have been created and merged")
+		}
+		if len(provider.Releases) != 1 || provider.Releases[0] != "1.0.0" {
+			t.Fatal("Release 1.0.0 should have been created")
+		}
+	})
+
+	t.Run("Successfully released hotfix without a release pull request", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.1", "hotfix": true}`,
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.PullRequests) != 1 || !provider.PullRequestEvents[0].BackMerge || len(provider.Merged) != 0 {
+			t.Fatal("Hotfix should only have opened a back-merge pull request")
+		}
+		if len(provider.Releases) != 1 {
+			t.Fatal("Release should have been created")
+		}
+	})
+
+	t.Run("Provider is read from the legacy path", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create/fake",
+			Body:    `{"repo_name": "test", "release_version": "1.0.0"}`,
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Successfully released next version from bump", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{CurrentVersion: "v1.2.3", TagPrefix: "v"})}
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "bump": "minor"}`,
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.Releases) != 1 || provider.Releases[0] != "v1.3.0" {
+			t.Fatalf("Release v1.3.0 should have been created, got %v", provider.Releases)
+		}
+	})
+
+	t.Run("Version lower than current version is rejected", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{CurrentVersion: "1.2.3"})}
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.2.0"}`,
+		})
+		if resp.StatusCode != 400 {
+			t.Fatalf("Release should have been rejected, got %v", resp.StatusCode)
+		}
+		if len(provider.PullRequests) != 0 {
+			t.Fatal("No provider calls should have been made")
+		}
+	})
+
+	t.Run("Merge failure stops the release", func(t *testing.T) {
+		provider := &fakeProvider{Errors: map[string]error{"mergePullRequest": errors.New("conflict")}}
+		app := newTestApplication(provider)
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.0"}`,
+		})
+		if resp.StatusCode != 400 {
+			t.Fatalf("Release should have failed, got %v", resp.StatusCode)
+		}
+		if len(provider.Releases) != 0 {
+			t.Fatal("Release should not have been created")
+		}
+	})
+
+	t.Run("Successfully recorded release history", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+		items := map[string]map[string]*dynamodb.AttributeValue{}
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: items}
+
+		event := events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.4.2"}`,
+		}
+		event.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+			JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+				Claims: map[string]string{"username": "jane"},
+			},
+		}
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 200 {
+			t.Fatalf("Release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+
+		records := historyRecords(items)
+		if len(records) != 1 {
+			t.Fatalf("Release should have been recorded once, got %v", len(records))
+		}
+		record := records[0]
+		if record.PK != "release#fake#test" || record.ReleaseVersion != "1.4.2" || record.Actor != "jane" {
+			t.Fatalf("Release record has the wrong repository, version or actor, got %+v", record)
+		}
+		if record.PullRequestNumber != 1 || record.MergeSHA != "sha1" || record.Outcome != "succeeded" {
+			t.Fatalf("Release record has the wrong pull request or outcome, got %+v", record)
+		}
+	})
+
+	t.Run("Failed release is recorded in history", func(t *testing.T) {
+		provider := &fakeProvider{Errors: map[string]error{"createRelease": errors.New("tag exists")}}
+		app := newTestApplication(provider)
+		items := map[string]map[string]*dynamodb.AttributeValue{}
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: items}
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.0"}`,
+		})
+		if resp.StatusCode != 400 {
+			t.Fatalf("Release should have failed, got %v", resp.StatusCode)
+		}
+
+		records := historyRecords(items)
+		if len(records) != 1 || records[0].Outcome != "failed" {
+			t.Fatalf("Release should have been recorded as failed, got %+v", records)
+		}
+	})
+
+	t.Run("Failed release resumes from the last completed step", func(t *testing.T) {
+		provider := &fakeProvider{Errors: map[string]error{"mergePullRequest": errors.New("conflict")}}
+		app := newTestApplication(provider)
+		items := map[string]map[string]*dynamodb.AttributeValue{}
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: items}
+		event := events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.0"}`,
+		}
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 400 {
+			t.Fatalf("Release should have failed, got %v", resp.StatusCode)
+		}
+
+		provider.Errors = nil
+		resp, _ = app.handler(event)
+		if resp.StatusCode != 200 {
+			t.Fatalf("Resumed release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.PullRequests) != 1 || len(provider.Merged) != 1 || len(provider.Releases) != 1 {
+			t.Fatalf("Pull request should have been reused, got %v pull requests", len(provider.PullRequests))
+		}
+
+		state := releaseState{}
+		_ = dynamodbattribute.UnmarshalMap(items["release_state#fake#test|1.0.0"], &state)
+		if state.Step != "version_recorded" || state.ExpiresAt == 0 {
+			t.Fatalf("Release state should have been completed, got %+v", state)
+		}
+
+		resp, _ = app.handler(event)
+		if resp.StatusCode != 200 || len(provider.Releases) != 1 {
+			t.Fatal("Completed release should not have been released again")
+		}
+	})
+
+	t.Run("Release in progress is rejected with the lock holder", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+		items := map[string]map[string]*dynamodb.AttributeValue{}
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: items}
+		held, _ := dynamodbattribute.MarshalMap(newReleaseLock(releaseEvent{RepoProvider: "fake", RepoName: "test"}, "other", "jane", time.Now(), time.Minute))
+		items["lock|fake#test"] = held
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.0"}`,
+		})
+		if resp.StatusCode != 409 || !strings.Contains(resp.Body, "jane") {
+			t.Fatalf("Release should have been rejected naming the lock holder, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.PullRequests) != 0 || len(provider.Releases) != 0 {
+			t.Fatal("Provider should not have been called while the repository is locked")
+		}
+		if items["lock|fake#test"] == nil {
+			t.Fatal("Lock held by another release should not have been released")
+		}
+	})
+
+	t.Run("Expired lock is taken over and released", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+		items := map[string]map[string]*dynamodb.AttributeValue{}
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: items}
+		expired, _ := dynamodbattribute.MarshalMap(newReleaseLock(releaseEvent{RepoProvider: "fake", RepoName: "test"}, "other", "jane", time.Now().Add(-time.Hour), time.Minute))
+		items["lock|fake#test"] = expired
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.0"}`,
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if _, ok := items["lock|fake#test"]; ok {
+			t.Fatal("Lock should have been released after the release")
+		}
+	})
+
+	t.Run("Lock is released when the release fails", func(t *testing.T) {
+		provider := &fakeProvider{Errors: map[string]error{"createRelease": errors.New("tag exists")}}
+		app := newTestApplication(provider)
+		items := map[string]map[string]*dynamodb.AttributeValue{}
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: items}
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.0"}`,
+		})
+		if resp.StatusCode != 400 {
+			t.Fatalf("Release should have failed, got %v", resp.StatusCode)
+		}
+		if _, ok := items["lock|fake#test"]; ok {
+			t.Fatal("Lock should have been released after the failed release")
+		}
+	})
+
+	t.Run("Idempotency key resumes the version the release started with", func(t *testing.T) {
+		provider := &fakeProvider{Errors: map[string]error{"createRelease": errors.New("unavailable")}}
+		app := newTestApplication(provider)
+		items := map[string]map[string]*dynamodb.AttributeValue{}
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{CurrentVersion: "1.0.0"}), Items: items}
+		event := events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Headers: map[string]string{"idempotency-key": "deploy-1"},
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "bump": "minor"}`,
+		}
+
+		_, _ = app.handler(event)
+		provider.Errors = nil
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{CurrentVersion: "1.0.0"}), Items: items}
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 200 {
+			t.Fatalf("Resumed release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.Releases) != 1 || provider.Releases[0] != "1.1.0" || len(provider.PullRequests) != 1 {
+			t.Fatalf("Release 1.1.0 should have resumed, got %v", provider.Releases)
+		}
+	})
+
+	t.Run("Successfully generated release notes when the body is empty", func(t *testing.T) {
+		provider := &fakeProvider{Comparison: comparison{Commits: []commit{
+			{SHA: "0123456789", Message: "feat: add release notes"},
+		}}}
+		app := newTestApplication(provider)
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "branch_base": "main", "branch_head": "develop", "release_version": "1.0.0"}`,
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.Compared) != 1 || provider.Compared[0] != "main...develop" {
+			t.Fatalf("main and develop should have been compared, got %v", provider.Compared)
+		}
+		if provider.ReleaseBodies[0] != "### Features\n\n- add release notes (0123456)" {
+			t.Fatalf("Release notes should have been generated, got %q", provider.ReleaseBodies[0])
+		}
+	})
+
+	t.Run("Hotfix release notes compare the current version with the base branch", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{CurrentVersion: "1.0.0"})}
+
+		_, _ = app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "branch_base": "main", "branch_head": "develop", "release_version": "1.0.1", "hotfix": true}`,
+		})
+		if len(provider.Compared) != 1 || provider.Compared[0] != "1.0.0...main" {
+			t.Fatalf("1.0.0 and main should have been compared, got %v", provider.Compared)
+		}
+	})
+
+	t.Run("Provided release body is not replaced", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app := newTestApplication(provider)
+
+		_, _ = app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_body": "notes", "release_version": "1.0.0"}`,
+		})
+		if len(provider.Compared) != 0 || provider.ReleaseBodies[0] != "notes" {
+			t.Fatal("Provided release body should have been used")
+		}
+	})
+
+	t.Run("Repository which has not been onboarded is rejected", func(t *testing.T) {
+		app := newTestApplication(&fakeProvider{})
+		app.AWS.DB = mockDynamoDB{GetItemResponse: &dynamodb.GetItemOutput{}}
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.0"}`,
+		})
+		if resp.StatusCode != 404 {
+			t.Fatalf("Release should have been rejected, got %v", resp.StatusCode)
+		}
+	})
+
+	t.Run("Unknown provider is rejected", func(t *testing.T) {
+		app := newTestApplication(&fakeProvider{})
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "unknown", "release_version": "1.0.0"}`,
+		})
+		if resp.StatusCode != 400 {
+			t.Fatalf("Release should have been rejected, got %v", resp.StatusCode)
+		}
+	})
+
+	t.Run("Unknown path returns 404", func(t *testing.T) {
+		app := newTestApplication(&fakeProvider{})
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{RawPath: "/releases/unknown"})
+		if resp.StatusCode != 404 {
+			t.Fatalf("Path should not exist, got %v", resp.StatusCode)
+		}
+	})
+}
+
+type mockGetParameterByName struct {
+	ssmiface.SSMAPI
+	Parameters map[string]string
+	Requested  *[]string
+}
+
+func (m mockGetParameterByName) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	*m.Requested = append(*m.Requested, *input.Name)
+	value, ok := m.Parameters[*input.Name]
+	if !ok {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "parameter not found", nil)
+	}
+	return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(value)}}, nil
+}
+
+func (m mockGetParameterByName) GetParameters(input *ssm.GetParametersInput) (*ssm.GetParametersOutput, error) {
+	resp := &ssm.GetParametersOutput{}
+	for _, name := range input.Names {
+		*m.Requested = append(*m.Requested, *name)
+		if value, ok := m.Parameters[*name]; ok {
+			resp.Parameters = append(resp.Parameters, &ssm.Parameter{Name: name, Value: aws.String(value)})
+		} else {
+			resp.InvalidParameters = append(resp.InvalidParameters, name)
+		}
+	}
+	return resp, nil
+}
+
+func TestValidateTimeouts(t *testing.T) {
+	t.Run("Successfully validated the default timeouts", func(t *testing.T) {
+		err := configuration{ChecksTimeout: defaultChecksTimeout, LambdaTimeout: defaultLambdaTimeout}.validateTimeouts()
+		if err != nil {
+			t.Fatalf("Default timeouts should have been valid, %v", err)
+		}
+	})
+
+	t.Run("Checks timeout which does not fit within the lambda timeout is rejected", func(t *testing.T) {
+		err := configuration{ChecksTimeout: 20 * time.Second, LambdaTimeout: defaultLambdaTimeout}.validateTimeouts()
+		if err == nil {
+			t.Fatal("Checks timeout should have been rejected")
+		}
+	})
+}
+
+func TestGetProviderToken(t *testing.T) {
+	t.Run("Self-hosted instance token is keyed by host", func(t *testing.T) {
+		requested := []string{}
+		app := application{
+			AWS: awsController{SSM: mockGetParameterByName{
+				Parameters: map[string]string{"/test/github.example.com/github_token": "instance"},
+				Requested:  &requested,
+			}},
+			Config: configuration{DashboardName: "test"},
+		}
+
+		token, err := app.getProviderToken(releaseEvent{RepoProvider: "github"}, repository{BaseURL: "https://github.example.com"})
+		if err != nil || token != "instance" {
+			t.Fatalf("Instance token should have been returned, got %v %v", token, err)
+		}
+	})
+
+	t.Run("Self-hosted instance falls back to the provider token", func(t *testing.T) {
+		requested := []string{}
+		app := application{
+			AWS: awsController{SSM: mockGetParameterByName{
+				Parameters: map[string]string{"/test/gitlab_token": "provider"},
+				Requested:  &requested,
+			}},
+			Config: configuration{DashboardName: "test"},
+		}
+
+		token, err := app.getProviderToken(releaseEvent{RepoProvider: "gitlab"}, repository{BaseURL: "https://gitlab.example.com:8443"})
+		if err != nil || token != "provider" {
+			t.Fatalf("Provider token should have been returned, got %v %v", token, err)
+		}
+		if requested[0] != "/test/gitlab.example.com_8443/gitlab_token" {
+			t.Fatalf("Instance token should have been requested first, got %v", requested)
+		}
+	})
+}
+
+func TestNewGithubController(t *testing.T) {
+	t.Run("Github Enterprise Server repositories use the instance API", func(t *testing.T) {
+		provider, err := newGithubController(releaseEvent{}, repository{BaseURL: "https://github.example.com/"}, "token")
+		if err != nil {
+			t.Fatalf("Provider should have been created, %v", err)
+		}
+
+		baseURL := provider.(githubController).Client.BaseURL.String()
+		if baseURL != "https://github.example.com/api/v3/" {
+			t.Fatalf("Client should target the enterprise API, got %v", baseURL)
+		}
+	})
+}
+
+func TestComponentRelease(t *testing.T) {
+	monorepo := repository{
+		CurrentVersion: "v2.0.0",
+		TagPrefix:      "v",
+		Components: map[string]component{
+			"billing": {Path: "services/billing", TagPrefix: "billing/v", CurrentVersion: "billing/v1.2.0"},
+		},
+	}
+
+	t.Run("Successfully released a component with its own tag and release notes", func(t *testing.T) {
+		provider := &fakeProvider{
+			Comparison: comparison{Commits: []commit{
+				{SHA: "1111111aaa", Message: "feat(billing): add invoices"},
+				{SHA: "2222222bbb", Message: "fix(payments): handle refunds"},
+			}},
+			PathCommits: []string{"1111111aaa"},
+		}
+		app, updates, items := newRepositoryApplication(provider, monorepo)
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "repo_owner": "test", "branch_base": "main", "branch_head": "develop", "bump": "minor", "component": "billing"}`,
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Component release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.Releases) != 1 || provider.Releases[0] != "billing/v1.3.0" {
+			t.Fatalf("billing/v1.3.0 should have been released, got %v", provider.Releases)
+		}
+		if body := provider.ReleaseBodies[0]; !strings.Contains(body, "add invoices") || strings.Contains(body, "handle refunds") {
+			t.Fatalf("Release notes should only describe the component's commits, got\n%v", body)
+		}
+
+		if len(*updates) != 1 {
+			t.Fatal("Component release should have updated the component's current version")
+		}
+		update := (*updates)[0]
+		if aws.StringValue(update.UpdateExpression) != "SET Components.#component.CurrentVersion = :cv" || aws.StringValue(update.ExpressionAttributeNames["#component"]) != "billing" {
+			t.Fatalf("Component's current version should have been updated, got %v", update)
+		}
+		if records := historyRecords(items); len(records) != 1 || records[0].Component != "billing" {
+			t.Fatalf("Component release should have been recorded in history, got %+v", records)
+		}
+	})
+
+	t.Run("Unknown component is not found", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, _, _ := newRepositoryApplication(provider, monorepo)
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "bump": "minor", "component": "shipping"}`,
+		})
+		if resp.StatusCode != 404 || len(provider.Releases) != 0 {
+			t.Fatalf("Release of an unknown component should have been rejected, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+}
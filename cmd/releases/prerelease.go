@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// prereleaseProvider is implemented by the providers which list release candidates in a way which
+// must be undone once they are promoted
+type prereleaseProvider interface {
+	// publishCandidate lists the release candidate tagged tag as an ordinary release
+	publishCandidate(e releaseEvent, tag string) error
+}
+
+// releasesPromoteHandler releases the final version of a release candidate, e.g. 1.3.0 for
+// 1.3.0-rc.2, through the regular pull request workflow. The repository's current prerelease is
+// promoted when the client does not name one.
+func (app application) releasesPromoteHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	e := releaseEvent{}
+	err := json.Unmarshal([]byte(event.Body), &e)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+	}
+
+	if app.Config.RequiredApprovals > 0 {
+		message := fmt.Sprintf("Unable to promote %s, releases require %d approvals, request one through /releases/request with promote", e.RepoName, app.Config.RequiredApprovals)
+		statusCode := 403
+		return message, statusCode
+	}
+
+	repo, err := app.AWS.getRepository(e)
+	if err == errRepositoryNotFound {
+		message := fmt.Sprintf("Unable to promote %s, repository has not been onboarded for %s", e.RepoName, e.RepoProvider)
+		statusCode := 404
+		return message, statusCode
+	} else if err == errComponentNotFound {
+		message := fmt.Sprintf("Unable to promote %s, component %s has not been onboarded", e.RepoName, e.Component)
+		statusCode := 404
+		return message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to promote %s, could not read repository from backend", e.RepoName)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	if e.Promote == "" {
+		if repo.CurrentPrerelease == "" {
+			message := fmt.Sprintf("Unable to promote %s, no release candidate has been released", e.RepoName)
+			statusCode := 400
+			return message, statusCode
+		}
+		e.Promote = repo.CurrentPrerelease
+	}
+
+	// NOTE(SMT): promotions use the onboarded branches unless the client sends its own
+	if e.RepoOwner == "" {
+		e.RepoOwner = repo.RepoOwner
+	}
+	if e.BranchBase == "" {
+		e.BranchBase = repo.BranchBase
+	}
+	if e.BranchHead == "" {
+		e.BranchHead = repo.BranchHead
+	}
+	return app.release(event, e)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestReleaseCandidate(t *testing.T) {
+	t.Run("Successfully released the next release candidate from the head branch", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, updates, items := newRepositoryApplication(provider, repository{CurrentVersion: "1.2.0", CurrentPrerelease: "1.3.0-rc.1"})
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "repo_owner": "test", "branch_base": "main", "branch_head": "develop", "bump": "minor", "prerelease": true}`,
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Release candidate should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.Releases) != 1 || provider.Releases[0] != "1.3.0-rc.2" || provider.ReleaseTargets[0] != "develop" {
+			t.Fatalf("1.3.0-rc.2 should have been released from develop, got %v %v", provider.Releases, provider.ReleaseTargets)
+		}
+		if len(provider.PullRequests) != 0 {
+			t.Fatal("Release candidate should not have opened a pull request")
+		}
+		if len(*updates) != 1 || aws.StringValue((*updates)[0].UpdateExpression) != "SET CurrentPrerelease = :cv" {
+			t.Fatal("Release candidate should have been recorded as the current prerelease")
+		}
+		if records := historyRecords(items); len(records) != 1 || !records[0].Prerelease {
+			t.Fatalf("Release candidate should have been recorded in history, got %+v", records)
+		}
+	})
+}
+
+func TestPromote(t *testing.T) {
+	event := events.APIGatewayV2HTTPRequest{
+		RawPath: "/releases/promote",
+		Body:    `{"repo_name": "test", "repo_provider": "fake"}`,
+	}
+
+	t.Run("Successfully promoted the current release candidate through a pull request", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, updates, items := newRepositoryApplication(provider, repository{CurrentVersion: "1.2.0", CurrentPrerelease: "1.3.0-rc.2"})
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 200 {
+			t.Fatalf("Promotion should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.PullRequests) != 1 || len(provider.Merged) != 1 {
+			t.Fatal("Promotion should have opened and merged a pull request")
+		}
+		if pr := provider.PullRequestEvents[0]; pr.BranchHead != "develop" || pr.BranchBase != "main" {
+			t.Fatalf("Promotion should have merged develop into main, got %+v", pr)
+		}
+		if len(provider.Releases) != 1 || provider.Releases[0] != "1.3.0" || provider.ReleaseTargets[0] != "main" {
+			t.Fatalf("1.3.0 should have been released from main, got %v %v", provider.Releases, provider.ReleaseTargets)
+		}
+		if len(*updates) != 1 || aws.StringValue((*updates)[0].UpdateExpression) != "SET CurrentVersion = :cv" {
+			t.Fatal("Promotion should have updated the current version")
+		}
+		if len(provider.Candidates) != 1 || provider.Candidates[0] != "1.3.0-rc.2" {
+			t.Fatalf("Promoted release candidate should have been published, got %v", provider.Candidates)
+		}
+		if records := historyRecords(items); len(records) != 1 || records[0].PromotedFrom != "1.3.0-rc.2" {
+			t.Fatalf("Promotion should have been recorded in history, got %+v", records)
+		}
+	})
+
+	t.Run("Repository without a release candidate cannot be promoted", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, _, _ := newRepositoryApplication(provider, repository{CurrentVersion: "1.2.0"})
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 400 || len(provider.Releases) != 0 {
+			t.Fatalf("Promotion should have been rejected, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Release candidate of a released version cannot be promoted", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, _, _ := newRepositoryApplication(provider, repository{CurrentVersion: "1.3.0", CurrentPrerelease: "1.3.0-rc.2"})
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 400 || len(provider.PullRequests) != 0 {
+			t.Fatalf("Promotion should have been rejected, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+}
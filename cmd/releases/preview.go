@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// releasePreview is what /releases/create would release for the same event. It is built only from
+// read requests to the provider, so previewing has no side effects.
+type releasePreview struct {
+	RepoName       string          `json:"repo_name"`
+	RepoProvider   string          `json:"repo_provider"`
+	BranchBase     string          `json:"branch_base"`
+	BranchHead     string          `json:"branch_head"`
+	Hotfix         bool            `json:"hotfix"`
+	CurrentVersion string          `json:"current_version"`
+	ReleaseVersion string          `json:"release_version"`
+	Commits        []previewCommit `json:"commits"`
+	AheadBy        int             `json:"ahead_by"`
+	BehindBy       int             `json:"behind_by"`
+	FilesChanged   int             `json:"files_changed"`
+	Additions      int             `json:"additions"`
+	Deletions      int             `json:"deletions"`
+	Files          []changedFile   `json:"files"`
+	Mergeable      string          `json:"mergeable"`
+	ReleaseNotes   string          `json:"release_notes"`
+}
+
+// previewCommit is a commit which would be released
+type previewCommit struct {
+	SHA     string `json:"sha"`
+	Message string `json:"message"`
+	Author  string `json:"author"`
+}
+
+// newReleasePreview summarises the comparison of the commits being released
+func newReleasePreview(e releaseEvent, repo repository, c comparison) releasePreview {
+	preview := releasePreview{
+		RepoName:       e.RepoName,
+		RepoProvider:   e.RepoProvider,
+		BranchBase:     e.BranchBase,
+		BranchHead:     e.BranchHead,
+		Hotfix:         e.Hotfix,
+		CurrentVersion: repo.CurrentVersion,
+		Commits:        []previewCommit{},
+		AheadBy:        c.AheadBy,
+		BehindBy:       c.BehindBy,
+		FilesChanged:   len(c.Files),
+		Files:          []changedFile{},
+		Mergeable:      c.Mergeable,
+	}
+
+	for _, commit := range c.Commits {
+		preview.Commits = append(preview.Commits, previewCommit{
+			SHA:     commit.SHA,
+			Message: strings.SplitN(commit.Message, "\n", 2)[0],
+			Author:  commit.Author,
+		})
+	}
+	for _, file := range c.Files {
+		preview.Files = append(preview.Files, file)
+		preview.Additions += file.Additions
+		preview.Deletions += file.Deletions
+	}
+
+	// NOTE(SMT): only providers which check the comparison for conflicts report its mergeability, others
+	// can only tell once a pull request has been opened
+	if preview.Mergeable == "" {
+		preview.Mergeable = "unknown"
+	}
+	return preview
+}
+
+func (app application) releasesPreviewHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	e := releaseEvent{}
+	err := json.Unmarshal([]byte(event.Body), &e)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+	}
+
+	newProvider, ok := app.Providers[e.RepoProvider]
+	if !ok {
+		log.Error(fmt.Sprintf("provider %v is not supported", e.RepoProvider))
+		message := fmt.Sprintf("Unable to preview %s release, provider %s is not supported", e.RepoName, e.RepoProvider)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	repo, err := app.AWS.getRepository(e)
+	if err == errRepositoryNotFound {
+		message := fmt.Sprintf("Unable to preview %s release, repository has not been onboarded for %s", e.RepoName, e.RepoProvider)
+		statusCode := 404
+		return message, statusCode
+	} else if err == errComponentNotFound {
+		message := fmt.Sprintf("Unable to preview %s release, component %s has not been onboarded", e.RepoName, e.Component)
+		statusCode := 404
+		return message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to preview %s release, could not read repository from backend", e.RepoName)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	token, err := app.getProviderToken(e, repo)
+	if err != nil {
+		message := fmt.Sprintf("Unable to preview %s release, please double check the %s token", e.RepoName, e.RepoProvider)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	provider, err := newProvider(e, repo, token)
+	if err != nil {
+		message := fmt.Sprintf("Unable to preview %s release, could not create %s client, %v", e.RepoName, e.RepoProvider, err)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	base, head, ok := releaseRange(e, repo)
+	if !ok {
+		message := fmt.Sprintf("Unable to preview %s hotfix, repository has not been released", e.RepoName)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	c, err := compareRelease(provider, e, repo, base, head)
+	if err == errPathFilterUnsupported {
+		message := fmt.Sprintf("Unable to preview %s release, %v", e.RepoName, err)
+		statusCode := 400
+		return message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to preview %s release, could not compare %s with %s on %s", e.RepoName, base, head, e.RepoProvider)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	// NOTE(SMT): without a requested version or bump, the proposed version follows the release notes
+	if e.ReleaseVersion == "" && e.Bump == "" {
+		e.Bump = suggestedBump(changelogEntries(c, e.RepoProvider))
+	}
+
+	preview := newReleasePreview(e, repo, c)
+	preview.ReleaseVersion, err = resolveReleaseVersion(e, repo)
+	if err != nil {
+		log.Error(fmt.Sprintf("invalid release version for %v, %v", e.RepoName, err))
+		message := fmt.Sprintf("Unable to preview %s release, %v", e.RepoName, err)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	preview.ReleaseNotes = e.ReleaseBody
+	if strings.TrimSpace(preview.ReleaseNotes) == "" {
+		preview.ReleaseNotes = renderChangelog(c, e.RepoProvider)
+	}
+
+	body, err := json.Marshal(preview)
+	statusCode := 200
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal json for response, %v", err))
+		statusCode = 400
+	}
+
+	var buf bytes.Buffer
+	json.HTMLEscape(&buf, body)
+	return buf.String(), statusCode
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/seanturner026/moot/internal/util"
+)
+
+func releasePreviewBody(t *testing.T, resp events.APIGatewayV2HTTPResponse) releasePreview {
+	body := util.ResponseBody{}
+	err := json.Unmarshal([]byte(resp.Body), &body)
+	if err != nil {
+		t.Fatalf("Unable to read response body, %v", err)
+	}
+
+	preview := releasePreview{}
+	err = json.Unmarshal([]byte(body.Message), &preview)
+	if err != nil {
+		t.Fatalf("Unable to read release preview, %v", err)
+	}
+	return preview
+}
+
+func TestReleasesPreviewHandler(t *testing.T) {
+	t.Run("Successfully previewed release without side effects", func(t *testing.T) {
+		provider := &fakeProvider{Comparison: comparison{
+			Commits: []commit{
+				{SHA: "1111111aaa", Message: "feat: add preview\n\ndetails", Author: "jane"},
+				{SHA: "2222222bbb", Message: "fix: typo", Author: "joe"},
+			},
+			Files: []changedFile{
+				{Filename: "preview.go", Status: "added", Additions: 10},
+				{Filename: "main.go", Status: "modified", Additions: 2, Deletions: 1},
+			},
+			AheadBy: 2,
+		}}
+		app := newTestApplication(provider)
+		records := []*dynamodb.PutItemInput{}
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{CurrentVersion: "1.2.3"}), PutItemInputs: &records}
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/preview",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "branch_base": "main", "branch_head": "develop"}`,
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Preview should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.PullRequests) != 0 || len(provider.Releases) != 0 || len(records) != 0 {
+			t.Fatal("Preview should not have had side effects")
+		}
+
+		preview := releasePreviewBody(t, resp)
+		if preview.ReleaseVersion != "1.3.0" {
+			t.Fatalf("Proposed version should have been a minor bump, got %v", preview.ReleaseVersion)
+		}
+		if len(preview.Commits) != 2 || preview.Commits[0].Message != "feat: add preview" {
+			t.Fatalf("Commits should have been summarised, got %v", preview.Commits)
+		}
+		if preview.FilesChanged != 2 || preview.Additions != 12 || preview.Deletions != 1 {
+			t.Fatalf("Changed file stats are wrong, got %+v", preview)
+		}
+		if preview.Mergeable != "unknown" {
+			t.Fatalf("Mergeability the provider did not report should be unknown, got %v", preview.Mergeable)
+		}
+		if preview.ReleaseNotes != "### Features\n\n- add preview (1111111)\n\n### Bug Fixes\n\n- typo (2222222)" {
+			t.Fatalf("Release notes should have been rendered, got %q", preview.ReleaseNotes)
+		}
+	})
+
+	t.Run("Requested version is validated", func(t *testing.T) {
+		app := newTestApplication(&fakeProvider{})
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{CurrentVersion: "1.2.3"})}
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/preview",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "1.0.0"}`,
+		})
+		if resp.StatusCode != 400 {
+			t.Fatalf("Preview of a lower version should have failed, got %v", resp.StatusCode)
+		}
+	})
+
+	t.Run("Head which is not behind base has unknown mergeability", func(t *testing.T) {
+		preview := newReleasePreview(releaseEvent{}, repository{}, comparison{BehindBy: 0})
+		if preview.Mergeable != "unknown" {
+			t.Fatalf("Mergeability should be unknown, got %v", preview.Mergeable)
+		}
+	})
+
+	t.Run("Conflicts reported by the provider are kept", func(t *testing.T) {
+		preview := newReleasePreview(releaseEvent{}, repository{}, comparison{Mergeable: "conflicts"})
+		if preview.Mergeable != "conflicts" {
+			t.Fatalf("Mergeability should be conflicts, got %v", preview.Mergeable)
+		}
+	})
+}
+
+func TestSuggestedBump(t *testing.T) {
+	tests := map[string][]changelogEntry{
+		"patch": {{Type: "fix"}, {Type: "chore"}},
+		"minor": {{Type: "fix"}, {Type: "feat"}},
+		"major": {{Type: "feat"}, {Type: "fix", Breaking: true}},
+	}
+
+	for expected, entries := range tests {
+		if bump := suggestedBump(entries); bump != expected {
+			t.Fatalf("Expected %v bump, got %v", expected, bump)
+		}
+	}
+}
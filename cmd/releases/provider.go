@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/seanturner026/moot/internal/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// releaseProvider is implemented by each version control provider that the dashboard is able to
+// release to. handler runs the same workflow against every provider, so adding a provider only
+// requires implementing this interface and registering a providerFactory in providerRegistry.
+type releaseProvider interface {
+	compareBranches(e releaseEvent, base, head string) (comparison, error)
+	createPullRequest(e releaseEvent) (pullRequest, error)
+	waitForMergeable(e releaseEvent, pr pullRequest) error
+	listChecks(e releaseEvent, pr pullRequest) ([]check, error)
+	mergePullRequest(e releaseEvent, pr pullRequest) (string, error)
+	createRelease(e releaseEvent) error
+}
+
+// pullRequest is the provider agnostic representation of a github pull request or a gitlab merge
+// request
+type pullRequest struct {
+	Number int
+	URL    string
+}
+
+// mergeCommitMessage renders the repository's merge commit message template for the pull request.
+// Templates are validated when the repository is onboarded, so the default message is only used
+// should the template fail to render.
+func mergeCommitMessage(tmpl string, e releaseEvent, pr pullRequest) string {
+	c := util.MergeCommit{
+		Number:  pr.Number,
+		Version: e.ReleaseVersion,
+		Repo:    e.RepoName,
+		Base:    e.BranchBase,
+		Head:    e.BranchHead,
+	}
+
+	message, err := util.RenderMergeCommitMessage(tmpl, c)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to render %v merge commit message, %v", e.RepoName, err))
+		message, _ = util.RenderMergeCommitMessage("", c)
+	}
+	return message
+}
+
+// check is a CI status check, check run or pipeline job on the head of a pull request. State is one of
+// pending, success or failure.
+type check struct {
+	Name  string
+	State string
+	URL   string
+}
+
+// comparison is the provider agnostic representation of the commits on head which are not on base,
+// and the files they change. BehindBy is the number of commits on base which are not on head.
+// Mergeable is one of mergeable, conflicts or unknown, as not every provider can check for conflicts
+// without opening a pull request.
+type comparison struct {
+	Commits   []commit
+	Files     []changedFile
+	AheadBy   int
+	BehindBy  int
+	Mergeable string
+}
+
+// commit is a single commit in a comparison. Merge is set for commits with more than one parent.
+type commit struct {
+	SHA     string
+	Message string
+	Author  string
+	Merge   bool
+}
+
+// changedFile is a file changed between base and head. Additions and Deletions are zero for
+// providers which only report the files which changed.
+type changedFile struct {
+	Filename  string `json:"filename"`
+	Status    string `json:"status"`
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+}
+
+// providerFactory builds a releaseProvider for the onboarded repository using the token stored in
+// SSM for the provider
+type providerFactory func(e releaseEvent, repo repository, token string) (releaseProvider, error)
+
+// providerRegistry maps releaseEvent.RepoProvider to the factory for that provider
+var providerRegistry = map[string]providerFactory{
+	"azuredevops": newAzureDevOpsController,
+	"bitbucket":   newBitbucketController,
+	"gitea":       newGiteaController,
+	"github":      newGithubController,
+	"gitlab":      newGitlabController,
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	log "github.com/sirupsen/logrus"
+)
+
+var errRollbackUnsupported = errors.New("rollbacks are not supported on this provider")
+
+// rollbackProvider is implemented by the providers which are able to roll a repository back to a
+// previous release
+type rollbackProvider interface {
+	// tagCommit returns the SHA of the commit which the tag points at
+	tagCommit(e releaseEvent, tag string) (string, error)
+	// createRevertBranch creates branch from BranchBase with a commit which reverts the merge commit sha
+	createRevertBranch(e releaseEvent, branch, sha string) error
+}
+
+// rollbackEvent is the body of /releases/rollback. The release version defaults to the next patch
+// version, as a rollback is released as a new version.
+type rollbackEvent struct {
+	releaseEvent
+	TargetVersion string `json:"target_version"`
+}
+
+// rollbackTarget validates that target is a version of the repository older than its current version,
+// and returns it with the repository's tag prefix
+func rollbackTarget(target string, repo repository) (string, error) {
+	if repo.CurrentVersion == "" {
+		return "", errors.New("repository has not been released yet")
+	}
+
+	t, err := parseSemver(target, repo.TagPrefix)
+	if err != nil {
+		return "", fmt.Errorf("target version %v is not a semantic version", target)
+	}
+
+	current, err := parseSemver(repo.CurrentVersion, repo.TagPrefix)
+	if err == nil && t.compare(current) >= 0 {
+		return "", fmt.Errorf("target version %v must be older than the current version %v", target, repo.CurrentVersion)
+	}
+	return repo.TagPrefix + t.String(), nil
+}
+
+// lastMergeSHA returns the merge commit of the release of the repository's current version
+func (app awsController) lastMergeSHA(e releaseEvent, repo repository) (string, error) {
+	r, ok, err := app.findRelease(e.RepoProvider, e.RepoName, repo.CurrentVersion, func(r releaseRecord) bool {
+		return r.Outcome == "succeeded" && r.MergeSHA != ""
+	})
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return r.MergeSHA, nil
+	}
+	return "", fmt.Errorf("no merge was recorded for version %v, it may have been a hotfix", repo.CurrentVersion)
+}
+
+// prepareRollback points the rollback at the previous release. Repositories which roll back by
+// releasing release the commit of the target version's tag directly, like a hotfix. Repositories which
+// roll back by reverting release a pull request which reverts the merge of the current version, whose
+// branch is only created when the rollback starts rather than when it resumes.
+func (app application) prepareRollback(provider releaseProvider, e releaseEvent, repo repository, resumed bool) (releaseEvent, error) {
+	rp, ok := provider.(rollbackProvider)
+	if !ok {
+		return e, errRollbackUnsupported
+	}
+
+	if repo.RollbackStrategy != "revert" {
+		sha, err := rp.tagCommit(e, e.Rollback)
+		if err != nil {
+			return e, fmt.Errorf("unable to find the commit of %v", e.Rollback)
+		}
+		e.Hotfix = true
+		e.ReleaseTarget = sha
+		return e, nil
+	}
+
+	e.Hotfix = false
+	e.BranchHead = fmt.Sprintf("rollback/%s", e.ReleaseVersion)
+	if resumed {
+		return e, nil
+	}
+
+	sha, err := app.AWS.lastMergeSHA(e, repo)
+	if err != nil {
+		return e, err
+	}
+
+	log.Info(fmt.Sprintf("reverting %v merge %v on %v...", e.RepoName, sha, e.BranchHead))
+	err = rp.createRevertBranch(e, e.BranchHead, sha)
+	if err != nil {
+		return e, fmt.Errorf("unable to revert %v, %v", sha, err)
+	}
+	return e, nil
+}
+
+func (app application) releasesRollbackHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	r := rollbackEvent{}
+	err := json.Unmarshal([]byte(event.Body), &r)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+	}
+	e := r.releaseEvent
+
+	if app.Config.RequiredApprovals > 0 && !requestIsAdmin(event) {
+		message := fmt.Sprintf("Unable to roll back %s, releases require %d approvals, so only admins may roll back", e.RepoName, app.Config.RequiredApprovals)
+		statusCode := 403
+		return message, statusCode
+	}
+
+	repo, err := app.AWS.getRepository(e)
+	if err == errRepositoryNotFound {
+		message := fmt.Sprintf("Unable to roll back %s, repository has not been onboarded for %s", e.RepoName, e.RepoProvider)
+		statusCode := 404
+		return message, statusCode
+	} else if err == errComponentNotFound {
+		message := fmt.Sprintf("Unable to roll back %s, component %s has not been onboarded", e.RepoName, e.Component)
+		statusCode := 404
+		return message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to roll back %s, could not read repository from backend", e.RepoName)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	target, err := rollbackTarget(r.TargetVersion, repo)
+	if err != nil {
+		message := fmt.Sprintf("Unable to roll back %s, %v", e.RepoName, err)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	// NOTE(SMT): rollbacks use the onboarded branches unless the client sends its own
+	if e.RepoOwner == "" {
+		e.RepoOwner = repo.RepoOwner
+	}
+	if e.BranchBase == "" {
+		e.BranchBase = repo.BranchBase
+	}
+	if e.BranchHead == "" {
+		e.BranchHead = repo.BranchHead
+	}
+
+	e.Rollback = target
+	e.Hotfix = false
+	if e.ReleaseVersion == "" && e.Bump == "" {
+		e.Bump = "patch"
+	}
+	if e.ReleaseBody == "" {
+		e.ReleaseBody = fmt.Sprintf("Rolls %v back from %v to %v.", e.RepoName, repo.CurrentVersion, target)
+	}
+	return app.release(event, e)
+}
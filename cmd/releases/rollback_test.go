@@ -0,0 +1,165 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func newRepositoryApplication(provider *fakeProvider, repo repository) (application, *[]*dynamodb.UpdateItemInput, map[string]map[string]*dynamodb.AttributeValue) {
+	app := newTestApplication(provider)
+	updates := []*dynamodb.UpdateItemInput{}
+	items := map[string]map[string]*dynamodb.AttributeValue{}
+	repo.RepoOwner = "test"
+	repo.BranchBase = "main"
+	repo.BranchHead = "develop"
+	app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repo), Items: items, UpdateInputs: &updates}
+	return app, &updates, items
+}
+
+func TestRollbackTarget(t *testing.T) {
+	tests := map[string]struct {
+		Target  string
+		Current string
+		Want    string
+		Error   bool
+	}{
+		"older version":         {Target: "1.1.0", Current: "v1.2.0", Want: "v1.1.0"},
+		"prefixed version":      {Target: "v1.1.0", Current: "v1.2.0", Want: "v1.1.0"},
+		"current version":       {Target: "1.2.0", Current: "v1.2.0", Error: true},
+		"newer version":         {Target: "1.3.0", Current: "v1.2.0", Error: true},
+		"invalid version":       {Target: "latest", Current: "v1.2.0", Error: true},
+		"unreleased repository": {Target: "1.0.0", Error: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := rollbackTarget(test.Target, repository{CurrentVersion: test.Current, TagPrefix: "v"})
+			if (err != nil) != test.Error || got != test.Want {
+				t.Fatalf("expected %v (error %v), got %v (%v)", test.Want, test.Error, got, err)
+			}
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	event := events.APIGatewayV2HTTPRequest{
+		RawPath: "/releases/rollback",
+		Body:    `{"repo_name": "test", "repo_provider": "fake", "target_version": "1.1.0"}`,
+	}
+
+	t.Run("Successfully rolled back by releasing the previous tag", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, updates, items := newRepositoryApplication(provider, repository{CurrentVersion: "1.2.0"})
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 200 {
+			t.Fatalf("Rollback should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.Releases) != 1 || provider.Releases[0] != "1.2.1" || provider.ReleaseTargets[0] != "sha-1.1.0" {
+			t.Fatalf("1.2.1 should have been released from the commit of 1.1.0, got %v %v", provider.Releases, provider.ReleaseTargets)
+		}
+		if len(provider.PullRequests) != 0 {
+			t.Fatal("Rollback should not have opened a pull request")
+		}
+		if len(*updates) != 1 || aws.StringValue((*updates)[0].ExpressionAttributeValues[":cv"].S) != "1.2.1" {
+			t.Fatal("Current version should have been updated to 1.2.1")
+		}
+
+		records := historyRecords(items)
+		if len(records) != 1 || records[0].RollbackTo != "1.1.0" {
+			t.Fatalf("Rollback should have been recorded in history, got %+v", records)
+		}
+	})
+
+	t.Run("Successfully rolled back by reverting the last merge", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, _, items := newRepositoryApplication(provider, repository{CurrentVersion: "1.2.0", RollbackStrategy: "revert"})
+		record, _ := dynamodbattribute.MarshalMap(releaseRecord{
+			PK:             historyPartitionKey("fake", "test"),
+			SK:             "2021-12-25T06:00:00.000Z#1.2.0",
+			ReleaseVersion: "1.2.0",
+			MergeSHA:       "merge120",
+			Outcome:        "succeeded",
+		})
+		items[mockItemKey(record)] = record
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 200 {
+			t.Fatalf("Rollback should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.Reverted) != 1 || provider.Reverted[0] != "rollback/1.2.1:merge120" {
+			t.Fatalf("Merge of 1.2.0 should have been reverted, got %v", provider.Reverted)
+		}
+
+		pr := provider.PullRequestEvents[0]
+		if pr.BranchHead != "rollback/1.2.1" || pr.BranchBase != "main" || pr.pullRequestTitle() != "1.2.1 (roll back to 1.1.0)" {
+			t.Fatalf("Revert pull request should merge the revert branch into main, got %+v", pr)
+		}
+		if len(provider.Merged) != 1 || len(provider.Releases) != 1 || provider.ReleaseTargets[0] != "main" {
+			t.Fatal("Revert should have been merged and released from main")
+		}
+	})
+
+	t.Run("Version which is not older is rejected", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, _, _ := newRepositoryApplication(provider, repository{CurrentVersion: "1.0.0"})
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 400 || len(provider.Releases) != 0 {
+			t.Fatalf("Rollback should have been rejected, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Only admins may roll back when releases require approvals", func(t *testing.T) {
+		provider := &fakeProvider{}
+		app, _, _ := newRepositoryApplication(provider, repository{CurrentVersion: "1.2.0"})
+		app.Config.RequiredApprovals = 1
+
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 403 {
+			t.Fatalf("Rollback should have been forbidden, got %v %v", resp.StatusCode, resp.Body)
+		}
+
+		resp, _ = app.handler(adminRequest("/releases/rollback", event.Body))
+		if resp.StatusCode != 200 || !strings.Contains(resp.Body, "1.2.1") {
+			t.Fatalf("Admin should have been able to roll back, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+}
+
+// mockHistoryPages returns one page of release history per query, the way DynamoDB does when the
+// version filter removes every item of the earlier pages
+type mockHistoryPages struct {
+	dynamodbiface.DynamoDBAPI
+	Pages []*dynamodb.QueryOutput
+}
+
+func (m mockHistoryPages) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	page := 0
+	if input.ExclusiveStartKey != nil {
+		page, _ = strconv.Atoi(aws.StringValue(input.ExclusiveStartKey["SK"].S))
+	}
+	return m.Pages[page], nil
+}
+
+func TestLastMergeSHA(t *testing.T) {
+	t.Run("Successfully found a merge beyond the first page of history", func(t *testing.T) {
+		record, _ := dynamodbattribute.MarshalMap(releaseRecord{ReleaseVersion: "1.2.0", Outcome: "succeeded", MergeSHA: "abc"})
+		app := awsController{TableName: "test", DB: mockHistoryPages{Pages: []*dynamodb.QueryOutput{
+			{LastEvaluatedKey: map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("release#fake#test")}, "SK": {S: aws.String("1")}}},
+			{Items: []map[string]*dynamodb.AttributeValue{record}},
+		}}}
+
+		sha, err := app.lastMergeSHA(releaseEvent{RepoProvider: "fake", RepoName: "test"}, repository{CurrentVersion: "1.2.0"})
+		if err != nil || sha != "abc" {
+			t.Fatalf("Merge of 1.2.0 should have been found on the second page, got %v %v", sha, err)
+		}
+	})
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	log "github.com/sirupsen/logrus"
+)
+
+// scheduledRelease is a release which the scheduler lambda sends to /releases/create once ReleaseAt
+// has passed. Body is the request body sent to /releases/create. Status is scheduled, started,
+// succeeded, failed or cancelled.
+type scheduledRelease struct {
+	PK          string       `dynamodbav:"PK"                    json:"-"`
+	SK          string       `dynamodbav:"SK"                    json:"-"`
+	ScheduleID  string       `dynamodbav:"ScheduleID"            json:"schedule_id"`
+	Release     releaseEvent `dynamodbav:"Release"               json:"release"`
+	Body        string       `dynamodbav:"Body"                  json:"-"`
+	ReleaseAt   string       `dynamodbav:"ReleaseAt"             json:"release_at"`
+	Status      string       `dynamodbav:"Status"                json:"status"`
+	ScheduledBy string       `dynamodbav:"ScheduledBy"           json:"scheduled_by"`
+	CancelledBy string       `dynamodbav:"CancelledBy,omitempty" json:"cancelled_by,omitempty"`
+	Message     string       `dynamodbav:"Message,omitempty"     json:"message,omitempty"`
+}
+
+// scheduleEvent is the body of /releases/schedule/create and /releases/schedule/cancel
+type scheduleEvent struct {
+	releaseEvent
+	ReleaseAt  string `json:"release_at"`
+	ScheduleID string `json:"schedule_id"`
+}
+
+// listScheduledReleases returns every scheduled release, following LastEvaluatedKey through every page
+func (app awsController) listScheduledReleases() ([]scheduledRelease, error) {
+	schedules := []scheduledRelease{}
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":primary_key": {
+				S: aws.String("schedule"),
+			}},
+		KeyConditionExpression: aws.String("PK = :primary_key"),
+		TableName:              aws.String(app.TableName),
+	}
+
+	for {
+		resp, err := app.DB.Query(input)
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok {
+				log.Error(fmt.Sprintf("%v", aerr.Error()))
+			} else {
+				log.Error(fmt.Sprintf("%v", err.Error()))
+			}
+			return schedules, err
+		}
+
+		page := []scheduledRelease{}
+		err = dynamodbattribute.UnmarshalListOfMaps(resp.Items, &page)
+		if err != nil {
+			log.Error(fmt.Sprintf("unable to unmarshal scheduled releases, %v", err))
+			return schedules, err
+		}
+		schedules = append(schedules, page...)
+
+		if len(resp.LastEvaluatedKey) == 0 {
+			return schedules, nil
+		}
+		input.ExclusiveStartKey = resp.LastEvaluatedKey
+	}
+}
+
+func (app awsController) putScheduledRelease(s scheduledRelease) error {
+	item, err := dynamodbattribute.MarshalMap(s)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal scheduled release, %v", err))
+		return err
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(app.TableName),
+	}
+
+	_, err = app.DB.PutItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return err
+	}
+	return nil
+}
+
+// scheduledReleaseID returns the id of the scheduled release which the scheduler lambda started with
+// the request. The scheduler invokes the lambda directly, so requests through API Gateway never carry
+// its request ids.
+func scheduledReleaseID(event events.APIGatewayV2HTTPRequest) (string, bool) {
+	if !strings.HasPrefix(event.RequestContext.RequestID, "schedule-") {
+		return "", false
+	}
+	return strings.TrimPrefix(event.RequestContext.RequestID, "schedule-"), true
+}
+
+// completeScheduledRelease records the outcome of a scheduled release which the scheduler started
+func (app awsController) completeScheduledRelease(id, message string, statusCode int) error {
+	outcome := "succeeded"
+	if statusCode != 200 {
+		outcome = "failed"
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		ConditionExpression: aws.String("#status = :started"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":started": {
+				S: aws.String("started"),
+			},
+			":outcome": {
+				S: aws.String(outcome),
+			},
+			":message": {
+				S: aws.String(message),
+			},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("schedule"),
+			},
+			"SK": {
+				S: aws.String(id),
+			},
+		},
+		TableName:        aws.String(app.TableName),
+		UpdateExpression: aws.String("SET #status = :outcome, Message = :message"),
+	}
+
+	log.Info(fmt.Sprintf("recording scheduled release %v as %v...", id, outcome))
+	_, err := app.DB.UpdateItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return err
+	}
+	return nil
+}
+
+// cancelScheduledRelease cancels the release unless the scheduler has already started it
+func (app awsController) cancelScheduledRelease(id, actor string) error {
+	input := &dynamodb.UpdateItemInput{
+		ConditionExpression: aws.String("#status = :scheduled"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":scheduled": {
+				S: aws.String("scheduled"),
+			},
+			":cancelled": {
+				S: aws.String("cancelled"),
+			},
+			":actor": {
+				S: aws.String(actor),
+			},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("schedule"),
+			},
+			"SK": {
+				S: aws.String(id),
+			},
+		},
+		TableName:        aws.String(app.TableName),
+		UpdateExpression: aws.String("SET #status = :cancelled, CancelledBy = :actor"),
+	}
+
+	log.Info(fmt.Sprintf("cancelling scheduled release %v...", id))
+	_, err := app.DB.UpdateItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return err
+	}
+	return nil
+}
+
+func (app application) releasesScheduleCreateHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	e := scheduleEvent{}
+	err := json.Unmarshal([]byte(event.Body), &e)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+	}
+
+	if app.Config.RequiredApprovals > 0 {
+		message := fmt.Sprintf("Unable to schedule release of %s, releases require %d approvals, request one through /releases/request", e.RepoName, app.Config.RequiredApprovals)
+		statusCode := 403
+		return message, statusCode
+	}
+
+	releaseAt, err := time.Parse(time.RFC3339, e.ReleaseAt)
+	if err != nil {
+		message := fmt.Sprintf("Unable to schedule release of %s, release_at must be an RFC 3339 time", e.RepoName)
+		statusCode := 400
+		return message, statusCode
+	}
+	if !releaseAt.After(time.Now()) {
+		message := fmt.Sprintf("Unable to schedule release of %s, release_at must be in the future", e.RepoName)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	if _, ok := app.Providers[e.RepoProvider]; !ok {
+		message := fmt.Sprintf("Unable to schedule release of %s, provider %s is not supported", e.RepoName, e.RepoProvider)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	repo, err := app.AWS.getRepository(e.releaseEvent)
+	if err == errRepositoryNotFound {
+		message := fmt.Sprintf("Unable to schedule release of %s, repository has not been onboarded for %s", e.RepoName, e.RepoProvider)
+		statusCode := 404
+		return message, statusCode
+	} else if err == errComponentNotFound {
+		message := fmt.Sprintf("Unable to schedule release of %s, component %s has not been onboarded", e.RepoName, e.Component)
+		statusCode := 404
+		return message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to schedule release of %s, could not read repository from backend", e.RepoName)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	// NOTE(SMT): bumps are resolved when the release runs, as other releases may happen in the meantime
+	_, err = resolveReleaseVersion(e.releaseEvent, repo)
+	if err != nil {
+		message := fmt.Sprintf("Unable to schedule release of %s, %v", e.RepoName, err)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	body, err := json.Marshal(e.releaseEvent)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal release, %v", err))
+		message := fmt.Sprintf("Unable to schedule release of %s", e.RepoName)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	id := event.RequestContext.RequestID
+	if id == "" {
+		id = fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+
+	s := scheduledRelease{
+		PK:          "schedule",
+		SK:          id,
+		ScheduleID:  id,
+		Release:     e.releaseEvent,
+		Body:        string(body),
+		ReleaseAt:   releaseAt.UTC().Format(time.RFC3339),
+		Status:      "scheduled",
+		ScheduledBy: requestActor(event),
+	}
+	err = app.AWS.putScheduledRelease(s)
+	if err != nil {
+		message := fmt.Sprintf("Unable to schedule release of %s, could not write schedule to backend", e.RepoName)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	body, err = json.Marshal(s)
+	statusCode := 200
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal json for response, %v", err))
+		statusCode = 400
+	}
+
+	var buf bytes.Buffer
+	json.HTMLEscape(&buf, body)
+	return buf.String(), statusCode
+}
+
+func (app application) releasesScheduleListHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	schedules, err := app.AWS.listScheduledReleases()
+	if err != nil {
+		message := "Failed to query scheduled releases"
+		statusCode := 400
+		return message, statusCode
+	}
+
+	body, err := json.Marshal(schedules)
+	statusCode := 200
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal json for response, %v", err))
+		statusCode = 400
+	}
+
+	var buf bytes.Buffer
+	json.HTMLEscape(&buf, body)
+	return buf.String(), statusCode
+}
+
+func (app application) releasesScheduleCancelHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	e := scheduleEvent{}
+	err := json.Unmarshal([]byte(event.Body), &e)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+	}
+
+	if e.ScheduleID == "" {
+		message := "Unable to cancel scheduled release, schedule_id is required"
+		statusCode := 400
+		return message, statusCode
+	}
+
+	err = app.AWS.cancelScheduledRelease(e.ScheduleID, requestActor(event))
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		message := fmt.Sprintf("Unable to cancel scheduled release %v, it does not exist or has already started", e.ScheduleID)
+		statusCode := 409
+		return message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to cancel scheduled release %v, could not write to backend", e.ScheduleID)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	message := fmt.Sprintf("Cancelled scheduled release %v", e.ScheduleID)
+	statusCode := 200
+	return message, statusCode
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func TestScheduledReleases(t *testing.T) {
+	t.Run("Successfully scheduled and listed release", func(t *testing.T) {
+		app := newTestApplication(&fakeProvider{})
+		items := map[string]map[string]*dynamodb.AttributeValue{}
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: items}
+		releaseAt := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+
+		event := userRequest("/releases/schedule/create", fmt.Sprintf(`{"repo_name": "test", "repo_provider": "fake", "release_version": "2.3.0", "release_at": "%v"}`, releaseAt), "jane@example.com")
+		event.RequestContext.RequestID = "abc"
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 200 {
+			t.Fatalf("Release should have been scheduled, got %v %v", resp.StatusCode, resp.Body)
+		}
+
+		s := scheduledRelease{}
+		_ = dynamodbattribute.UnmarshalMap(items["schedule|abc"], &s)
+		if s.Status != "scheduled" || s.ReleaseAt != releaseAt || s.Release.ReleaseVersion != "2.3.0" || s.ScheduledBy != "jane@example.com" {
+			t.Fatalf("Scheduled release has the wrong status, time, version or user, got %+v", s)
+		}
+
+		resp, _ = app.handler(events.APIGatewayV2HTTPRequest{RawPath: "/releases/schedule/list"})
+		if resp.StatusCode != 200 || !strings.Contains(resp.Body, "abc") {
+			t.Fatalf("Scheduled release should have been listed, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Release scheduled in the past is rejected", func(t *testing.T) {
+		app := newTestApplication(&fakeProvider{})
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/schedule/create",
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "release_version": "2.3.0", "release_at": "2021-01-01T06:00:00Z"}`,
+		})
+		if resp.StatusCode != 400 {
+			t.Fatalf("Release should not have been scheduled, got %v", resp.StatusCode)
+		}
+	})
+
+	t.Run("Release cannot be scheduled when releases require approvals", func(t *testing.T) {
+		app := newTestApplication(&fakeProvider{})
+		items := map[string]map[string]*dynamodb.AttributeValue{}
+		app.AWS.DB = mockDynamoDB{GetItemResponse: repositoryItem(repository{}), Items: items}
+		app.Config.RequiredApprovals = 2
+		releaseAt := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/schedule/create",
+			Body:    fmt.Sprintf(`{"repo_name": "test", "repo_provider": "fake", "release_version": "2.3.0", "release_at": "%v"}`, releaseAt),
+		})
+		if resp.StatusCode != 403 || len(items) != 0 {
+			t.Fatalf("Release should not have been scheduled, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Successfully recorded the outcome of a scheduled release", func(t *testing.T) {
+		app, updates, _ := newRepositoryApplication(&fakeProvider{}, repository{CurrentVersion: "2.2.0"})
+
+		event := events.APIGatewayV2HTTPRequest{
+			RawPath: "/releases/create",
+			Headers: map[string]string{"idempotency-key": "schedule#abc"},
+			Body:    `{"repo_name": "test", "repo_provider": "fake", "repo_owner": "test", "branch_base": "main", "branch_head": "develop", "release_version": "2.3.0"}`,
+		}
+		event.RequestContext.RequestID = "schedule-abc"
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 200 {
+			t.Fatalf("Scheduled release should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+
+		recorded := false
+		for _, update := range *updates {
+			if aws.StringValue(update.Key["PK"].S) == "schedule" && aws.StringValue(update.Key["SK"].S) == "abc" {
+				recorded = aws.StringValue(update.ExpressionAttributeValues[":outcome"].S) == "succeeded"
+			}
+		}
+		if !recorded {
+			t.Fatalf("Scheduled release abc should have been recorded as succeeded, got %+v", *updates)
+		}
+	})
+
+	t.Run("Successfully cancelled scheduled release", func(t *testing.T) {
+		app := newTestApplication(&fakeProvider{})
+		updates := []*dynamodb.UpdateItemInput{}
+		app.AWS.DB = mockDynamoDB{UpdateInputs: &updates}
+
+		resp, _ := app.handler(userRequest("/releases/schedule/cancel", `{"schedule_id": "abc"}`, "jane@example.com"))
+		if resp.StatusCode != 200 {
+			t.Fatalf("Scheduled release should have been cancelled, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(updates) != 1 || aws.StringValue(updates[0].Key["SK"].S) != "abc" || aws.StringValue(updates[0].ExpressionAttributeValues[":actor"].S) != "jane@example.com" {
+			t.Fatalf("Scheduled release abc should have been cancelled by jane, got %+v", updates)
+		}
+	})
+
+	t.Run("Started release cannot be cancelled", func(t *testing.T) {
+		app := newTestApplication(&fakeProvider{})
+		app.AWS.DB = mockDynamoDB{Error: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", errors.New(""))}
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{RawPath: "/releases/schedule/cancel", Body: `{"schedule_id": "abc"}`})
+		if resp.StatusCode != 409 {
+			t.Fatalf("Scheduled release should not have been cancelled, got %v", resp.StatusCode)
+		}
+	})
+}
+
+func TestListScheduledReleases(t *testing.T) {
+	t.Run("Successfully listed schedules beyond the first page", func(t *testing.T) {
+		first, _ := dynamodbattribute.MarshalMap(scheduledRelease{ScheduleID: "1"})
+		second, _ := dynamodbattribute.MarshalMap(scheduledRelease{ScheduleID: "2"})
+		app := awsController{TableName: "test", DB: mockHistoryPages{Pages: []*dynamodb.QueryOutput{
+			{Items: []map[string]*dynamodb.AttributeValue{first}, LastEvaluatedKey: map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("schedule")}, "SK": {S: aws.String("1")}}},
+			{Items: []map[string]*dynamodb.AttributeValue{second}},
+		}}}
+
+		schedules, err := app.listScheduledReleases()
+		if err != nil || len(schedules) != 2 || schedules[1].ScheduleID != "2" {
+			t.Fatalf("Schedules on both pages should have been listed, got %+v %v", schedules, err)
+		}
+	})
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// semverPattern is the regular expression suggested by https://semver.org
+var semverPattern = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// semver is a parsed semantic version
+type semver struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Build      string
+}
+
+// parseSemver parses version after removing the repository's tag prefix, e.g. v1.2.3 with prefix v
+func parseSemver(version, prefix string) (semver, error) {
+	match := semverPattern.FindStringSubmatch(strings.TrimPrefix(version, prefix))
+	if match == nil {
+		if prefix != "" {
+			return semver{}, fmt.Errorf("version %v is not a semantic version with optional prefix %v, e.g. %v1.2.3", version, prefix, prefix)
+		}
+		return semver{}, fmt.Errorf("version %v is not a semantic version, e.g. 1.2.3", version)
+	}
+
+	v := semver{Prerelease: match[4], Build: match[5]}
+	v.Major, _ = strconv.Atoi(match[1])
+	v.Minor, _ = strconv.Atoi(match[2])
+	v.Patch, _ = strconv.Atoi(match[3])
+	return v, nil
+}
+
+func (v semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// compare returns -1, 0 or 1 when v has lower, equal or higher precedence than other. Build metadata
+// is ignored, as per the specification.
+func (v semver) compare(other semver) int {
+	for _, diff := range []int{v.Major - other.Major, v.Minor - other.Minor, v.Patch - other.Patch} {
+		if diff < 0 {
+			return -1
+		} else if diff > 0 {
+			return 1
+		}
+	}
+
+	// a version without a prerelease has higher precedence than one with a prerelease
+	if v.Prerelease == other.Prerelease {
+		return 0
+	} else if v.Prerelease == "" {
+		return 1
+	} else if other.Prerelease == "" {
+		return -1
+	}
+
+	a := strings.Split(v.Prerelease, ".")
+	b := strings.Split(other.Prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := comparePrereleaseIdentifier(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+
+	if len(a) < len(b) {
+		return -1
+	} else if len(a) > len(b) {
+		return 1
+	}
+	return 0
+}
+
+func comparePrereleaseIdentifier(a, b string) int {
+	numA, errA := strconv.Atoi(a)
+	numB, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		if numA < numB {
+			return -1
+		} else if numA > numB {
+			return 1
+		}
+		return 0
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// bump returns the next version for a major, minor or patch release. Bumping a prerelease releases the
+// version it precedes when possible, e.g. a patch bump of 1.2.3-rc.1 is 1.2.3.
+func (v semver) bump(part string) (semver, error) {
+	next := semver{Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+	switch part {
+	case "major":
+		if v.Prerelease == "" || v.Minor != 0 || v.Patch != 0 {
+			next = semver{Major: v.Major + 1}
+		}
+	case "minor":
+		if v.Prerelease == "" || v.Patch != 0 {
+			next = semver{Major: v.Major, Minor: v.Minor + 1}
+		}
+	case "patch":
+		if v.Prerelease == "" {
+			next.Patch++
+		}
+	default:
+		return semver{}, errors.New("bump must be one of major, minor or patch")
+	}
+	return next, nil
+}
+
+// releaseCandidatePattern matches the prerelease identifiers of release candidates, e.g. rc.2
+var releaseCandidatePattern = regexp.MustCompile(`^rc\.(0|[1-9]\d*)$`)
+
+// releaseCandidate returns v with the repository's tag prefix. Release candidates of a final version
+// are numbered after the repository's current prerelease, e.g. 1.3.0-rc.3 follows 1.3.0-rc.2, and
+// start at rc.1 otherwise.
+func releaseCandidate(e releaseEvent, repo repository, v semver) string {
+	if !e.Prerelease || v.Prerelease != "" {
+		return repo.TagPrefix + v.String()
+	}
+
+	n := 1
+	if current, err := parseSemver(repo.CurrentPrerelease, repo.TagPrefix); err == nil {
+		match := releaseCandidatePattern.FindStringSubmatch(current.Prerelease)
+		if match != nil && current.Major == v.Major && current.Minor == v.Minor && current.Patch == v.Patch {
+			n, _ = strconv.Atoi(match[1])
+			n++
+		}
+	}
+	v.Prerelease = fmt.Sprintf("rc.%d", n)
+	return repo.TagPrefix + v.String()
+}
+
+// promotedVersion returns the final version of the release candidate being promoted, e.g. 1.3.0 for
+// 1.3.0-rc.2
+func promotedVersion(e releaseEvent, repo repository, current *semver) (string, error) {
+	if e.Bump != "" || e.Prerelease || e.Hotfix {
+		return "", errors.New("promote cannot be combined with bump, prerelease or hotfix")
+	}
+
+	rc, err := parseSemver(e.Promote, repo.TagPrefix)
+	if err != nil {
+		return "", err
+	}
+	if rc.Prerelease == "" {
+		return "", fmt.Errorf("version %v is not a release candidate", e.Promote)
+	}
+
+	final := semver{Major: rc.Major, Minor: rc.Minor, Patch: rc.Patch}
+	version := repo.TagPrefix + final.String()
+	if e.ReleaseVersion != "" && e.ReleaseVersion != version {
+		return "", fmt.Errorf("release candidate %v is promoted to %v, not %v", e.Promote, version, e.ReleaseVersion)
+	}
+	if current != nil && final.compare(*current) <= 0 {
+		return "", fmt.Errorf("version %v must be greater than the current version %v", version, repo.CurrentVersion)
+	}
+	return version, nil
+}
+
+// resolveReleaseVersion validates the requested release version, or computes it from the bump,
+// against the repository's current version. The returned version includes the repository's tag
+// prefix, and is used as the tag name.
+func resolveReleaseVersion(e releaseEvent, repo repository) (string, error) {
+	if e.ReleaseVersion != "" && e.Bump != "" {
+		return "", errors.New("provide either release_version or bump, not both")
+	}
+	if e.Prerelease && e.Hotfix {
+		return "", errors.New("hotfixes cannot be released as release candidates")
+	}
+
+	var current *semver
+	if repo.CurrentVersion != "" {
+		v, err := parseSemver(repo.CurrentVersion, repo.TagPrefix)
+		if err == nil {
+			current = &v
+		} else if e.Bump != "" {
+			return "", fmt.Errorf("unable to bump current version, %v", err)
+		}
+	}
+
+	if e.Promote != "" {
+		return promotedVersion(e, repo, current)
+	}
+
+	if e.Bump != "" {
+		base := semver{}
+		if current != nil {
+			base = *current
+		}
+		next, err := base.bump(e.Bump)
+		if err != nil {
+			return "", err
+		}
+		return releaseCandidate(e, repo, next), nil
+	}
+
+	if e.ReleaseVersion == "" {
+		return "", errors.New("provide either release_version or bump")
+	}
+
+	requested, err := parseSemver(e.ReleaseVersion, repo.TagPrefix)
+	if err != nil {
+		return "", err
+	}
+	if requested.Prerelease != "" && !e.Prerelease {
+		return "", fmt.Errorf("version %v is a prerelease, release it with prerelease", e.ReleaseVersion)
+	}
+
+	if current != nil && requested.compare(*current) <= 0 {
+		return "", fmt.Errorf("version %v must be greater than the current version %v", e.ReleaseVersion, repo.CurrentVersion)
+	}
+	return releaseCandidate(e, repo, requested), nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+func TestParseSemver(t *testing.T) {
+	for _, version := range []string{"1.2", "01.2.3", "1.2.3.4", "v1.2.3", "latest"} {
+		if _, err := parseSemver(version, ""); err == nil {
+			t.Errorf("Version %v should not have parsed", version)
+		}
+	}
+
+	v, err := parseSemver("v1.2.3-rc.1+build.5", "v")
+	if err != nil {
+		t.Fatalf("Version should have parsed, %v", err)
+	}
+	if v.String() != "1.2.3-rc.1+build.5" {
+		t.Fatalf("Version should have round tripped, got %v", v)
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.1.0", "2.0.0"}
+	for i := 0; i < len(ordered)-1; i++ {
+		a, _ := parseSemver(ordered[i], "")
+		b, _ := parseSemver(ordered[i+1], "")
+		if a.compare(b) != -1 || b.compare(a) != 1 {
+			t.Errorf("%v should have lower precedence than %v", ordered[i], ordered[i+1])
+		}
+	}
+}
+
+func TestResolveReleaseVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    releaseEvent
+		repo     repository
+		expected string
+		valid    bool
+	}{
+		{"Explicit version", releaseEvent{ReleaseVersion: "1.3.0"}, repository{CurrentVersion: "1.2.3"}, "1.3.0", true},
+		{"Explicit version with prefix", releaseEvent{ReleaseVersion: "v1.3.0"}, repository{CurrentVersion: "v1.2.3", TagPrefix: "v"}, "v1.3.0", true},
+		{"Prefix is added to explicit version", releaseEvent{ReleaseVersion: "1.3.0"}, repository{CurrentVersion: "v1.2.3", TagPrefix: "v"}, "v1.3.0", true},
+		{"First release", releaseEvent{ReleaseVersion: "0.1.0"}, repository{}, "0.1.0", true},
+		{"Malformed version", releaseEvent{ReleaseVersion: "1.2"}, repository{CurrentVersion: "1.1.0"}, "", false},
+		{"Lower version", releaseEvent{ReleaseVersion: "1.2.2"}, repository{CurrentVersion: "1.2.3"}, "", false},
+		{"Same version", releaseEvent{ReleaseVersion: "1.2.3"}, repository{CurrentVersion: "1.2.3"}, "", false},
+		{"Major bump", releaseEvent{Bump: "major"}, repository{CurrentVersion: "1.2.3"}, "2.0.0", true},
+		{"Minor bump", releaseEvent{Bump: "minor"}, repository{CurrentVersion: "v1.2.3", TagPrefix: "v"}, "v1.3.0", true},
+		{"Patch bump", releaseEvent{Bump: "patch"}, repository{CurrentVersion: "1.2.3"}, "1.2.4", true},
+		{"Patch bump of prerelease", releaseEvent{Bump: "patch"}, repository{CurrentVersion: "1.2.3-rc.1"}, "1.2.3", true},
+		{"Bump of first release", releaseEvent{Bump: "minor"}, repository{}, "0.1.0", true},
+		{"Unknown bump", releaseEvent{Bump: "huge"}, repository{CurrentVersion: "1.2.3"}, "", false},
+		{"Version and bump", releaseEvent{ReleaseVersion: "1.3.0", Bump: "minor"}, repository{}, "", false},
+		{"Neither version nor bump", releaseEvent{}, repository{}, "", false},
+		{"First release candidate", releaseEvent{Bump: "minor", Prerelease: true}, repository{CurrentVersion: "1.2.3"}, "1.3.0-rc.1", true},
+		{"Next release candidate", releaseEvent{Bump: "minor", Prerelease: true}, repository{CurrentVersion: "v1.2.3", CurrentPrerelease: "v1.3.0-rc.2", TagPrefix: "v"}, "v1.3.0-rc.3", true},
+		{"Release candidate of another version", releaseEvent{ReleaseVersion: "1.4.0", Prerelease: true}, repository{CurrentVersion: "1.2.3", CurrentPrerelease: "1.3.0-rc.2"}, "1.4.0-rc.1", true},
+		{"Explicit release candidate", releaseEvent{ReleaseVersion: "1.3.0-rc.5", Prerelease: true}, repository{CurrentVersion: "1.2.3"}, "1.3.0-rc.5", true},
+		{"Explicit prerelease without prerelease", releaseEvent{ReleaseVersion: "1.3.0-rc.1"}, repository{CurrentVersion: "1.2.3"}, "", false},
+		{"Hotfix release candidate", releaseEvent{Bump: "patch", Prerelease: true, Hotfix: true}, repository{CurrentVersion: "1.2.3"}, "", false},
+		{"Promoted release candidate", releaseEvent{Promote: "v1.3.0-rc.2"}, repository{CurrentVersion: "v1.2.3", TagPrefix: "v"}, "v1.3.0", true},
+		{"Promoted release candidate with its version", releaseEvent{Promote: "1.3.0-rc.2", ReleaseVersion: "1.3.0"}, repository{CurrentVersion: "1.2.3"}, "1.3.0", true},
+		{"Promoted release candidate with another version", releaseEvent{Promote: "1.3.0-rc.2", ReleaseVersion: "1.4.0"}, repository{CurrentVersion: "1.2.3"}, "", false},
+		{"Promoted final version", releaseEvent{Promote: "1.3.0"}, repository{CurrentVersion: "1.2.3"}, "", false},
+		{"Promoted release candidate which was released", releaseEvent{Promote: "1.3.0-rc.2"}, repository{CurrentVersion: "1.3.0"}, "", false},
+		{"Promoted release candidate with bump", releaseEvent{Promote: "1.3.0-rc.2", Bump: "minor"}, repository{CurrentVersion: "1.2.3"}, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			version, err := resolveReleaseVersion(test.event, test.repo)
+			if test.valid && (err != nil || version != test.expected) {
+				t.Fatalf("Expected %v, got %v %v", test.expected, version, err)
+			}
+			if !test.valid && err == nil {
+				t.Fatalf("Expected an error, got %v", version)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	log "github.com/sirupsen/logrus"
+)
+
+// releaseSteps are the steps of the release workflow, in the order they complete. Hotfixes skip the
+// pull request steps, as do release candidates, only hotfixes are back-merged, and only promotions
+// publish the release candidate they promote. Drafts stop after notified, their version is recorded
+// when they are published.
+var releaseSteps = []string{"started", "pr_opened", "mergeable", "checks_passed", "merged", "tagged", "artifacts_uploaded", "back_merged", "candidate_published", "notified", "version_recorded"}
+
+// releaseStateTTL is how long the state of a release is kept after it was last updated
+const releaseStateTTL = 30 * 24 * time.Hour
+
+var errReleaseStateNotFound = errors.New("release has not been started")
+
+// releaseState is the progress of a release, persisted after every step so that re-submitting the
+// same release resumes from the last completed step
+type releaseState struct {
+	PK                string `dynamodbav:"PK"`
+	SK                string `dynamodbav:"SK"`
+	IdempotencyKey    string `dynamodbav:"IdempotencyKey"`
+	ReleaseVersion    string `dynamodbav:"ReleaseVersion"`
+	ReleaseBody       string `dynamodbav:"ReleaseBody"`
+	Hotfix            bool   `dynamodbav:"Hotfix"`
+	Prerelease        bool   `dynamodbav:"Prerelease,omitempty"`
+	Draft             bool   `dynamodbav:"Draft,omitempty"`
+	Step              string `dynamodbav:"Step"`
+	PullRequestNumber int    `dynamodbav:"PullRequestNumber,omitempty"`
+	PullRequestURL    string `dynamodbav:"PullRequestURL,omitempty"`
+	MergeSHA          string `dynamodbav:"MergeSHA,omitempty"`
+	BackMergeURL      string `dynamodbav:"BackMergeURL,omitempty"`
+	DraftReleaseID    string `dynamodbav:"DraftReleaseID,omitempty"`
+	UpdatedAt         string `dynamodbav:"UpdatedAt"`
+	ExpiresAt         int64  `dynamodbav:"ExpiresAt"`
+
+	Artifacts []releasedArtifact `dynamodbav:"Artifacts,omitempty"`
+}
+
+// requestIdempotencyKey returns the key sent by the client in the Idempotency-Key header or the
+// idempotency_key field. Releases without a key are identified by their version.
+func requestIdempotencyKey(event events.APIGatewayV2HTTPRequest, e releaseEvent) string {
+	// NOTE(SMT): API Gateway lowercases the names of headers
+	if key := event.Headers["idempotency-key"]; key != "" {
+		return key
+	}
+	return e.IdempotencyKey
+}
+
+func newReleaseState(e releaseEvent, key string) releaseState {
+	return releaseState{
+		PK:             fmt.Sprintf("release_state#%s#%s", e.RepoProvider, e.RepoName),
+		SK:             key,
+		IdempotencyKey: key,
+		ReleaseVersion: e.ReleaseVersion,
+		ReleaseBody:    e.ReleaseBody,
+		Hotfix:         e.Hotfix,
+		Prerelease:     e.Prerelease,
+		Draft:          e.Draft,
+		Step:           "started",
+	}
+}
+
+// done returns true once step, or a later step, has completed
+func (s releaseState) done(step string) bool {
+	current, target := -1, -1
+	for i, name := range releaseSteps {
+		if name == s.Step {
+			current = i
+		}
+		if name == step {
+			target = i
+		}
+	}
+	return current >= target
+}
+
+// completeStep records that step has completed. The release continues when the state cannot be
+// saved, as a failure to save only means that a retry repeats the step.
+func (app application) completeStep(s *releaseState, step string) {
+	s.Step = step
+	now := time.Now()
+	s.UpdatedAt = now.UTC().Format(historyTimeFormat)
+	s.ExpiresAt = now.Add(releaseStateTTL).Unix()
+
+	err := app.AWS.putReleaseState(*s)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to save %v release %v step %v", s.PK, s.ReleaseVersion, step))
+	}
+}
+
+func (app awsController) getReleaseState(e releaseEvent, key string) (releaseState, error) {
+	state := newReleaseState(e, key)
+	input := &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String(state.PK),
+			},
+			"SK": {
+				S: aws.String(state.SK),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String(app.TableName),
+	}
+
+	resp, err := app.DB.GetItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return state, err
+	}
+
+	if len(resp.Item) == 0 {
+		return state, errReleaseStateNotFound
+	}
+
+	err = dynamodbattribute.UnmarshalMap(resp.Item, &state)
+	return state, err
+}
+
+func (app awsController) putReleaseState(s releaseState) error {
+	item, err := dynamodbattribute.MarshalMap(s)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal release state, %v", err))
+		return err
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(app.TableName),
+	}
+
+	_, err = app.DB.PutItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return err
+	}
+	return nil
+}
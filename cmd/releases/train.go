@@ -0,0 +1,657 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	log "github.com/sirupsen/logrus"
+)
+
+// trainStaleAfter is how long a running train may go without recording progress before it is marked
+// as failed, e.g. when the invocation running one of its releases timed out
+const trainStaleAfter = 15 * time.Minute
+
+// trainStepPath is invoked by the releases lambda to run one release of a train. It is not routed by
+// API Gateway.
+const trainStepPath = "/releases/train/step"
+
+// trainExpirePath is invoked by the scheduler lambda on every run to fail the trains which have gone
+// stale. It is not routed by API Gateway.
+const trainExpirePath = "/releases/train/expire"
+
+var errTrainNotFound = errors.New("release train does not exist")
+
+var errTrainChanged = errors.New("release train has been changed since it was read")
+
+// trainEvent is the body of /releases/train/create. Each release accepts the same fields as a deploy,
+// along with the names of the releases in the train which must be released before it. Releases are
+// named after their repository, or repository/component for components of a monorepo.
+type trainEvent struct {
+	Name     string         `json:"name"`
+	Releases []trainRelease `json:"releases"`
+}
+
+type trainRelease struct {
+	releaseEvent
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+// name is the name which other releases of the train depend on
+func (r trainRelease) name() string {
+	if r.Component != "" {
+		return fmt.Sprintf("%s/%s", r.RepoName, r.Component)
+	}
+	return r.RepoName
+}
+
+// trainStep is the body of a request to trainStepPath, which runs release Index of the train stored
+// under SK
+type trainStep struct {
+	SK    string `json:"sk"`
+	Index int    `json:"index"`
+}
+
+// trainResult is the outcome of one release of a train, which is pending, running, succeeded, failed,
+// or skipped when an earlier release failed
+type trainResult struct {
+	RepoProvider   string `dynamodbav:"RepoProvider"         json:"repo_provider"`
+	RepoName       string `dynamodbav:"RepoName"             json:"repo_name"`
+	Component      string `dynamodbav:"Component,omitempty"  json:"component,omitempty"`
+	ReleaseVersion string `dynamodbav:"ReleaseVersion"       json:"release_version"`
+	Outcome        string `dynamodbav:"Outcome"              json:"outcome"`
+	StatusCode     int    `dynamodbav:"StatusCode,omitempty" json:"status_code,omitempty"`
+	Message        string `dynamodbav:"Message,omitempty"    json:"message,omitempty"`
+}
+
+// trainRun is a release train, stored in DynamoDB when it starts and again as each of its releases
+// starts and completes. Outcome is running, succeeded or failed. Revision is incremented on every
+// write, so that a step which is invoked twice only runs once.
+type trainRun struct {
+	PK          string        `dynamodbav:"PK"                    json:"-"`
+	SK          string        `dynamodbav:"SK"                    json:"-"`
+	TrainID     string        `dynamodbav:"TrainID"               json:"train_id"`
+	Name        string        `dynamodbav:"Name,omitempty"        json:"name,omitempty"`
+	StartedBy   string        `dynamodbav:"StartedBy,omitempty"   json:"started_by,omitempty"`
+	StartedAt   string        `dynamodbav:"StartedAt"             json:"started_at"`
+	UpdatedAt   string        `dynamodbav:"UpdatedAt"             json:"updated_at"`
+	CompletedAt string        `dynamodbav:"CompletedAt,omitempty" json:"completed_at,omitempty"`
+	Outcome     string        `dynamodbav:"Outcome"               json:"outcome"`
+	Results     []trainResult `dynamodbav:"Results"               json:"results"`
+	Revision    int           `dynamodbav:"Revision"              json:"-"`
+
+	// NOTE(SMT): the resolved releases, in the order they run, so that every step releases exactly
+	// what was validated when the train was created
+	Releases []releaseEvent `dynamodbav:"Releases" json:"-"`
+}
+
+// finish completes the train with outcome, skipping the releases which have not started
+func (run *trainRun) finish(outcome string, now time.Time) {
+	for i := range run.Results {
+		if run.Results[i].Outcome == "pending" {
+			run.Results[i].Outcome = "skipped"
+		}
+	}
+	run.Outcome = outcome
+	run.UpdatedAt = now.UTC().Format(historyTimeFormat)
+	run.CompletedAt = run.UpdatedAt
+}
+
+// expire fails a running train which has not recorded progress for trainStaleAfter, and reports
+// whether it did
+func (run *trainRun) expire(now time.Time) bool {
+	updated, err := time.Parse(historyTimeFormat, run.UpdatedAt)
+	if run.Outcome != "running" || err != nil || now.Sub(updated) < trainStaleAfter {
+		return false
+	}
+
+	for i := range run.Results {
+		if run.Results[i].Outcome == "running" {
+			run.Results[i].Outcome = "failed"
+			run.Results[i].Message = fmt.Sprintf("release did not complete within %v", trainStaleAfter)
+		}
+	}
+	run.finish("failed", now)
+	return true
+}
+
+// trainOrder orders the releases so that every release follows the releases it depends on. Releases
+// which do not depend on each other keep the order they were sent in.
+func trainOrder(releases []trainRelease) ([]trainRelease, error) {
+	if len(releases) == 0 {
+		return nil, errors.New("a train needs at least one release")
+	}
+
+	index := map[string]int{}
+	for i, r := range releases {
+		if _, ok := index[r.name()]; ok {
+			return nil, fmt.Errorf("%v is in the train more than once", r.name())
+		}
+		index[r.name()] = i
+	}
+
+	waiting := make([]int, len(releases))
+	dependents := make([][]int, len(releases))
+	for i, r := range releases {
+		for _, dependency := range r.DependsOn {
+			j, ok := index[dependency]
+			if !ok {
+				return nil, fmt.Errorf("%v depends on %v, which is not in the train", r.name(), dependency)
+			}
+			waiting[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	ordered := []trainRelease{}
+	released := make([]bool, len(releases))
+	for len(ordered) < len(releases) {
+		next := -1
+		for i := range releases {
+			if !released[i] && waiting[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			return nil, errors.New("the dependencies of the train form a cycle")
+		}
+
+		released[next] = true
+		ordered = append(ordered, releases[next])
+		for _, i := range dependents[next] {
+			waiting[i]--
+		}
+	}
+	return ordered, nil
+}
+
+// trainSummary is the Slack message which describes the outcome of every release of the train
+func trainSummary(run trainRun) string {
+	lines := []string{fmt.Sprintf("Release train %v %v:", run.Name, run.Outcome)}
+	for _, r := range run.Results {
+		line := fmt.Sprintf("• %v %v %v", r.RepoName, r.ReleaseVersion, r.Outcome)
+		if r.Outcome == "failed" {
+			line = fmt.Sprintf("%v, %v", line, r.Message)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// putTrainRun writes run and increments its revision. The write fails with errTrainChanged when
+// another invocation has written run since it was read.
+func (app awsController) putTrainRun(run *trainRun) error {
+	revision := run.Revision
+	run.Revision++
+	item, err := dynamodbattribute.MarshalMap(run)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal release train, %v", err))
+		return err
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(app.TableName),
+	}
+	if revision == 0 {
+		input.ConditionExpression = aws.String("attribute_not_exists(PK)")
+	} else {
+		input.ConditionExpression = aws.String("Revision = :revision")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":revision": {
+				N: aws.String(strconv.Itoa(revision)),
+			},
+		}
+	}
+
+	_, err = app.DB.PutItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return errTrainChanged
+	} else if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return err
+	}
+	return nil
+}
+
+func (app awsController) getTrainRun(sk string) (trainRun, error) {
+	run := trainRun{}
+	input := &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {S: aws.String("release_train")},
+			"SK": {S: aws.String(sk)},
+		},
+		TableName: aws.String(app.TableName),
+	}
+
+	resp, err := app.DB.GetItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return run, err
+	}
+
+	if len(resp.Item) == 0 {
+		return run, errTrainNotFound
+	}
+
+	err = dynamodbattribute.UnmarshalMap(resp.Item, &run)
+	return run, err
+}
+
+// listTrainRuns returns the most recent release trains, newest first
+func (app awsController) listTrainRuns(limit int64) ([]trainRun, error) {
+	runs := []trainRun{}
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":primary_key": {
+				S: aws.String("release_train"),
+			}},
+		KeyConditionExpression: aws.String("PK = :primary_key"),
+		Limit:                  aws.Int64(limit),
+		ScanIndexForward:       aws.Bool(false),
+		TableName:              aws.String(app.TableName),
+	}
+
+	resp, err := app.DB.Query(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return runs, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(resp.Items, &runs)
+	return runs, err
+}
+
+// listRunningTrains returns every release train which is still running
+func (app awsController) listRunningTrains() ([]trainRun, error) {
+	runs := []trainRun{}
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":primary_key": {
+				S: aws.String("release_train"),
+			},
+			":running": {
+				S: aws.String("running"),
+			},
+		},
+		FilterExpression:       aws.String("Outcome = :running"),
+		KeyConditionExpression: aws.String("PK = :primary_key"),
+		TableName:              aws.String(app.TableName),
+	}
+
+	for {
+		resp, err := app.DB.Query(input)
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok {
+				log.Error(fmt.Sprintf("%v", aerr.Error()))
+			} else {
+				log.Error(fmt.Sprintf("%v", err.Error()))
+			}
+			return runs, err
+		}
+
+		page := []trainRun{}
+		err = dynamodbattribute.UnmarshalListOfMaps(resp.Items, &page)
+		if err != nil {
+			return runs, err
+		}
+		runs = append(runs, page...)
+
+		if len(resp.LastEvaluatedKey) == 0 {
+			return runs, nil
+		}
+		input.ExclusiveStartKey = resp.LastEvaluatedKey
+	}
+}
+
+// expireTrain fails run if it has gone stale and posts its summary. A run which has been written since
+// it was read is left alone, as it has either recorded progress or already expired.
+func (app application) expireTrain(run *trainRun) {
+	if !run.expire(time.Now()) {
+		return
+	}
+
+	err := app.AWS.putTrainRun(run)
+	if err == errTrainChanged {
+		log.Info(fmt.Sprintf("release train %v has changed since it was read, not expiring it", run.TrainID))
+		return
+	} else if err != nil {
+		log.Error(fmt.Sprintf("unable to record outcome of release train %v", run.TrainID))
+		return
+	}
+	app.notify(trainSummary(*run))
+}
+
+// trainStepRequest is the request which runs release index of the train. It is made on behalf of the
+// user who started the train, and locks each repository under the same id should the step be retried.
+func trainStepRequest(run trainRun, index int) (events.APIGatewayV2HTTPRequest, error) {
+	body, err := json.Marshal(trainStep{SK: run.SK, Index: index})
+	if err != nil {
+		return events.APIGatewayV2HTTPRequest{}, err
+	}
+
+	event := events.APIGatewayV2HTTPRequest{RawPath: trainStepPath, Body: string(body)}
+	event.RequestContext.RequestID = fmt.Sprintf("train-%s-%d", run.TrainID, index)
+	event.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+		JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+			Claims: map[string]string{"email": run.StartedBy},
+		},
+	}
+	return event, nil
+}
+
+// startTrainStep invokes the releases lambda asynchronously to run release index of the train, so
+// that every release of the train has the whole lambda timeout to itself
+func (app application) startTrainStep(run trainRun, index int) error {
+	event, err := trainStepRequest(run, index)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal release train step, %v", err))
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal release train step, %v", err))
+		return err
+	}
+
+	input := &lambda.InvokeInput{
+		FunctionName:   aws.String(app.Config.FunctionName),
+		InvocationType: aws.String(lambda.InvocationTypeEvent),
+		Payload:        payload,
+	}
+
+	_, err = app.AWS.Lambda.Invoke(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return err
+	}
+	return nil
+}
+
+// releasesTrainCreateHandler starts releasing several repositories in dependency order through the
+// release workflow. Every version is resolved before the train is started, so that a train with an
+// invalid release does not release anything. The releases then run one per invocation of
+// trainStepPath, and the train is returned while its first release is starting.
+func (app application) releasesTrainCreateHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	t := trainEvent{}
+	err := json.Unmarshal([]byte(event.Body), &t)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+	}
+
+	if app.Config.RequiredApprovals > 0 {
+		message := fmt.Sprintf("Unable to run release train %s, releases require %d approvals, request them through /releases/request", t.Name, app.Config.RequiredApprovals)
+		statusCode := 403
+		return message, statusCode
+	}
+
+	ordered, err := trainOrder(t.Releases)
+	if err != nil {
+		message := fmt.Sprintf("Unable to run release train %s, %v", t.Name, err)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	id := event.RequestContext.RequestID
+	if id == "" {
+		id = fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+
+	releases := []releaseEvent{}
+	results := []trainResult{}
+	for _, r := range ordered {
+		e := r.releaseEvent
+		if _, ok := app.Providers[e.RepoProvider]; !ok {
+			message := fmt.Sprintf("Unable to run release train %s, provider %s of %s is not supported", t.Name, e.RepoProvider, e.RepoName)
+			statusCode := 400
+			return message, statusCode
+		}
+
+		repo, err := app.AWS.getRepository(e)
+		if err == errRepositoryNotFound {
+			message := fmt.Sprintf("Unable to run release train %s, repository %s has not been onboarded for %s", t.Name, e.RepoName, e.RepoProvider)
+			statusCode := 404
+			return message, statusCode
+		} else if err == errComponentNotFound {
+			message := fmt.Sprintf("Unable to run release train %s, component %s of %s has not been onboarded", t.Name, e.Component, e.RepoName)
+			statusCode := 404
+			return message, statusCode
+		} else if err != nil {
+			message := fmt.Sprintf("Unable to run release train %s, could not read repository %s from backend", t.Name, e.RepoName)
+			statusCode := 400
+			return message, statusCode
+		}
+
+		version, err := resolveReleaseVersion(e, repo)
+		if err != nil {
+			message := fmt.Sprintf("Unable to run release train %s, %s %v", t.Name, e.RepoName, err)
+			statusCode := 400
+			return message, statusCode
+		}
+		e.ReleaseVersion = version
+		e.Bump = ""
+		e.IdempotencyKey = fmt.Sprintf("train#%s#%s", id, r.name())
+		releases = append(releases, e)
+		results = append(results, trainResult{RepoProvider: e.RepoProvider, RepoName: e.RepoName, Component: e.Component, ReleaseVersion: version, Outcome: "pending"})
+	}
+
+	started := time.Now().UTC().Format(historyTimeFormat)
+	run := trainRun{
+		PK:        "release_train",
+		SK:        fmt.Sprintf("%s#%s", started, id),
+		TrainID:   id,
+		Name:      t.Name,
+		StartedBy: requestActor(event),
+		StartedAt: started,
+		UpdatedAt: started,
+		Outcome:   "running",
+		Results:   results,
+		Releases:  releases,
+	}
+	err = app.AWS.putTrainRun(&run)
+	if err != nil {
+		message := fmt.Sprintf("Unable to run release train %s, could not write train to backend", t.Name)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	err = app.startTrainStep(run, 0)
+	if err != nil {
+		run.finish("failed", time.Now())
+		if err := app.AWS.putTrainRun(&run); err != nil {
+			log.Error(fmt.Sprintf("unable to record outcome of release train %v", id))
+		}
+		message := fmt.Sprintf("Unable to run release train %s, could not start its first release", t.Name)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	body, err := json.Marshal(run)
+	statusCode := 202
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal json for response, %v", err))
+		statusCode = 400
+	}
+
+	var buf bytes.Buffer
+	json.HTMLEscape(&buf, body)
+	return buf.String(), statusCode
+}
+
+// releasesTrainStepHandler runs one release of a train and records its outcome. The next release is
+// started once it succeeds, otherwise the train stops, and its summary is posted once it completes.
+// The release is only run by the invocation which marks it as running.
+func (app application) releasesTrainStepHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	// NOTE(SMT): steps are only invoked by the releases lambda, never through API Gateway
+	if event.RequestContext.APIID != "" {
+		message := "Unable to run release train step, steps are only run by the release train"
+		statusCode := 403
+		return message, statusCode
+	}
+
+	step := trainStep{}
+	err := json.Unmarshal([]byte(event.Body), &step)
+	if err != nil {
+		log.Error(fmt.Sprintf("%v", err))
+	}
+
+	run, err := app.AWS.getTrainRun(step.SK)
+	if err == errTrainNotFound {
+		message := fmt.Sprintf("Unable to run release train step, train %s does not exist", step.SK)
+		statusCode := 404
+		return message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to run release train step, could not read train %s from backend", step.SK)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	app.expireTrain(&run)
+
+	// NOTE(SMT): asynchronous invocations are retried, so a step which has already completed is not
+	// run again
+	if run.Outcome != "running" || step.Index < 0 || step.Index >= len(run.Releases) || run.Results[step.Index].Outcome != "pending" {
+		message := fmt.Sprintf("Unable to run release train step, release %d of train %s has already run", step.Index, run.TrainID)
+		statusCode := 409
+		return message, statusCode
+	}
+
+	// NOTE(SMT): asynchronous invocations can also be delivered more than once, so only the invocation
+	// whose write lands runs the release
+	run.Results[step.Index].Outcome = "running"
+	run.UpdatedAt = time.Now().UTC().Format(historyTimeFormat)
+	err = app.AWS.putTrainRun(&run)
+	if err == errTrainChanged {
+		message := fmt.Sprintf("Unable to run release train step, release %d of train %s is already running", step.Index, run.TrainID)
+		statusCode := 409
+		return message, statusCode
+	} else if err != nil {
+		message := fmt.Sprintf("Unable to run release train step, could not write train %s to backend", run.TrainID)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	e := run.Releases[step.Index]
+	e.Train = run.TrainID
+	log.Info(fmt.Sprintf("releasing %v version %v as part of train %v...", e.RepoName, e.ReleaseVersion, run.TrainID))
+	message, statusCode := app.release(event, e)
+	run.Results[step.Index].StatusCode = statusCode
+	run.Results[step.Index].Message = message
+	run.UpdatedAt = time.Now().UTC().Format(historyTimeFormat)
+
+	if statusCode != 200 {
+		run.Results[step.Index].Outcome = "failed"
+		run.finish("failed", time.Now())
+	} else {
+		run.Results[step.Index].Outcome = "succeeded"
+		if step.Index+1 == len(run.Releases) {
+			run.finish("succeeded", time.Now())
+		}
+	}
+
+	// NOTE(SMT): the outcome is recorded before the next release starts, as the next step reads the
+	// train from the backend. A train whose outcome cannot be recorded goes stale, and one which expired
+	// while the release ran keeps its failure.
+	err = app.AWS.putTrainRun(&run)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to record outcome of release train %v", run.TrainID))
+		return message, statusCode
+	}
+
+	if run.Outcome == "running" {
+		err = app.startTrainStep(run, step.Index+1)
+		if err != nil {
+			run.Results[step.Index+1].Outcome = "failed"
+			run.Results[step.Index+1].Message = "could not start release"
+			run.finish("failed", time.Now())
+			if err := app.AWS.putTrainRun(&run); err != nil {
+				log.Error(fmt.Sprintf("unable to record outcome of release train %v", run.TrainID))
+			}
+		}
+	}
+
+	if run.Outcome != "running" {
+		app.notify(trainSummary(run))
+	}
+	return message, statusCode
+}
+
+// releasesTrainListHandler lists the most recent release trains. Running trains which have gone stale
+// are marked as failed as they are listed.
+func (app application) releasesTrainListHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	runs, err := app.AWS.listTrainRuns(defaultListLimit)
+	if err != nil {
+		message := "Failed to query release trains"
+		statusCode := 400
+		return message, statusCode
+	}
+
+	for i := range runs {
+		app.expireTrain(&runs[i])
+	}
+
+	body, err := json.Marshal(runs)
+	statusCode := 200
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal json for response, %v", err))
+		statusCode = 400
+	}
+
+	var buf bytes.Buffer
+	json.HTMLEscape(&buf, body)
+	return buf.String(), statusCode
+}
+
+// releasesTrainExpireHandler fails every running train which has gone stale, so that a train whose
+// release timed out is failed and summarised without waiting for it to be listed
+func (app application) releasesTrainExpireHandler(event events.APIGatewayV2HTTPRequest) (string, int) {
+	// NOTE(SMT): expiry is only invoked by the scheduler lambda, never through API Gateway
+	if event.RequestContext.APIID != "" {
+		message := "Unable to expire release trains, trains are only expired by the scheduler"
+		statusCode := 403
+		return message, statusCode
+	}
+
+	runs, err := app.AWS.listRunningTrains()
+	if err != nil {
+		message := "Failed to query running release trains"
+		statusCode := 400
+		return message, statusCode
+	}
+
+	for i := range runs {
+		app.expireTrain(&runs[i])
+	}
+
+	message := fmt.Sprintf("Checked %d running release trains.", len(runs))
+	statusCode := 200
+	return message, statusCode
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/seanturner026/moot/internal/util"
+)
+
+func TestTrainOrder(t *testing.T) {
+	release := func(name string, dependsOn ...string) trainRelease {
+		return trainRelease{releaseEvent: releaseEvent{RepoName: name}, DependsOn: dependsOn}
+	}
+
+	tests := map[string]struct {
+		Releases []trainRelease
+		Want     string
+		Error    bool
+	}{
+		"no dependencies":        {Releases: []trainRelease{release("a"), release("b")}, Want: "a,b"},
+		"dependency sent later":  {Releases: []trainRelease{release("frontend", "backend", "library"), release("backend", "library"), release("library")}, Want: "library,backend,frontend"},
+		"independent keep order": {Releases: []trainRelease{release("b", "lib"), release("a"), release("lib")}, Want: "a,lib,b"},
+		"cycle":                  {Releases: []trainRelease{release("a", "b"), release("b", "a")}, Error: true},
+		"unknown dependency":     {Releases: []trainRelease{release("a", "c")}, Error: true},
+		"duplicate repository":   {Releases: []trainRelease{release("a"), release("a")}, Error: true},
+		"empty train":            {Error: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ordered, err := trainOrder(test.Releases)
+			names := []string{}
+			for _, r := range ordered {
+				names = append(names, r.RepoName)
+			}
+			if (err != nil) != test.Error || strings.Join(names, ",") != test.Want {
+				t.Fatalf("expected %v (error %v), got %v (%v)", test.Want, test.Error, names, err)
+			}
+		})
+	}
+}
+
+// trainRuns returns the release trains written to the mock table
+func trainRuns(items map[string]map[string]*dynamodb.AttributeValue) []trainRun {
+	runs := []trainRun{}
+	for key, item := range items {
+		if !strings.HasPrefix(key, "release_train|") {
+			continue
+		}
+		run := trainRun{}
+		_ = dynamodbattribute.UnmarshalMap(item, &run)
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// mockLambda records the asynchronous invocations of the releases lambda
+type mockLambda struct {
+	lambdaiface.LambdaAPI
+	Inputs *[]*lambda.InvokeInput
+	Error  error
+}
+
+func (m mockLambda) Invoke(input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	*m.Inputs = append(*m.Inputs, input)
+	return &lambda.InvokeOutput{}, m.Error
+}
+
+// newTrainApplication returns an application which records the steps of release trains in invokes
+func newTrainApplication(provider *fakeProvider, invokes *[]*lambda.InvokeInput) (application, map[string]map[string]*dynamodb.AttributeValue) {
+	app, _, items := newRepositoryApplication(provider, repository{CurrentVersion: "1.2.0"})
+	app.AWS.Lambda = mockLambda{Inputs: invokes}
+	app.Config.FunctionName = "moot_releases"
+	return app, items
+}
+
+// runTrainSteps handles every step invoked by the train until no step is left
+func runTrainSteps(t *testing.T, app application, invokes *[]*lambda.InvokeInput) {
+	t.Helper()
+	for len(*invokes) > 0 {
+		input := (*invokes)[0]
+		*invokes = (*invokes)[1:]
+		if aws.StringValue(input.FunctionName) != "moot_releases" || aws.StringValue(input.InvocationType) != lambda.InvocationTypeEvent {
+			t.Fatalf("Step should have been invoked asynchronously on the releases lambda, got %v", input)
+		}
+
+		event := events.APIGatewayV2HTTPRequest{}
+		_ = json.Unmarshal(input.Payload, &event)
+		_, _ = app.handler(event)
+	}
+}
+
+func TestReleaseTrain(t *testing.T) {
+	newTrainRequest := func(backendProvider, frontendVersion string) events.APIGatewayV2HTTPRequest {
+		return userRequest("/releases/train/create", `{"name": "checkout", "releases": [
+				{"repo_name": "frontend", "repo_provider": "fake", "release_version": "`+frontendVersion+`", "depends_on": ["backend", "library"]},
+				{"repo_name": "backend", "repo_provider": "`+backendProvider+`", "release_version": "1.4.0", "depends_on": ["library"]},
+				{"repo_name": "library", "repo_provider": "fake", "release_version": "1.3.0"}]}`, "jane@example.com")
+	}
+
+	t.Run("Successfully released every repository in dependency order", func(t *testing.T) {
+		provider := &fakeProvider{}
+		invokes := &[]*lambda.InvokeInput{}
+		app, items := newTrainApplication(provider, invokes)
+
+		resp, _ := app.handler(newTrainRequest("fake", "1.5.0"))
+		if resp.StatusCode != 202 {
+			t.Fatalf("Train should have started, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if len(provider.Releases) != 0 || len(*invokes) != 1 {
+			t.Fatalf("Only the first step should have been started, got %v releases and %v steps", provider.Releases, len(*invokes))
+		}
+
+		body := util.ResponseBody{}
+		_ = json.Unmarshal([]byte(resp.Body), &body)
+		run := trainRun{}
+		_ = json.Unmarshal([]byte(body.Message), &run)
+		if run.Outcome != "running" || len(run.Results) != 3 || run.Results[2].RepoName != "frontend" || run.Results[2].Outcome != "pending" {
+			t.Fatalf("Response should have described every release, got %+v", run)
+		}
+
+		runTrainSteps(t, app, invokes)
+		if strings.Join(provider.Releases, ",") != "1.3.0,1.4.0,1.5.0" {
+			t.Fatalf("Library, backend then frontend should have been released, got %v", provider.Releases)
+		}
+		runs := trainRuns(items)
+		if len(runs) != 1 || runs[0].Outcome != "succeeded" || runs[0].CompletedAt == "" || runs[0].Results[2].Outcome != "succeeded" {
+			t.Fatalf("Train should have been recorded, got %+v", runs)
+		}
+		for _, record := range historyRecords(items) {
+			if record.TrainID != run.TrainID || record.Actor != "jane@example.com" {
+				t.Fatalf("Release history should have referenced the train, got %+v", record)
+			}
+		}
+	})
+
+	t.Run("Train stops at the first failed release", func(t *testing.T) {
+		provider := &fakeProvider{}
+		broken := &fakeProvider{Errors: map[string]error{"createPullRequest": errors.New("boom")}}
+		invokes := &[]*lambda.InvokeInput{}
+		app, items := newTrainApplication(provider, invokes)
+		app.Providers["broken"] = func(e releaseEvent, repo repository, token string) (releaseProvider, error) {
+			return broken, nil
+		}
+
+		resp, _ := app.handler(newTrainRequest("broken", "1.5.0"))
+		if resp.StatusCode != 202 {
+			t.Fatalf("Train should have started, got %v %v", resp.StatusCode, resp.Body)
+		}
+		runTrainSteps(t, app, invokes)
+		if strings.Join(provider.Releases, ",") != "1.3.0" || len(broken.Releases) != 0 {
+			t.Fatalf("Only the library should have been released, got %v", provider.Releases)
+		}
+
+		runs := trainRuns(items)
+		if len(runs) != 1 || runs[0].Outcome != "failed" {
+			t.Fatalf("Failed train should have been recorded, got %+v", runs)
+		}
+		outcomes := []string{}
+		for _, r := range runs[0].Results {
+			outcomes = append(outcomes, r.RepoName+":"+r.Outcome)
+		}
+		if strings.Join(outcomes, ",") != "library:succeeded,backend:failed,frontend:skipped" {
+			t.Fatalf("Results should have described where the train stopped, got %v", outcomes)
+		}
+	})
+
+	t.Run("Completed step is not run again", func(t *testing.T) {
+		provider := &fakeProvider{}
+		invokes := &[]*lambda.InvokeInput{}
+		app, _ := newTrainApplication(provider, invokes)
+
+		_, _ = app.handler(newTrainRequest("fake", "1.5.0"))
+		first := (*invokes)[0]
+		runTrainSteps(t, app, invokes)
+
+		event := events.APIGatewayV2HTTPRequest{}
+		_ = json.Unmarshal(first.Payload, &event)
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 409 || len(provider.Releases) != 3 {
+			t.Fatalf("Retried step should have been refused, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Step delivered again while its release runs is refused", func(t *testing.T) {
+		provider := &fakeProvider{}
+		invokes := &[]*lambda.InvokeInput{}
+		app, _ := newTrainApplication(provider, invokes)
+
+		_, _ = app.handler(newTrainRequest("fake", "1.5.0"))
+		event := events.APIGatewayV2HTTPRequest{}
+		_ = json.Unmarshal((*invokes)[0].Payload, &event)
+
+		duplicates := []int{}
+		app.Providers["fake"] = func(e releaseEvent, repo repository, token string) (releaseProvider, error) {
+			if len(duplicates) == 0 {
+				resp, _ := app.handler(event)
+				duplicates = append(duplicates, resp.StatusCode)
+			}
+			return provider, nil
+		}
+		runTrainSteps(t, app, invokes)
+		if len(duplicates) != 1 || duplicates[0] != 409 || len(provider.Releases) != 3 {
+			t.Fatalf("Duplicate step should have been refused, got %v and releases %v", duplicates, provider.Releases)
+		}
+	})
+
+	t.Run("Step sent through API Gateway is refused", func(t *testing.T) {
+		provider := &fakeProvider{}
+		invokes := &[]*lambda.InvokeInput{}
+		app, _ := newTrainApplication(provider, invokes)
+
+		_, _ = app.handler(newTrainRequest("fake", "1.5.0"))
+		event := events.APIGatewayV2HTTPRequest{}
+		_ = json.Unmarshal((*invokes)[0].Payload, &event)
+		event.RequestContext.APIID = "api"
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 403 || len(provider.Releases) != 0 {
+			t.Fatalf("Step should have been refused, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Invalid version fails the train before anything is released", func(t *testing.T) {
+		provider := &fakeProvider{}
+		invokes := &[]*lambda.InvokeInput{}
+		app, items := newTrainApplication(provider, invokes)
+
+		resp, _ := app.handler(newTrainRequest("fake", "1.0.0"))
+		if resp.StatusCode != 400 || len(*invokes) != 0 || len(trainRuns(items)) != 0 {
+			t.Fatalf("Train should have been rejected, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+
+	t.Run("Train which cannot start its first release fails", func(t *testing.T) {
+		provider := &fakeProvider{}
+		invokes := &[]*lambda.InvokeInput{}
+		app, items := newTrainApplication(provider, invokes)
+		app.AWS.Lambda = mockLambda{Inputs: invokes, Error: errors.New("boom")}
+
+		resp, _ := app.handler(newTrainRequest("fake", "1.5.0"))
+		runs := trainRuns(items)
+		if resp.StatusCode != 400 || len(runs) != 1 || runs[0].Outcome != "failed" || runs[0].Results[0].Outcome != "skipped" {
+			t.Fatalf("Train should have failed, got %v %v %+v", resp.StatusCode, resp.Body, runs)
+		}
+	})
+}
+
+func TestPutTrainRun(t *testing.T) {
+	t.Run("Write of a train which changed since it was read fails", func(t *testing.T) {
+		invokes := &[]*lambda.InvokeInput{}
+		app, items := newTrainApplication(&fakeProvider{}, invokes)
+		run := trainRun{PK: "release_train", SK: "1", TrainID: "1", Outcome: "running"}
+		if err := app.AWS.putTrainRun(&run); err != nil || run.Revision != 1 {
+			t.Fatalf("Train should have been written at revision 1, got %v %v", run.Revision, err)
+		}
+
+		first, second := trainRuns(items)[0], trainRuns(items)[0]
+		first.Outcome = "failed"
+		if err := app.AWS.putTrainRun(&first); err != nil {
+			t.Fatalf("First write should have succeeded, got %v", err)
+		}
+		second.Outcome = "succeeded"
+		if err := app.AWS.putTrainRun(&second); err != errTrainChanged {
+			t.Fatalf("Second write should have failed with errTrainChanged, got %v", err)
+		}
+		if runs := trainRuns(items); runs[0].Outcome != "failed" || runs[0].Revision != 2 {
+			t.Fatalf("First write should have been kept, got %+v", runs[0])
+		}
+	})
+}
+
+func TestTrainRunExpire(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	newRun := func(outcome string, updated time.Time) trainRun {
+		return trainRun{
+			UpdatedAt: updated.Format(historyTimeFormat),
+			Outcome:   outcome,
+			Results:   []trainResult{{Outcome: "succeeded"}, {Outcome: "running"}, {Outcome: "pending"}},
+		}
+	}
+
+	t.Run("Successfully failed a stale train", func(t *testing.T) {
+		run := newRun("running", now.Add(-trainStaleAfter))
+		if !run.expire(now) {
+			t.Fatal("Stale train should have expired")
+		}
+		outcomes := []string{}
+		for _, r := range run.Results {
+			outcomes = append(outcomes, r.Outcome)
+		}
+		if run.Outcome != "failed" || run.CompletedAt == "" || strings.Join(outcomes, ",") != "succeeded,failed,skipped" {
+			t.Fatalf("Stale train should have failed at its running release, got %+v", run)
+		}
+	})
+
+	t.Run("Recently updated train keeps running", func(t *testing.T) {
+		run := newRun("running", now.Add(-time.Minute))
+		if run.expire(now) || run.Outcome != "running" {
+			t.Fatalf("Train should have kept running, got %+v", run)
+		}
+	})
+
+	t.Run("Completed train is not expired", func(t *testing.T) {
+		run := newRun("succeeded", now.Add(-time.Hour))
+		if run.expire(now) || run.Outcome != "succeeded" {
+			t.Fatalf("Train should have kept its outcome, got %+v", run)
+		}
+	})
+}
+
+// putStaleTrain writes a train to items whose release has been running for an hour
+func putStaleTrain(items map[string]map[string]*dynamodb.AttributeValue) {
+	stale := time.Now().Add(-time.Hour).UTC().Format(historyTimeFormat)
+	item, _ := dynamodbattribute.MarshalMap(trainRun{
+		PK:        "release_train",
+		SK:        stale + "#1",
+		TrainID:   "1",
+		StartedAt: stale,
+		UpdatedAt: stale,
+		Outcome:   "running",
+		Results:   []trainResult{{RepoName: "library", Outcome: "running"}},
+		Revision:  2,
+	})
+	items[mockItemKey(item)] = item
+}
+
+func TestReleaseTrainList(t *testing.T) {
+	t.Run("Successfully failed stale trains as they are listed", func(t *testing.T) {
+		invokes := &[]*lambda.InvokeInput{}
+		app, items := newTrainApplication(&fakeProvider{}, invokes)
+		putStaleTrain(items)
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{RawPath: "/releases/train/list"})
+		body := util.ResponseBody{}
+		_ = json.Unmarshal([]byte(resp.Body), &body)
+		runs := []trainRun{}
+		_ = json.Unmarshal([]byte(body.Message), &runs)
+		if resp.StatusCode != 200 || len(runs) != 1 || runs[0].Outcome != "failed" {
+			t.Fatalf("Stale train should have been listed as failed, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if recorded := trainRuns(items); recorded[0].Outcome != "failed" || recorded[0].Results[0].Outcome != "failed" {
+			t.Fatalf("Stale train should have been recorded as failed, got %+v", recorded)
+		}
+	})
+}
+
+func TestReleaseTrainExpire(t *testing.T) {
+	t.Run("Successfully failed stale trains when the scheduler runs", func(t *testing.T) {
+		invokes := &[]*lambda.InvokeInput{}
+		app, items := newTrainApplication(&fakeProvider{}, invokes)
+		putStaleTrain(items)
+
+		resp, _ := app.handler(events.APIGatewayV2HTTPRequest{RawPath: trainExpirePath})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Expiry should have succeeded, got %v %v", resp.StatusCode, resp.Body)
+		}
+		if recorded := trainRuns(items); recorded[0].Outcome != "failed" || recorded[0].Results[0].Outcome != "failed" {
+			t.Fatalf("Stale train should have been recorded as failed, got %+v", recorded)
+		}
+	})
+
+	t.Run("Expiry sent through API Gateway is refused", func(t *testing.T) {
+		invokes := &[]*lambda.InvokeInput{}
+		app, items := newTrainApplication(&fakeProvider{}, invokes)
+		putStaleTrain(items)
+
+		event := events.APIGatewayV2HTTPRequest{RawPath: trainExpirePath}
+		event.RequestContext.APIID = "api"
+		resp, _ := app.handler(event)
+		if resp.StatusCode != 403 || trainRuns(items)[0].Outcome != "running" {
+			t.Fatalf("Expiry should have been refused, got %v %v", resp.StatusCode, resp.Body)
+		}
+	})
+}
@@ -3,48 +3,109 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
-	"github.com/aws/aws-sdk-go/service/ssm"
-	"github.com/google/go-github/github"
+	"github.com/seanturner026/moot/internal/githubapp"
+	"github.com/seanturner026/moot/internal/util"
 	log "github.com/sirupsen/logrus"
 	"github.com/xanzy/go-gitlab"
-	"golang.org/x/oauth2"
 )
 
 type createRepoEvent struct {
-	PK              string `dynamodbav:"PK"`
-	RepoProvider    string `dynamodbav:"SK"                        json:"repo_provider"`
-	RepoName        string `dynamodbav:"-"                         json:"repo_name"`
-	RepoOwner       string `dynamodbav:"RepoOwner"                 json:"repo_owner"`
-	BranchBase      string `dynamodbav:"BranchBase"                json:"branch_base"`
-	BranchHead      string `dynamodbav:"BranchHead"                json:"branch_head"`
-	GitlabProjectID string `dynamodbav:"GitlabProjectID,omitempty" json:"gitlab_repo_id,omitempty"`
+	PK                string `dynamodbav:"PK"`
+	RepoProvider      string `dynamodbav:"SK"                          json:"repo_provider"`
+	RepoName          string `dynamodbav:"-"                           json:"repo_name"`
+	RepoOwner         string `dynamodbav:"RepoOwner"                   json:"repo_owner"`
+	BranchBase        string `dynamodbav:"BranchBase"                  json:"branch_base"`
+	BranchHead        string `dynamodbav:"BranchHead"                  json:"branch_head"`
+	GitlabProjectID   string `dynamodbav:"GitlabProjectID,omitempty"   json:"gitlab_repo_id,omitempty"`
+	BaseURL           string `dynamodbav:"BaseURL,omitempty"           json:"base_url,omitempty"`
+	AzureOrganization string `dynamodbav:"AzureOrganization,omitempty" json:"azure_organization,omitempty"`
+	AzureProject      string `dynamodbav:"AzureProject,omitempty"      json:"azure_project,omitempty"`
+	TagPrefix         string `dynamodbav:"TagPrefix,omitempty"         json:"tag_prefix,omitempty"`
+	AutoBackMerge     bool   `dynamodbav:"AutoBackMerge,omitempty"     json:"auto_back_merge,omitempty"`
+	RollbackStrategy  string `dynamodbav:"RollbackStrategy,omitempty"  json:"rollback_strategy,omitempty"`
+
+	MergeMethod         string `dynamodbav:"MergeMethod,omitempty"         json:"merge_method,omitempty"`
+	MergeCommitTemplate string `dynamodbav:"MergeCommitTemplate,omitempty" json:"merge_commit_template,omitempty"`
+	DeleteSourceBranch  *bool  `dynamodbav:"DeleteSourceBranch,omitempty"  json:"delete_source_branch,omitempty"`
+
+	Components map[string]component `dynamodbav:"Components,omitempty" json:"components,omitempty"`
 }
 
-func (app application) getProviderToken(e createRepoEvent) (string, error) {
-	input := &ssm.GetParameterInput{
-		Name:           aws.String(fmt.Sprintf("/%s/%s_token", app.Config.DashboardName, e.RepoProvider)),
-		WithDecryption: aws.Bool(true),
+// validateMergeSettings returns an error when the merge method is not one which both Github and
+// Gitlab support, or the merge commit template does not render
+func validateMergeSettings(e createRepoEvent) error {
+	switch e.MergeMethod {
+	case "", "merge", "squash", "rebase":
+	default:
+		return fmt.Errorf("merge_method must be one of merge, squash or rebase, got %v", e.MergeMethod)
 	}
 
-	resp, err := app.AWS.SSM.GetParameter(input)
+	_, err := util.RenderMergeCommitMessage(e.MergeCommitTemplate, util.MergeCommit{})
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			log.Error(fmt.Sprintf("%v", aerr.Error()))
-		} else {
-			log.Error(fmt.Sprintf("%v", err.Error()))
+		return fmt.Errorf("merge_commit_template is invalid, %v", err)
+	}
+	return nil
+}
+
+// validateRollbackStrategy returns an error unless the repository rolls back by releasing the previous
+// version's commit, or by reverting the last merge
+func validateRollbackStrategy(e createRepoEvent) error {
+	switch e.RollbackStrategy {
+	case "", "release", "revert":
+		return nil
+	}
+	return fmt.Errorf("rollback_strategy must be one of release or revert, got %v", e.RollbackStrategy)
+}
+
+// validateComponents returns an error unless every component of a monorepo has a path and a tag
+// prefix of its own, so that the tags of one component are never read as versions of another.
+// Components are only supported on Github and Gitlab, which can list the commits touching a path.
+func validateComponents(e createRepoEvent) error {
+	if len(e.Components) > 0 && e.RepoProvider != "github" && e.RepoProvider != "gitlab" {
+		return fmt.Errorf("components are only supported on github and gitlab, got %v", e.RepoProvider)
+	}
+
+	prefixes := map[string]string{}
+	for name, c := range e.Components {
+		if strings.TrimSpace(name) == "" {
+			return errors.New("component names must not be empty")
+		}
+		if c.Path == "" || strings.HasPrefix(c.Path, "/") {
+			return fmt.Errorf("component %v path must be relative to the root of the repository", name)
+		}
+		if c.TagPrefix == "" || c.TagPrefix == e.TagPrefix {
+			return fmt.Errorf("component %v needs a tag_prefix which differs from the repository's", name)
+		}
+		if other, ok := prefixes[c.TagPrefix]; ok {
+			return fmt.Errorf("components %v and %v have the same tag_prefix %v", other, name, c.TagPrefix)
+		}
+		prefixes[c.TagPrefix] = name
+	}
+	return nil
+}
+
+// getProviderToken returns a Github App installation token when a Github App has been configured,
+// otherwise the provider's personal access token
+func (app application) getProviderToken(e createRepoEvent) (string, error) {
+	if e.RepoProvider == "github" {
+		creds, err := githubapp.GetCredentials(app.AWS.SSM, app.Config.DashboardName, e.BaseURL)
+		if err == nil {
+			return app.GithubApps.Token(context.Background(), creds, e.BaseURL, e.RepoOwner, e.RepoName)
+		} else if err != githubapp.ErrNotConfigured {
+			return "", err
 		}
-		return "", err
 	}
 
-	token := *resp.Parameter.Value
-	return token, nil
+	return util.GetProviderToken(app.AWS.SSM, app.Config.DashboardName, e.RepoProvider, e.BaseURL)
 }
 
 func (app githubController) confirmTokenAccess(e createRepoEvent) error {
@@ -55,6 +116,30 @@ func (app githubController) confirmTokenAccess(e createRepoEvent) error {
 	return nil
 }
 
+func (app azureDevOpsController) confirmTokenAccess(e createRepoEvent) error {
+	_, err := app.Client.GetRepository(e.RepoName)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (app bitbucketController) confirmTokenAccess(e createRepoEvent) error {
+	_, err := app.Client.GetRepository(e.RepoOwner, e.RepoName)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (app giteaController) confirmTokenAccess(e createRepoEvent) error {
+	_, err := app.Client.GetRepository(e.RepoOwner, e.RepoName)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func (app gitlabController) confirmTokenAccess(e createRepoEvent) error {
 	_, _, err := app.Client.Projects.GetProject(e.GitlabProjectID, &gitlab.GetProjectOptions{})
 	if err != nil {
@@ -98,6 +183,20 @@ func (app application) repositoriesCreateHandler(event events.APIGatewayV2HTTPRe
 		log.Error(fmt.Sprintf("%v", err))
 	}
 	e.PK = "repo"
+
+	err = validateMergeSettings(e)
+	if err == nil {
+		err = validateRollbackStrategy(e)
+	}
+	if err == nil {
+		err = validateComponents(e)
+	}
+	if err != nil {
+		message := fmt.Sprintf("Unable to onboard %s, %v", e.RepoName, err)
+		statusCode := 400
+		return message, statusCode
+	}
+
 	token, err := app.getProviderToken(e)
 	if err != nil {
 		message := fmt.Sprintf("Unable to onboard %s, please double check that a token has been provided for %s", e.RepoName, e.RepoProvider)
@@ -105,35 +204,26 @@ func (app application) repositoriesCreateHandler(event events.APIGatewayV2HTTPRe
 		return message, statusCode
 	}
 
-	if e.RepoProvider == "github" {
-		githubCtx := context.Background()
-		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
-		tc := oauth2.NewClient(githubCtx, ts)
+	newProvider, ok := app.Providers[e.RepoProvider]
+	if !ok {
+		log.Error(fmt.Sprintf("provider %s is not supported", e.RepoProvider))
+		message := fmt.Sprintf("Unable to onboard %s, provider %s is not supported", e.RepoName, e.RepoProvider)
+		statusCode := 400
+		return message, statusCode
+	}
 
-		app.GH = githubController{
-			Client:    github.NewClient(tc),
-			GithubCtx: githubCtx,
-		}
-		err = app.GH.confirmTokenAccess(e)
-		if err != nil {
-			message := fmt.Sprintf("Provided %s token is unable to access repository %s", e.RepoProvider, e.RepoName)
-			statusCode := 401
-			return message, statusCode
-		}
-	} else if e.RepoProvider == "gitlab" {
-		clientGitlab, err := gitlab.NewClient(token)
-		if err != nil {
-			log.Fatalf("Failed to create client: %v", err)
-		}
-		app.GL = gitlabController{
-			Client: clientGitlab,
-		}
-		err = app.GL.confirmTokenAccess(e)
-		if err != nil {
-			message := fmt.Sprintf("Provided %s token is unable to access repository %s", e.RepoProvider, e.RepoName)
-			statusCode := 401
-			return message, statusCode
-		}
+	provider, err := newProvider(e, token)
+	if err != nil {
+		message := fmt.Sprintf("Unable to onboard %s, could not create %s client, %v", e.RepoName, e.RepoProvider, err)
+		statusCode := 400
+		return message, statusCode
+	}
+
+	err = provider.confirmTokenAccess(e)
+	if err != nil {
+		message := fmt.Sprintf("Provided %s token is unable to access repository %s", e.RepoProvider, e.RepoName)
+		statusCode := 401
+		return message, statusCode
 	}
 
 	itemInput, err := generatePutItemInputExpression(e)
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/seanturner026/moot/internal/bitbucket"
 )
 
 type mockPutItem struct {
@@ -60,3 +63,184 @@ func TestWriteRepoToDB(t *testing.T) {
 		}
 	})
 }
+
+func TestBitbucketConfirmTokenAccess(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repositories/owner/repo" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"type": "error", "error": {"message": "Repository not found"}}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"full_name": "owner/repo"}`))
+	}))
+	defer server.Close()
+
+	client := bitbucket.NewClient("token")
+	client.BaseURL = server.URL
+	app := bitbucketController{Client: client}
+
+	t.Run("Token is able to access repository", func(t *testing.T) {
+		err := app.confirmTokenAccess(createRepoEvent{RepoOwner: "owner", RepoName: "repo"})
+		if err != nil {
+			t.Fatalf("Token should have been able to access repository, %v", err)
+		}
+	})
+
+	t.Run("Token is unable to access repository", func(t *testing.T) {
+		err := app.confirmTokenAccess(createRepoEvent{RepoOwner: "owner", RepoName: "missing"})
+		if err == nil {
+			t.Fatal("Token should not have been able to access repository")
+		}
+	})
+}
+
+func TestGiteaConfirmTokenAccess(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/repos/owner/repo" || r.Header.Get("Authorization") != "token token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"full_name": "owner/repo"}`))
+	}))
+	defer server.Close()
+
+	t.Run("Token is able to access repository", func(t *testing.T) {
+		e := createRepoEvent{RepoOwner: "owner", RepoName: "repo", BaseURL: server.URL}
+		app, err := newGiteaController(e, "token")
+		if err != nil {
+			t.Fatalf("Provider should have been created, %v", err)
+		}
+
+		err = app.confirmTokenAccess(e)
+		if err != nil {
+			t.Fatalf("Token should have been able to access repository, %v", err)
+		}
+	})
+
+	t.Run("Base url is required", func(t *testing.T) {
+		_, err := newGiteaController(createRepoEvent{RepoOwner: "owner", RepoName: "repo"}, "token")
+		if err == nil {
+			t.Fatal("Provider should not have been created without a base url")
+		}
+	})
+}
+
+func TestAzureDevOpsConfirmTokenAccess(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/org/project/_apis/git/repositories/repo" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"id": "1", "name": "repo"}`))
+	}))
+	defer server.Close()
+
+	t.Run("Token is able to access repository", func(t *testing.T) {
+		e := createRepoEvent{
+			RepoName:          "repo",
+			BaseURL:           server.URL,
+			AzureOrganization: "org",
+			AzureProject:      "project",
+		}
+		app, err := newAzureDevOpsController(e, "token")
+		if err != nil {
+			t.Fatalf("Provider should have been created, %v", err)
+		}
+
+		err = app.confirmTokenAccess(e)
+		if err != nil {
+			t.Fatalf("Token should have been able to access repository, %v", err)
+		}
+	})
+
+	t.Run("Organization and project are required", func(t *testing.T) {
+		_, err := newAzureDevOpsController(createRepoEvent{RepoName: "repo"}, "token")
+		if err == nil {
+			t.Fatal("Provider should not have been created without an organization and project")
+		}
+	})
+}
+
+func TestValidateMergeSettings(t *testing.T) {
+	t.Run("Successfully validated merge settings", func(t *testing.T) {
+		e := createRepoEvent{MergeMethod: "squash", MergeCommitTemplate: "Release {{.Version}} (#{{.Number}})"}
+		err := validateMergeSettings(e)
+		if err != nil {
+			t.Fatalf("Merge settings should have been valid, %v", err)
+		}
+	})
+
+	t.Run("Unsupported merge method is rejected", func(t *testing.T) {
+		err := validateMergeSettings(createRepoEvent{MergeMethod: "fast-forward"})
+		if err == nil {
+			t.Fatal("Merge method should have been rejected")
+		}
+	})
+
+	t.Run("Template with an unknown field is rejected", func(t *testing.T) {
+		err := validateMergeSettings(createRepoEvent{MergeCommitTemplate: "Release {{.Tag}}"})
+		if err == nil {
+			t.Fatal("Merge commit template should have been rejected")
+		}
+	})
+}
+
+func TestValidateRollbackStrategy(t *testing.T) {
+	t.Run("Successfully validated rollback strategy", func(t *testing.T) {
+		err := validateRollbackStrategy(createRepoEvent{RollbackStrategy: "revert"})
+		if err != nil {
+			t.Fatalf("Rollback strategy should have been valid, %v", err)
+		}
+	})
+
+	t.Run("Unsupported rollback strategy is rejected", func(t *testing.T) {
+		err := validateRollbackStrategy(createRepoEvent{RollbackStrategy: "reset"})
+		if err == nil {
+			t.Fatal("Rollback strategy should have been rejected")
+		}
+	})
+}
+
+func TestValidateComponents(t *testing.T) {
+	t.Run("Successfully validated components", func(t *testing.T) {
+		err := validateComponents(createRepoEvent{RepoProvider: "github", Components: map[string]component{
+			"billing":  {Path: "services/billing", TagPrefix: "billing/v"},
+			"payments": {Path: "services/payments", TagPrefix: "payments/v"},
+		}})
+		if err != nil {
+			t.Fatalf("Components should have been valid, %v", err)
+		}
+	})
+
+	t.Run("Components sharing a tag prefix are rejected", func(t *testing.T) {
+		err := validateComponents(createRepoEvent{RepoProvider: "github", Components: map[string]component{
+			"billing":  {Path: "services/billing", TagPrefix: "v"},
+			"payments": {Path: "services/payments", TagPrefix: "v"},
+		}})
+		if err == nil {
+			t.Fatal("Components should have been rejected")
+		}
+	})
+
+	t.Run("Components on a provider which cannot filter commits by path are rejected", func(t *testing.T) {
+		err := validateComponents(createRepoEvent{RepoProvider: "bitbucket", Components: map[string]component{
+			"billing": {Path: "services/billing", TagPrefix: "billing/v"},
+		}})
+		if err == nil {
+			t.Fatal("Components should have been rejected")
+		}
+	})
+
+	t.Run("Component without a path is rejected", func(t *testing.T) {
+		err := validateComponents(createRepoEvent{RepoProvider: "github", Components: map[string]component{
+			"billing": {TagPrefix: "billing/v"},
+		}})
+		if err == nil {
+			t.Fatal("Component should have been rejected")
+		}
+	})
+}
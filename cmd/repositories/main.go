@@ -13,16 +13,20 @@ import (
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"github.com/google/go-github/github"
+	"github.com/seanturner026/moot/internal/azuredevops"
+	"github.com/seanturner026/moot/internal/bitbucket"
+	"github.com/seanturner026/moot/internal/gitea"
+	"github.com/seanturner026/moot/internal/githubapp"
 	util "github.com/seanturner026/moot/internal/util"
 	log "github.com/sirupsen/logrus"
 	"github.com/xanzy/go-gitlab"
 )
 
 type application struct {
-	AWS    awsController
-	GH     githubController
-	GL     gitlabController
-	Config configuration
+	AWS        awsController
+	Providers  map[string]providerFactory
+	GithubApps *githubapp.Installations
+	Config     configuration
 }
 
 type awsController struct {
@@ -31,6 +35,18 @@ type awsController struct {
 	SSM       ssmiface.SSMAPI
 }
 
+type azureDevOpsController struct {
+	Client *azuredevops.Client
+}
+
+type bitbucketController struct {
+	Client *bitbucket.Client
+}
+
+type giteaController struct {
+	Client *gitea.Client
+}
+
 type githubController struct {
 	Client    *github.Client
 	GithubCtx context.Context
@@ -47,13 +63,34 @@ type configuration struct {
 }
 
 type repository struct {
-	RepoName        string `json:"repo_name,omitempty"`
-	RepoProvider    string `json:"repo_provider,omitempty"   dynamodbav:"SK"`
-	RepoOwner       string `json:"repo_owner,omitempty"      dynamodbav:"RepoOwner"`
-	BranchBase      string `json:"branch_base,omitempty"     dynamodbav:"BranchBase"`
-	BranchHead      string `json:"branch_head,omitempty"     dynamodbav:"BranchHead"`
-	CurrentVersion  string `json:"current_version,omitempty" dynamodbav:"CurrentVersion"`
-	GitlabProjectID string `json:"gitlab_repo_id,omitempty"  dynamodbav:"GitlabProjectID,omitempty"`
+	RepoName          string `json:"repo_name,omitempty"`
+	RepoProvider      string `json:"repo_provider,omitempty"      dynamodbav:"SK"`
+	RepoOwner         string `json:"repo_owner,omitempty"         dynamodbav:"RepoOwner"`
+	BranchBase        string `json:"branch_base,omitempty"        dynamodbav:"BranchBase"`
+	BranchHead        string `json:"branch_head,omitempty"        dynamodbav:"BranchHead"`
+	CurrentVersion    string `json:"current_version,omitempty"    dynamodbav:"CurrentVersion"`
+	CurrentPrerelease string `json:"current_prerelease,omitempty" dynamodbav:"CurrentPrerelease,omitempty"`
+	GitlabProjectID   string `json:"gitlab_repo_id,omitempty"     dynamodbav:"GitlabProjectID,omitempty"`
+	BaseURL           string `json:"base_url,omitempty"           dynamodbav:"BaseURL,omitempty"`
+	AzureOrganization string `json:"azure_organization,omitempty" dynamodbav:"AzureOrganization,omitempty"`
+	AzureProject      string `json:"azure_project,omitempty"      dynamodbav:"AzureProject,omitempty"`
+	TagPrefix         string `json:"tag_prefix,omitempty"         dynamodbav:"TagPrefix,omitempty"`
+	AutoBackMerge     bool   `json:"auto_back_merge,omitempty"    dynamodbav:"AutoBackMerge,omitempty"`
+	RollbackStrategy  string `json:"rollback_strategy,omitempty"  dynamodbav:"RollbackStrategy,omitempty"`
+
+	MergeMethod         string `json:"merge_method,omitempty"          dynamodbav:"MergeMethod,omitempty"`
+	MergeCommitTemplate string `json:"merge_commit_template,omitempty" dynamodbav:"MergeCommitTemplate,omitempty"`
+	DeleteSourceBranch  *bool  `json:"delete_source_branch,omitempty"  dynamodbav:"DeleteSourceBranch,omitempty"`
+
+	Components map[string]component `json:"components,omitempty" dynamodbav:"Components,omitempty"`
+}
+
+// component is an independently versioned part of a monorepo, which is tagged with its own prefix
+type component struct {
+	Path              string `json:"path"                         dynamodbav:"Path"`
+	TagPrefix         string `json:"tag_prefix"                   dynamodbav:"TagPrefix"`
+	CurrentVersion    string `json:"current_version,omitempty"    dynamodbav:"CurrentVersion,omitempty"`
+	CurrentPrerelease string `json:"current_prerelease,omitempty" dynamodbav:"CurrentPrerelease,omitempty"`
 }
 
 func (app application) handler(event events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
@@ -84,6 +121,8 @@ func main() {
 	log.SetFormatter(&log.JSONFormatter{})
 
 	app := application{
+		Providers:  providerRegistry,
+		GithubApps: githubapp.NewInstallations(),
 		AWS: awsController{
 			TableName: os.Getenv("TABLE_NAME"),
 			DB:        dynamodb.New(session.Must(session.NewSession())),
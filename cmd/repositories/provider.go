@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/seanturner026/moot/internal/azuredevops"
+	"github.com/seanturner026/moot/internal/bitbucket"
+	"github.com/seanturner026/moot/internal/gitea"
+	"github.com/seanturner026/moot/internal/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// tokenAccessChecker is implemented by each version control provider that repositories can be
+// onboarded from
+type tokenAccessChecker interface {
+	confirmTokenAccess(e createRepoEvent) error
+}
+
+// providerFactory builds a tokenAccessChecker using the token stored in SSM for the provider
+type providerFactory func(e createRepoEvent, token string) (tokenAccessChecker, error)
+
+// providerRegistry maps createRepoEvent.RepoProvider to the factory for that provider
+var providerRegistry = map[string]providerFactory{
+	"azuredevops": newAzureDevOpsController,
+	"bitbucket":   newBitbucketController,
+	"gitea":       newGiteaController,
+	"github":      newGithubController,
+	"gitlab":      newGitlabController,
+}
+
+func newAzureDevOpsController(e createRepoEvent, token string) (tokenAccessChecker, error) {
+	if e.AzureOrganization == "" || e.AzureProject == "" {
+		return nil, errors.New("azure devops repositories require an organization and project")
+	}
+
+	return azureDevOpsController{
+		Client: azuredevops.NewClient(e.BaseURL, e.AzureOrganization, e.AzureProject, token),
+	}, nil
+}
+
+func newBitbucketController(e createRepoEvent, token string) (tokenAccessChecker, error) {
+	return bitbucketController{Client: bitbucket.NewClient(token)}, nil
+}
+
+func newGiteaController(e createRepoEvent, token string) (tokenAccessChecker, error) {
+	if e.BaseURL == "" {
+		return nil, errors.New("gitea repositories require a base url")
+	}
+
+	client, err := gitea.NewClient(e.BaseURL, token)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create gitea client, %v", err))
+		return nil, err
+	}
+
+	return giteaController{Client: client}, nil
+}
+
+func newGithubController(e createRepoEvent, token string) (tokenAccessChecker, error) {
+	githubCtx := context.Background()
+	client, err := util.NewGithubClient(githubCtx, token, e.BaseURL)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create github client, %v", err))
+		return nil, err
+	}
+
+	return githubController{
+		Client:    client,
+		GithubCtx: githubCtx,
+	}, nil
+}
+
+func newGitlabController(e createRepoEvent, token string) (tokenAccessChecker, error) {
+	clientGitlab, err := util.NewGitlabClient(token, e.BaseURL)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create gitlab client, %v", err))
+		return nil, err
+	}
+
+	return gitlabController{Client: clientGitlab}, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	awslambda "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	log "github.com/sirupsen/logrus"
+)
+
+type application struct {
+	Config configuration
+	// Now is the clock which decides whether a release is due
+	Now func() time.Time
+}
+
+type configuration struct {
+	TableName            string
+	ReleasesFunctionName string
+	DB                   dynamodbiface.DynamoDBAPI
+	Lambda               lambdaiface.LambdaAPI
+}
+
+// handler is invoked on a timer, starts every scheduled release which is due, and has the releases
+// lambda fail the release trains which have gone stale
+func (app application) handler(event events.CloudWatchEvent) error {
+	log.Info(fmt.Sprintf("checking for scheduled releases due at %v", app.Now().UTC().Format(time.RFC3339)))
+	err := app.startDueReleases()
+
+	// NOTE(SMT): trains are expired even when the schedule cannot be read, as they do not depend on it
+	if expireErr := app.expireStaleTrains(); err == nil {
+		err = expireErr
+	}
+	return err
+}
+
+func main() {
+	log.SetFormatter(&log.JSONFormatter{})
+
+	local := flag.Bool("local", false, "start due releases once and exit, instead of running as a lambda")
+	now := flag.String("now", "", "RFC 3339 time to use as the current time with -local")
+	flag.Parse()
+
+	app := application{
+		Config: configuration{
+			TableName:            os.Getenv("TABLE_NAME"),
+			ReleasesFunctionName: os.Getenv("RELEASES_FUNCTION_NAME"),
+			DB:                   dynamodb.New(session.Must(session.NewSession())),
+			Lambda:               awslambda.New(session.Must(session.NewSession())),
+		},
+		Now: time.Now,
+	}
+
+	if !*local {
+		lambda.Start(app.handler)
+		return
+	}
+
+	if *now != "" {
+		t, err := time.Parse(time.RFC3339, *now)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("-now must be an RFC 3339 time, %v", err))
+		}
+		app.Now = func() time.Time { return t }
+	}
+
+	err := app.startDueReleases()
+	if err != nil {
+		log.Fatal(fmt.Sprintf("unable to start scheduled releases, %v", err))
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+// mockDynamoDB holds schedule items keyed by SK, and evaluates the filter and conditions used by the
+// scheduler
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	Items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func (m mockDynamoDB) QueryPages(input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool) error {
+	page := &dynamodb.QueryOutput{}
+	now := aws.StringValue(input.ExpressionAttributeValues[":now"].S)
+	for _, item := range m.Items {
+		if aws.StringValue(item["Status"].S) == "scheduled" && aws.StringValue(item["ReleaseAt"].S) <= now {
+			page.Items = append(page.Items, item)
+		}
+	}
+	fn(page, true)
+	return nil
+}
+
+func (m mockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	item := m.Items[aws.StringValue(input.Key["SK"].S)]
+	if aws.StringValue(item["Status"].S) != aws.StringValue(input.ExpressionAttributeValues[":from"].S) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	item["Status"] = input.ExpressionAttributeValues[":to"]
+	item["Message"] = input.ExpressionAttributeValues[":message"]
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+type mockInvoke struct {
+	lambdaiface.LambdaAPI
+	Inputs *[]*lambda.InvokeInput
+	Error  error
+}
+
+func (m mockInvoke) Invoke(input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	*m.Inputs = append(*m.Inputs, input)
+	return &lambda.InvokeOutput{StatusCode: aws.Int64(202)}, m.Error
+}
+
+func scheduleItem(id, releaseAt, status string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"PK":          {S: aws.String("schedule")},
+		"SK":          {S: aws.String(id)},
+		"ScheduleID":  {S: aws.String(id)},
+		"Body":        {S: aws.String(`{"repo_name": "api-service", "repo_provider": "github", "release_version": "2.3.0"}`)},
+		"ReleaseAt":   {S: aws.String(releaseAt)},
+		"ScheduledBy": {S: aws.String("jane@example.com")},
+		"Status":      {S: aws.String(status)},
+	}
+}
+
+func newTestApplication(items map[string]map[string]*dynamodb.AttributeValue, invokes *[]*lambda.InvokeInput, now string) application {
+	clock, _ := time.Parse(time.RFC3339, now)
+	return application{
+		Config: configuration{
+			TableName:            "test",
+			ReleasesFunctionName: "moot_releases",
+			DB:                   mockDynamoDB{Items: items},
+			Lambda:               mockInvoke{Inputs: invokes},
+		},
+		Now: func() time.Time { return clock },
+	}
+}
+
+func TestStartDueReleases(t *testing.T) {
+	t.Run("Successfully started due release", func(t *testing.T) {
+		items := map[string]map[string]*dynamodb.AttributeValue{
+			"due":       scheduleItem("due", "2021-12-25T06:00:00Z", "scheduled"),
+			"tomorrow":  scheduleItem("tomorrow", "2021-12-26T06:00:00Z", "scheduled"),
+			"cancelled": scheduleItem("cancelled", "2021-12-25T05:00:00Z", "cancelled"),
+		}
+		invokes := []*lambda.InvokeInput{}
+		app := newTestApplication(items, &invokes, "2021-12-25T06:00:30Z")
+
+		err := app.handler(events.CloudWatchEvent{})
+		if err != nil {
+			t.Fatalf("Scheduled releases should have been started, %v", err)
+		}
+		if len(invokes) != 2 {
+			t.Fatalf("Only the due release should have been started before expiring trains, got %v", len(invokes))
+		}
+		if aws.StringValue(items["due"]["Status"].S) != "started" || aws.StringValue(items["tomorrow"]["Status"].S) != "scheduled" {
+			t.Fatal("Due release should have been marked as started")
+		}
+
+		request := events.APIGatewayV2HTTPRequest{}
+		_ = json.Unmarshal(invokes[0].Payload, &request)
+		if aws.StringValue(invokes[0].FunctionName) != "moot_releases" || request.RawPath != "/releases/create" {
+			t.Fatalf("Release should have been sent to /releases/create, got %v %v", aws.StringValue(invokes[0].FunctionName), request.RawPath)
+		}
+		if request.Headers["idempotency-key"] != "schedule#due" || request.RequestContext.Authorizer.JWT.Claims["email"] != "jane@example.com" {
+			t.Fatalf("Release should have been sent with an idempotency key on behalf of jane, got %+v", request)
+		}
+
+		expiry := events.APIGatewayV2HTTPRequest{}
+		_ = json.Unmarshal(invokes[1].Payload, &expiry)
+		if aws.StringValue(invokes[1].InvocationType) != lambda.InvocationTypeEvent || expiry.RawPath != trainExpirePath {
+			t.Fatalf("Stale trains should have been expired through %v, got %v", trainExpirePath, expiry.RawPath)
+		}
+	})
+
+	t.Run("Release which is not due is not started", func(t *testing.T) {
+		items := map[string]map[string]*dynamodb.AttributeValue{
+			"due": scheduleItem("due", "2021-12-25T06:00:00Z", "scheduled"),
+		}
+		invokes := []*lambda.InvokeInput{}
+		app := newTestApplication(items, &invokes, "2021-12-25T05:59:59Z")
+
+		_ = app.startDueReleases()
+		if len(invokes) != 0 {
+			t.Fatal("Release should not have been started before it was due")
+		}
+	})
+
+	t.Run("Release which cannot be started is marked as failed", func(t *testing.T) {
+		items := map[string]map[string]*dynamodb.AttributeValue{
+			"due": scheduleItem("due", "2021-12-25T06:00:00Z", "scheduled"),
+		}
+		invokes := []*lambda.InvokeInput{}
+		app := newTestApplication(items, &invokes, "2021-12-25T06:00:00Z")
+		app.Config.Lambda = mockInvoke{Inputs: &invokes, Error: errors.New("throttled")}
+
+		_ = app.startDueReleases()
+		if aws.StringValue(items["due"]["Status"].S) != "failed" {
+			t.Fatalf("Release should have been marked as failed, got %v", aws.StringValue(items["due"]["Status"].S))
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	log "github.com/sirupsen/logrus"
+)
+
+// scheduledRelease is the schedule item written by the releases lambda. Body is the request body for
+// /releases/create.
+type scheduledRelease struct {
+	ScheduleID  string `dynamodbav:"ScheduleID"`
+	Body        string `dynamodbav:"Body"`
+	ReleaseAt   string `dynamodbav:"ReleaseAt"`
+	ScheduledBy string `dynamodbav:"ScheduledBy"`
+}
+
+// dueReleases returns the scheduled releases whose ReleaseAt has passed
+func (app application) dueReleases() ([]scheduledRelease, error) {
+	due := []scheduledRelease{}
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":primary_key": {
+				S: aws.String("schedule"),
+			},
+			":scheduled": {
+				S: aws.String("scheduled"),
+			},
+			":now": {
+				S: aws.String(app.Now().UTC().Format(time.RFC3339)),
+			},
+		},
+		// NOTE(SMT): ReleaseAt is always written in UTC, so comparing the strings compares the times
+		FilterExpression:       aws.String("#status = :scheduled AND ReleaseAt <= :now"),
+		KeyConditionExpression: aws.String("PK = :primary_key"),
+		TableName:              aws.String(app.Config.TableName),
+	}
+
+	err := app.Config.DB.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		releases := []scheduledRelease{}
+		err := dynamodbattribute.UnmarshalListOfMaps(page.Items, &releases)
+		if err != nil {
+			log.Error(fmt.Sprintf("unable to unmarshal scheduled releases, %v", err))
+		}
+		due = append(due, releases...)
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return due, err
+	}
+	return due, nil
+}
+
+// setStatus moves the scheduled release from one status to another. Moving it from scheduled fails
+// when another scheduler has already started it, or it has been cancelled.
+func (app application) setStatus(s scheduledRelease, from, to, message string) error {
+	input := &dynamodb.UpdateItemInput{
+		ConditionExpression: aws.String("#status = :from"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("Status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":from": {
+				S: aws.String(from),
+			},
+			":to": {
+				S: aws.String(to),
+			},
+			":message": {
+				S: aws.String(message),
+			},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String("schedule"),
+			},
+			"SK": {
+				S: aws.String(s.ScheduleID),
+			},
+		},
+		TableName:        aws.String(app.Config.TableName),
+		UpdateExpression: aws.String("SET #status = :to, Message = :message"),
+	}
+
+	_, err := app.Config.DB.UpdateItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return err
+	}
+	return nil
+}
+
+// releaseRequest is the API Gateway request which runs the scheduled release through the releases
+// lambda. It is made on behalf of the user who scheduled the release, and its idempotency key
+// resumes the release should it fail part way through and be scheduled again.
+func releaseRequest(s scheduledRelease) events.APIGatewayV2HTTPRequest {
+	event := events.APIGatewayV2HTTPRequest{
+		RawPath: "/releases/create",
+		Headers: map[string]string{"idempotency-key": fmt.Sprintf("schedule#%s", s.ScheduleID)},
+		Body:    s.Body,
+	}
+	event.RequestContext.RequestID = fmt.Sprintf("schedule-%s", s.ScheduleID)
+	event.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+		JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+			Claims: map[string]string{"email": s.ScheduledBy},
+		},
+	}
+	return event
+}
+
+// startRelease invokes the releases lambda asynchronously, as a release can take longer than the
+// scheduler is allowed to run. The releases lambda records its outcome on the schedule.
+func (app application) startRelease(s scheduledRelease) error {
+	payload, err := json.Marshal(releaseRequest(s))
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal release request, %v", err))
+		return err
+	}
+
+	input := &lambda.InvokeInput{
+		FunctionName:   aws.String(app.Config.ReleasesFunctionName),
+		InvocationType: aws.String(lambda.InvocationTypeEvent),
+		Payload:        payload,
+	}
+
+	log.Info(fmt.Sprintf("starting scheduled release %v...", s.ScheduleID))
+	_, err = app.Config.Lambda.Invoke(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return err
+	}
+	return nil
+}
+
+// startDueReleases starts every scheduled release which is due. A release which cannot be started is
+// marked as failed, and does not stop the remaining releases from starting.
+func (app application) startDueReleases() error {
+	due, err := app.dueReleases()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range due {
+		err = app.setStatus(s, "scheduled", "started", "")
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			log.Info(fmt.Sprintf("scheduled release %v has already been started or cancelled", s.ScheduleID))
+			continue
+		} else if err != nil {
+			continue
+		}
+
+		err = app.startRelease(s)
+		if err != nil {
+			_ = app.setStatus(s, "started", "failed", fmt.Sprintf("unable to start release, %v", err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	log "github.com/sirupsen/logrus"
+)
+
+// trainExpirePath is the path of the releases lambda which fails the release trains that have gone
+// stale, e.g. because the invocation running one of their releases timed out
+const trainExpirePath = "/releases/train/expire"
+
+// expireStaleTrains invokes the releases lambda asynchronously to fail the release trains which have
+// gone stale, so that they are failed and summarised without anyone listing them
+func (app application) expireStaleTrains() error {
+	payload, err := json.Marshal(events.APIGatewayV2HTTPRequest{RawPath: trainExpirePath})
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to marshal release train expiry request, %v", err))
+		return err
+	}
+
+	input := &lambda.InvokeInput{
+		FunctionName:   aws.String(app.Config.ReleasesFunctionName),
+		InvocationType: aws.String(lambda.InvocationTypeEvent),
+		Payload:        payload,
+	}
+
+	log.Info("expiring stale release trains...")
+	_, err = app.Config.Lambda.Invoke(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+// Package azuredevops is a minimal client for the Azure DevOps Git REST API which covers the calls
+// required to onboard repositories and release them
+package azuredevops
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the Azure DevOps Services endpoint
+const DefaultBaseURL = "https://dev.azure.com"
+
+const apiVersion = "7.0"
+
+// Client sends requests authenticated with a personal access token to a single Azure DevOps project
+type Client struct {
+	BaseURL      string
+	Organization string
+	Project      string
+	Token        string
+	HTTPClient   *http.Client
+}
+
+// Repository is the subset of an Azure DevOps git repository used by the dashboard
+type Repository struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	DefaultBranch string `json:"defaultBranch"`
+}
+
+// IdentityRef identifies the user or service principal which performed an action
+type IdentityRef struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+// CommitRef points at a commit
+type CommitRef struct {
+	CommitID string `json:"commitId"`
+}
+
+// PullRequest is the subset of an Azure DevOps pull request used by the dashboard
+type PullRequest struct {
+	PullRequestID         int         `json:"pullRequestId"`
+	Status                string      `json:"status"`
+	MergeStatus           string      `json:"mergeStatus"`
+	CreatedBy             IdentityRef `json:"createdBy"`
+	LastMergeSourceCommit CommitRef   `json:"lastMergeSourceCommit"`
+	LastMergeCommit       CommitRef   `json:"lastMergeCommit"`
+}
+
+// Commit is the subset of an Azure DevOps commit used by the dashboard
+type Commit struct {
+	CommitID string   `json:"commitId"`
+	Comment  string   `json:"comment"`
+	Parents  []string `json:"parents"`
+	Author   struct {
+		Name string `json:"name"`
+	} `json:"author"`
+}
+
+// Change is a file changed between two commits
+type Change struct {
+	ChangeType string `json:"changeType"`
+	Item       struct {
+		Path     string `json:"path"`
+		IsFolder bool   `json:"isFolder"`
+	} `json:"item"`
+}
+
+// CommitDiffs is the difference between a base and target version
+type CommitDiffs struct {
+	AheadCount  int      `json:"aheadCount"`
+	BehindCount int      `json:"behindCount"`
+	Changes     []Change `json:"changes"`
+}
+
+// Ref is a git ref and the object it points to
+type Ref struct {
+	Name     string `json:"name"`
+	ObjectID string `json:"objectId"`
+}
+
+type errorResponse struct {
+	Message string `json:"message"`
+}
+
+// NewClient returns a Client for the project. baseURL may be empty to use Azure DevOps Services, or
+// the collection URL of an Azure DevOps Server installation.
+func NewClient(baseURL, organization, project, token string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+
+	return &Client{
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+		Organization: organization,
+		Project:      project,
+		Token:        token,
+		HTTPClient:   &http.Client{Timeout: 8 * time.Second},
+	}
+}
+
+func (c *Client) do(method, path string, query url.Values, body, out interface{}) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("api-version", apiVersion)
+	endpoint := fmt.Sprintf("%s/%s/%s/_apis/git%s?%s",
+		c.BaseURL,
+		url.PathEscape(c.Organization),
+		url.PathEscape(c.Project),
+		path,
+		query.Encode(),
+	)
+
+	req, err := http.NewRequest(method, endpoint, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth("", c.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		errResp := errorResponse{}
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("%v %v: %v %v", method, path, resp.StatusCode, errResp.Message)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func repoPath(repo string) string {
+	return "/repositories/" + url.PathEscape(repo)
+}
+
+// WebURL returns the browser URL of the pull request
+func (c *Client) WebURL(repo string, pullRequestID int) string {
+	return fmt.Sprintf("%s/%s/%s/_git/%s/pullrequest/%d",
+		c.BaseURL,
+		url.PathEscape(c.Organization),
+		url.PathEscape(c.Project),
+		url.PathEscape(repo),
+		pullRequestID,
+	)
+}
+
+// GetRepository returns the repository, and is used to confirm that the token can access it
+func (c *Client) GetRepository(repo string) (Repository, error) {
+	resp := Repository{}
+	err := c.do(http.MethodGet, repoPath(repo), nil, nil, &resp)
+	return resp, err
+}
+
+// GetBranch returns the ref for the branch
+func (c *Client) GetBranch(repo, branch string) (Ref, error) {
+	resp := struct {
+		Value []Ref `json:"value"`
+	}{}
+	query := url.Values{"filter": []string{"heads/" + branch}}
+	err := c.do(http.MethodGet, repoPath(repo)+"/refs", query, nil, &resp)
+	if err != nil {
+		return Ref{}, err
+	}
+
+	for _, ref := range resp.Value {
+		if ref.Name == "refs/heads/"+branch {
+			return ref, nil
+		}
+	}
+	return Ref{}, fmt.Errorf("branch %v does not exist", branch)
+}
+
+// ListCommits returns up to 1000 commits on the compare branch which are not on base, newest first.
+// baseType is the type of base, either branch or tag.
+func (c *Client) ListCommits(repo, base, baseType, compare string) ([]Commit, error) {
+	resp := struct {
+		Value []Commit `json:"value"`
+	}{}
+	query := url.Values{
+		"searchCriteria.itemVersion.version":        []string{base},
+		"searchCriteria.itemVersion.versionType":    []string{baseType},
+		"searchCriteria.compareVersion.version":     []string{compare},
+		"searchCriteria.compareVersion.versionType": []string{"branch"},
+		"searchCriteria.$top":                       []string{"1000"},
+	}
+	err := c.do(http.MethodGet, repoPath(repo)+"/commits", query, nil, &resp)
+	return resp.Value, err
+}
+
+// GetCommitDiffs returns the files changed on the target branch since it diverged from base, and
+// the number of commits each is ahead of the other. baseType is the type of base, either branch or tag.
+func (c *Client) GetCommitDiffs(repo, base, baseType, target string) (CommitDiffs, error) {
+	resp := CommitDiffs{}
+	query := url.Values{
+		"baseVersion":       []string{base},
+		"baseVersionType":   []string{baseType},
+		"targetVersion":     []string{target},
+		"targetVersionType": []string{"branch"},
+		"diffCommonCommit":  []string{"true"},
+		"$top":              []string{"1000"},
+	}
+	err := c.do(http.MethodGet, repoPath(repo)+"/diffs/commits", query, nil, &resp)
+	return resp, err
+}
+
+// CreatePullRequest opens a pull request which merges source into target
+func (c *Client) CreatePullRequest(repo, title, description, source, target string) (PullRequest, error) {
+	input := map[string]string{
+		"title":         title,
+		"description":   description,
+		"sourceRefName": "refs/heads/" + source,
+		"targetRefName": "refs/heads/" + target,
+	}
+
+	resp := PullRequest{}
+	err := c.do(http.MethodPost, repoPath(repo)+"/pullrequests", nil, input, &resp)
+	return resp, err
+}
+
+// GetPullRequest returns the pull request
+func (c *Client) GetPullRequest(repo string, pullRequestID int) (PullRequest, error) {
+	resp := PullRequest{}
+	err := c.do(http.MethodGet, fmt.Sprintf("%s/pullrequests/%d", repoPath(repo), pullRequestID), nil, nil, &resp)
+	return resp, err
+}
+
+// ListActivePullRequests returns the active pull requests which merge source into target
+func (c *Client) ListActivePullRequests(repo, source, target string) ([]PullRequest, error) {
+	resp := struct {
+		Value []PullRequest `json:"value"`
+	}{}
+	query := url.Values{
+		"searchCriteria.status":        []string{"active"},
+		"searchCriteria.sourceRefName": []string{"refs/heads/" + source},
+		"searchCriteria.targetRefName": []string{"refs/heads/" + target},
+	}
+	err := c.do(http.MethodGet, repoPath(repo)+"/pullrequests", query, nil, &resp)
+	return resp.Value, err
+}
+
+// UpdatePullRequest replaces the title and description of the pull request
+func (c *Client) UpdatePullRequest(repo string, pullRequestID int, title, description string) (PullRequest, error) {
+	input := map[string]string{
+		"title":       title,
+		"description": description,
+	}
+
+	resp := PullRequest{}
+	err := c.do(http.MethodPatch, fmt.Sprintf("%s/pullrequests/%d", repoPath(repo), pullRequestID), nil, input, &resp)
+	return resp, err
+}
+
+// CompletePullRequest completes the pull request with a merge commit. Completion is asynchronous, so
+// the returned pull request may still be active.
+func (c *Client) CompletePullRequest(repo string, pr PullRequest, message string) (PullRequest, error) {
+	input := map[string]interface{}{
+		"status":                "completed",
+		"lastMergeSourceCommit": pr.LastMergeSourceCommit,
+		"completionOptions": map[string]interface{}{
+			"mergeStrategy":      "noFastForward",
+			"mergeCommitMessage": message,
+			"deleteSourceBranch": false,
+		},
+	}
+
+	resp := PullRequest{}
+	err := c.do(http.MethodPatch, fmt.Sprintf("%s/pullrequests/%d", repoPath(repo), pr.PullRequestID), nil, input, &resp)
+	return resp, err
+}
+
+// SetAutoComplete completes the pull request on behalf of its creator once all branch policies pass
+func (c *Client) SetAutoComplete(repo string, pr PullRequest, message string) (PullRequest, error) {
+	input := map[string]interface{}{
+		"autoCompleteSetBy": IdentityRef{ID: pr.CreatedBy.ID},
+		"completionOptions": map[string]interface{}{
+			"mergeStrategy":      "noFastForward",
+			"mergeCommitMessage": message,
+			"deleteSourceBranch": false,
+		},
+	}
+
+	resp := PullRequest{}
+	err := c.do(http.MethodPatch, fmt.Sprintf("%s/pullrequests/%d", repoPath(repo), pr.PullRequestID), nil, input, &resp)
+	return resp, err
+}
+
+// CreateAnnotatedTag creates an annotated tag pointing at the commit
+func (c *Client) CreateAnnotatedTag(repo, name, commitID, message string) error {
+	input := map[string]interface{}{
+		"name":         name,
+		"message":      message,
+		"taggedObject": map[string]string{"objectId": commitID},
+	}
+
+	return c.do(http.MethodPost, repoPath(repo)+"/annotatedtags", nil, input, nil)
+}
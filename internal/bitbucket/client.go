@@ -0,0 +1,265 @@
+// Package bitbucket is a minimal client for the Bitbucket Cloud 2.0 REST API which covers the calls
+// required to onboard repositories and release them
+package bitbucket
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the Bitbucket Cloud API endpoint
+const DefaultBaseURL = "https://api.bitbucket.org/2.0"
+
+// maxPages bounds the number of pages read from paginated endpoints
+const maxPages = 10
+
+// Client sends authenticated requests to the Bitbucket API
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// Repository is the subset of a Bitbucket repository used by the dashboard
+type Repository struct {
+	FullName string `json:"full_name"`
+}
+
+// PullRequest is the subset of a Bitbucket pull request used by the dashboard
+type PullRequest struct {
+	ID          int    `json:"id"`
+	State       string `json:"state"`
+	MergeCommit *struct {
+		Hash string `json:"hash"`
+	} `json:"merge_commit,omitempty"`
+	Links struct {
+		HTML struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+}
+
+// Branch is the subset of a Bitbucket branch used by the dashboard
+type Branch struct {
+	Name   string `json:"name"`
+	Target struct {
+		Hash string `json:"hash"`
+	} `json:"target"`
+}
+
+// Commit is the subset of a Bitbucket commit used by the dashboard
+type Commit struct {
+	Hash    string `json:"hash"`
+	Message string `json:"message"`
+	Author  struct {
+		Raw string `json:"raw"`
+	} `json:"author"`
+	Parents []struct {
+		Hash string `json:"hash"`
+	} `json:"parents"`
+}
+
+type commitPage struct {
+	Values []Commit `json:"values"`
+	Next   string   `json:"next"`
+}
+
+// DiffStat is the number of lines changed in a single file
+type DiffStat struct {
+	Status       string `json:"status"`
+	LinesAdded   int    `json:"lines_added"`
+	LinesRemoved int    `json:"lines_removed"`
+	Old          *struct {
+		Path string `json:"path"`
+	} `json:"old"`
+	New *struct {
+		Path string `json:"path"`
+	} `json:"new"`
+}
+
+type diffStatPage struct {
+	Values []DiffStat `json:"values"`
+	Next   string     `json:"next"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// NewClient returns a Client for Bitbucket Cloud. Tokens in the form `username:app_password` are
+// sent using basic auth, any other token is sent as a bearer access token.
+func NewClient(token string) *Client {
+	return &Client{
+		BaseURL:    DefaultBaseURL,
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 8 * time.Second},
+	}
+}
+
+func (c *Client) do(method, path string, body, out interface{}) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.BaseURL, "/")+path, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if credentials := strings.SplitN(c.Token, ":", 2); len(credentials) == 2 {
+		req.SetBasicAuth(credentials[0], credentials[1])
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		errResp := errorResponse{}
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("%v %v: %v %v", method, path, resp.StatusCode, errResp.Error.Message)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func repoPath(workspace, slug string) string {
+	return fmt.Sprintf("/repositories/%s/%s", url.PathEscape(workspace), url.PathEscape(slug))
+}
+
+// GetRepository returns the repository, and is used to confirm that the token can access it
+func (c *Client) GetRepository(workspace, slug string) (Repository, error) {
+	repo := Repository{}
+	err := c.do(http.MethodGet, repoPath(workspace, slug), nil, &repo)
+	return repo, err
+}
+
+// GetBranch returns the branch and the commit it points to
+func (c *Client) GetBranch(workspace, slug, name string) (Branch, error) {
+	branch := Branch{}
+	err := c.do(http.MethodGet, repoPath(workspace, slug)+"/refs/branches/"+url.PathEscape(name), nil, &branch)
+	return branch, err
+}
+
+// ListCommits returns the commits reachable from include which are not reachable from exclude,
+// newest first. At most maxPages pages of results are read.
+func (c *Client) ListCommits(workspace, slug, include, exclude string) ([]Commit, error) {
+	commits := []Commit{}
+	query := url.Values{"exclude": []string{exclude}, "pagelen": []string{"100"}}
+	path := fmt.Sprintf("%s/commits/%s?%s", repoPath(workspace, slug), url.PathEscape(include), query.Encode())
+	for i := 0; i < maxPages && path != ""; i++ {
+		page := commitPage{}
+		err := c.do(http.MethodGet, path, nil, &page)
+		if err != nil {
+			return commits, err
+		}
+		commits = append(commits, page.Values...)
+		path = strings.TrimPrefix(page.Next, strings.TrimSuffix(c.BaseURL, "/"))
+	}
+	return commits, nil
+}
+
+// ListDiffStat returns the files changed on source since it diverged from destination. Files which
+// would conflict when merging source into destination have a status of `merge conflict`.
+func (c *Client) ListDiffStat(workspace, slug, source, destination string) ([]DiffStat, error) {
+	stats := []DiffStat{}
+	spec := url.PathEscape(source) + ".." + url.PathEscape(destination)
+	path := fmt.Sprintf("%s/diffstat/%s?merge=true&pagelen=500", repoPath(workspace, slug), spec)
+	for i := 0; i < maxPages && path != ""; i++ {
+		page := diffStatPage{}
+		err := c.do(http.MethodGet, path, nil, &page)
+		if err != nil {
+			return stats, err
+		}
+		stats = append(stats, page.Values...)
+		path = strings.TrimPrefix(page.Next, strings.TrimSuffix(c.BaseURL, "/"))
+	}
+	return stats, nil
+}
+
+// CreatePullRequest opens a pull request which merges source into destination
+func (c *Client) CreatePullRequest(workspace, slug, title, description, source, destination string) (PullRequest, error) {
+	input := map[string]interface{}{
+		"title":       title,
+		"description": description,
+		"source":      map[string]interface{}{"branch": map[string]string{"name": source}},
+		"destination": map[string]interface{}{"branch": map[string]string{"name": destination}},
+	}
+
+	pr := PullRequest{}
+	err := c.do(http.MethodPost, repoPath(workspace, slug)+"/pullrequests", input, &pr)
+	return pr, err
+}
+
+// bbqlString quotes s as a string in a Bitbucket query, escaping its backslashes and quotes
+func bbqlString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// ListOpenPullRequests returns the open pull requests which merge source into destination
+func (c *Client) ListOpenPullRequests(workspace, slug, source, destination string) ([]PullRequest, error) {
+	page := struct {
+		Values []PullRequest `json:"values"`
+	}{}
+	q := fmt.Sprintf("source.branch.name = %s AND destination.branch.name = %s", bbqlString(source), bbqlString(destination))
+	query := url.Values{"state": []string{"OPEN"}, "q": []string{q}}
+	err := c.do(http.MethodGet, fmt.Sprintf("%s/pullrequests?%s", repoPath(workspace, slug), query.Encode()), nil, &page)
+	return page.Values, err
+}
+
+// UpdatePullRequest replaces the title and description of the pull request
+func (c *Client) UpdatePullRequest(workspace, slug string, id int, title, description string) (PullRequest, error) {
+	input := map[string]string{
+		"title":       title,
+		"description": description,
+	}
+
+	pr := PullRequest{}
+	err := c.do(http.MethodPut, fmt.Sprintf("%s/pullrequests/%d", repoPath(workspace, slug), id), input, &pr)
+	return pr, err
+}
+
+// MergePullRequest merges the pull request using a merge commit
+func (c *Client) MergePullRequest(workspace, slug string, id int, message string) (PullRequest, error) {
+	input := map[string]interface{}{
+		"type":                "pullrequest",
+		"message":             message,
+		"merge_strategy":      "merge_commit",
+		"close_source_branch": false,
+	}
+
+	pr := PullRequest{}
+	err := c.do(http.MethodPost, fmt.Sprintf("%s/pullrequests/%d/merge", repoPath(workspace, slug), id), input, &pr)
+	return pr, err
+}
+
+// CreateTag creates a tag pointing at the commit hash
+func (c *Client) CreateTag(workspace, slug, name, hash, message string) error {
+	input := map[string]interface{}{
+		"name":    name,
+		"message": message,
+		"target":  map[string]string{"hash": hash},
+	}
+
+	return c.do(http.MethodPost, repoPath(workspace, slug)+"/refs/tags", input, nil)
+}
@@ -0,0 +1,232 @@
+// Package gitea is a minimal client for the Gitea (and Forgejo) v1 REST API which covers the calls
+// required to onboard repositories and release them
+package gitea
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client sends authenticated requests to the API of a self-hosted Gitea instance
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// Repository is the subset of a Gitea repository used by the dashboard
+type Repository struct {
+	FullName      string `json:"full_name"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+// PullRequest is the subset of a Gitea pull request used by the dashboard
+type PullRequest struct {
+	Number         int    `json:"number"`
+	HTMLURL        string `json:"html_url"`
+	State          string `json:"state"`
+	Mergeable      bool   `json:"mergeable"`
+	Merged         bool   `json:"merged"`
+	MergeCommitSHA string `json:"merge_commit_sha"`
+	Head           struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+// Release is the subset of a Gitea release used by the dashboard
+type Release struct {
+	ID      int64  `json:"id"`
+	TagName string `json:"tag_name"`
+	HTMLURL string `json:"html_url"`
+}
+
+// Commit is the subset of a Gitea commit used by the dashboard
+type Commit struct {
+	SHA    string `json:"sha"`
+	Commit struct {
+		Message string `json:"message"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commit"`
+	Parents []struct {
+		SHA string `json:"sha"`
+	} `json:"parents"`
+}
+
+// ChangedFile is a file changed by a comparison
+type ChangedFile struct {
+	Filename string `json:"filename"`
+	Status   string `json:"status"`
+}
+
+// Comparison is the subset of a Gitea comparison used by the dashboard. Files is only returned by
+// Gitea 1.22 and later.
+type Comparison struct {
+	TotalCommits int           `json:"total_commits"`
+	Commits      []Commit      `json:"commits"`
+	Files        []ChangedFile `json:"files"`
+}
+
+type errorResponse struct {
+	Message string `json:"message"`
+}
+
+// NewClient returns a Client for the Gitea instance hosted at baseURL, e.g. https://gitea.example.com
+func NewClient(baseURL, token string) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("gitea base url %q must be absolute", baseURL)
+	}
+
+	return &Client{
+		BaseURL:    strings.TrimSuffix(u.String(), "/") + "/api/v1",
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 8 * time.Second},
+	}, nil
+}
+
+func (c *Client) do(method, path string, body, out interface{}) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL+path, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "token "+c.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		errResp := errorResponse{}
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("%v %v: %v %v", method, path, resp.StatusCode, errResp.Message)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func repoPath(owner, repo string) string {
+	return fmt.Sprintf("/repos/%s/%s", url.PathEscape(owner), url.PathEscape(repo))
+}
+
+// GetRepository returns the repository, and is used to confirm that the token can access it
+func (c *Client) GetRepository(owner, repo string) (Repository, error) {
+	resp := Repository{}
+	err := c.do(http.MethodGet, repoPath(owner, repo), nil, &resp)
+	return resp, err
+}
+
+// CompareBranches returns the commits on head which are not on base
+func (c *Client) CompareBranches(owner, repo, base, head string) (Comparison, error) {
+	resp := Comparison{}
+	path := fmt.Sprintf("%s/compare/%s...%s", repoPath(owner, repo), url.PathEscape(base), url.PathEscape(head))
+	err := c.do(http.MethodGet, path, nil, &resp)
+	return resp, err
+}
+
+// CreatePullRequest opens a pull request which merges head into base
+func (c *Client) CreatePullRequest(owner, repo, title, body, head, base string) (PullRequest, error) {
+	input := map[string]string{
+		"title": title,
+		"body":  body,
+		"head":  head,
+		"base":  base,
+	}
+
+	resp := PullRequest{}
+	err := c.do(http.MethodPost, repoPath(owner, repo)+"/pulls", input, &resp)
+	return resp, err
+}
+
+// GetPullRequest returns the pull request with the given index
+func (c *Client) GetPullRequest(owner, repo string, index int) (PullRequest, error) {
+	resp := PullRequest{}
+	err := c.do(http.MethodGet, fmt.Sprintf("%s/pulls/%d", repoPath(owner, repo), index), nil, &resp)
+	return resp, err
+}
+
+// ListOpenPullRequests returns the open pull requests which merge head into base. Gitea cannot filter
+// pull requests by branch, so only the 50 most recently updated open pull requests are searched.
+func (c *Client) ListOpenPullRequests(owner, repo, head, base string) ([]PullRequest, error) {
+	resp := []PullRequest{}
+	err := c.do(http.MethodGet, repoPath(owner, repo)+"/pulls?state=open&sort=recentupdate&limit=50", nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	prs := []PullRequest{}
+	for _, pr := range resp {
+		if pr.Head.Ref == head && pr.Base.Ref == base {
+			prs = append(prs, pr)
+		}
+	}
+	return prs, nil
+}
+
+// UpdatePullRequest replaces the title and body of the pull request
+func (c *Client) UpdatePullRequest(owner, repo string, index int, title, body string) (PullRequest, error) {
+	input := map[string]string{
+		"title": title,
+		"body":  body,
+	}
+
+	resp := PullRequest{}
+	err := c.do(http.MethodPatch, fmt.Sprintf("%s/pulls/%d", repoPath(owner, repo), index), input, &resp)
+	return resp, err
+}
+
+// MergePullRequest merges the pull request with a merge commit
+func (c *Client) MergePullRequest(owner, repo string, index int, message string) error {
+	input := map[string]interface{}{
+		"Do":                        "merge",
+		"MergeTitleField":           message,
+		"delete_branch_after_merge": false,
+	}
+
+	return c.do(http.MethodPost, fmt.Sprintf("%s/pulls/%d/merge", repoPath(owner, repo), index), input, nil)
+}
+
+// CreateRelease creates a release and its tag on the target branch, optionally marked as a
+// prerelease
+func (c *Client) CreateRelease(owner, repo, tagName, target, name, body string, prerelease bool) (Release, error) {
+	input := map[string]interface{}{
+		"tag_name":         tagName,
+		"target_commitish": target,
+		"name":             name,
+		"body":             body,
+		"draft":            false,
+		"prerelease":       prerelease,
+	}
+
+	resp := Release{}
+	err := c.do(http.MethodPost, repoPath(owner, repo)+"/releases", input, &resp)
+	return resp, err
+}
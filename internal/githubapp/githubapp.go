@@ -0,0 +1,194 @@
+// Package githubapp authenticates as a Github App installation, so that pull requests and releases
+// are created by the app's bot account rather than by the owner of a personal access token
+package githubapp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/seanturner026/moot/internal/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNotConfigured is returned by GetCredentials when no Github App has been stored in SSM, in which
+// case the personal access token should be used instead
+var ErrNotConfigured = errors.New("github app credentials have not been configured")
+
+// Credentials identify the Github App and are used to sign JWTs
+type Credentials struct {
+	AppID      int64
+	PrivateKey *rsa.PrivateKey
+}
+
+type installationToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// Installations exchanges app JWTs for installation tokens, and caches the tokens for each
+// repository owner until shortly before they expire. A single Installations should be shared
+// between invocations so that warm lambdas reuse tokens.
+type Installations struct {
+	Now func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]installationToken
+}
+
+// expiryMargin ensures cached tokens are not handed out moments before they expire
+const expiryMargin = 5 * time.Minute
+
+// NewInstallations returns an empty installation token cache
+func NewInstallations() *Installations {
+	return &Installations{
+		Now:    time.Now,
+		tokens: map[string]installationToken{},
+	}
+}
+
+// GetCredentials reads the app ID and private key from the github_app_id and github_app_private_key
+// SSM parameters, keyed by instance host for Github Enterprise Server
+func GetCredentials(svc ssmiface.SSMAPI, dashboardName, baseURL string) (Credentials, error) {
+	appIDName := util.ProviderParameterName(dashboardName, baseURL, "github_app_id")
+	privateKeyName := util.ProviderParameterName(dashboardName, baseURL, "github_app_private_key")
+	input := &ssm.GetParametersInput{
+		Names:          aws.StringSlice([]string{appIDName, privateKeyName}),
+		WithDecryption: aws.Bool(true),
+	}
+
+	resp, err := svc.GetParameters(input)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to read github app credentials, %v", err))
+		return Credentials{}, err
+	}
+
+	values := map[string]string{}
+	for _, parameter := range resp.Parameters {
+		values[aws.StringValue(parameter.Name)] = aws.StringValue(parameter.Value)
+	}
+	if len(values) == 0 {
+		return Credentials{}, ErrNotConfigured
+	}
+	if values[appIDName] == "" || values[privateKeyName] == "" {
+		return Credentials{}, fmt.Errorf("both %v and %v must be set", appIDName, privateKeyName)
+	}
+
+	appID, err := strconv.ParseInt(values[appIDName], 10, 64)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("github app id %v is not numeric", values[appIDName])
+	}
+
+	privateKey, err := ParsePrivateKey([]byte(values[privateKeyName]))
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	return Credentials{AppID: appID, PrivateKey: privateKey}, nil
+}
+
+// ParsePrivateKey parses the PEM encoded private key downloaded from the Github App settings
+func ParsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("github app private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("github app private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// SignJWT creates the RS256 JWT used to authenticate as the app itself
+func SignJWT(creds Credentials, now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]int64{
+		// NOTE(SMT): backdated to allow for clock drift between lambda and github
+		"iat": now.Add(-60 * time.Second).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": creds.AppID,
+	})
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, creds.PrivateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Token returns an installation token for the app installation on the repository's owner, using a
+// cached token when one is available
+func (i *Installations) Token(ctx context.Context, creds Credentials, baseURL, owner, repo string) (string, error) {
+	key := fmt.Sprintf("%s#%d#%s", baseURL, creds.AppID, owner)
+	now := i.Now()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if cached, ok := i.tokens[key]; ok && now.Add(expiryMargin).Before(cached.ExpiresAt) {
+		return cached.Token, nil
+	}
+
+	jwt, err := SignJWT(creds, now)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to sign github app jwt, %v", err))
+		return "", err
+	}
+
+	client, err := util.NewGithubClient(ctx, jwt, baseURL)
+	if err != nil {
+		return "", err
+	}
+
+	log.Info(fmt.Sprintf("finding github app installation for %v...", owner))
+	installation, _, err := client.Apps.FindRepositoryInstallation(ctx, owner, repo)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to find github app installation for %v/%v, %v", owner, repo, err))
+		return "", err
+	}
+
+	req, err := client.NewRequest("POST", fmt.Sprintf("app/installations/%d/access_tokens", installation.GetID()), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp := struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{}
+	log.Info(fmt.Sprintf("creating github app installation token for %v...", owner))
+	_, err = client.Do(ctx, req, &resp)
+	if err != nil {
+		log.Error(fmt.Sprintf("unable to create github app installation token for %v, %v", owner, err))
+		return "", err
+	}
+
+	i.tokens[key] = installationToken{Token: resp.Token, ExpiresAt: resp.ExpiresAt}
+	return resp.Token, nil
+}
@@ -0,0 +1,81 @@
+package githubapp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstallationsToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := Credentials{AppID: 42, PrivateKey: key}
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tokenRequests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/repos/owner/repo/installation", func(w http.ResponseWriter, r *http.Request) {
+		jwt := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		parts := strings.Split(jwt, ".")
+		if len(parts) != 3 {
+			t.Fatalf("expected a jwt, got %q", jwt)
+		}
+
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+			t.Fatalf("jwt signature should be valid, %v", err)
+		}
+
+		claims := map[string]int64{}
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		json.Unmarshal(payload, &claims)
+		if claims["iss"] != 42 {
+			t.Fatalf("jwt should be issued by the app, got %v", claims["iss"])
+		}
+
+		w.Write([]byte(`{"id": 7}`))
+	})
+	mux.HandleFunc("/api/v3/app/installations/7/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"token": "installation-token", "expires_at": "2021-06-01T13:00:00Z"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	installations := NewInstallations()
+	installations.Now = func() time.Time { return now }
+
+	t.Run("Successfully exchanged jwt for an installation token", func(t *testing.T) {
+		token, err := installations.Token(context.Background(), creds, server.URL, "owner", "repo")
+		if err != nil || token != "installation-token" {
+			t.Fatalf("Installation token should have been returned, got %v %v", token, err)
+		}
+	})
+
+	t.Run("Installation token is cached until expiry", func(t *testing.T) {
+		now = now.Add(30 * time.Minute)
+		installations.Token(context.Background(), creds, server.URL, "owner", "repo")
+		if tokenRequests != 1 {
+			t.Fatalf("Cached token should have been reused, got %v token requests", tokenRequests)
+		}
+
+		now = now.Add(26 * time.Minute)
+		installations.Token(context.Background(), creds, server.URL, "owner", "repo")
+		if tokenRequests != 2 {
+			t.Fatalf("Token close to expiry should have been refreshed, got %v token requests", tokenRequests)
+		}
+	})
+}
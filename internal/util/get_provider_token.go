@@ -0,0 +1,71 @@
+package util
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	log "github.com/sirupsen/logrus"
+)
+
+var invalidParameterCharacters = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// ProviderParameterName returns the name of an SSM parameter which holds provider credentials.
+// Repositories hosted on a self-hosted instance (e.g. Github Enterprise Server) have their
+// credentials keyed by the host of the instance, for example /moot/github.example.com/github_token.
+func ProviderParameterName(dashboardName, baseURL, name string) string {
+	if baseURL == "" {
+		return fmt.Sprintf("/%s/%s", dashboardName, name)
+	}
+
+	host := baseURL
+	if u, err := url.Parse(baseURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	host = invalidParameterCharacters.ReplaceAllString(host, "_")
+	return fmt.Sprintf("/%s/%s/%s", dashboardName, host, name)
+}
+
+// ProviderTokenParameterName returns the name of the SSM parameter which holds the token for the
+// provider
+func ProviderTokenParameterName(dashboardName, provider, baseURL string) string {
+	return ProviderParameterName(dashboardName, baseURL, provider+"_token")
+}
+
+// GetProviderToken reads the provider token from SSM. Tokens for self-hosted instances fall back to
+// the provider wide token when no instance specific token has been stored.
+func GetProviderToken(svc ssmiface.SSMAPI, dashboardName, provider, baseURL string) (string, error) {
+	names := []string{ProviderTokenParameterName(dashboardName, provider, baseURL)}
+	if baseURL != "" {
+		names = append(names, ProviderTokenParameterName(dashboardName, provider, ""))
+	}
+
+	var err error
+	for _, name := range names {
+		input := &ssm.GetParameterInput{
+			Name:           aws.String(name),
+			WithDecryption: aws.Bool(true),
+		}
+
+		var resp *ssm.GetParameterOutput
+		resp, err = svc.GetParameter(input)
+		if err == nil {
+			return *resp.Parameter.Value, nil
+		}
+
+		if aerr, ok := err.(awserr.Error); ok {
+			log.Error(fmt.Sprintf("%v", aerr.Error()))
+			if aerr.Code() == ssm.ErrCodeParameterNotFound {
+				continue
+			}
+		} else {
+			log.Error(fmt.Sprintf("%v", err.Error()))
+		}
+		return "", err
+	}
+	return "", err
+}
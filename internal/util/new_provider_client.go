@@ -0,0 +1,35 @@
+package util
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/xanzy/go-gitlab"
+	"golang.org/x/oauth2"
+)
+
+// NewGithubClient creates a Github client authenticated with the token. An empty baseURL targets
+// github.com, otherwise baseURL is the address of a Github Enterprise Server instance, e.g.
+// https://github.example.com
+func NewGithubClient(ctx context.Context, token, baseURL string) (*github.Client, error) {
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	tc := oauth2.NewClient(ctx, ts)
+
+	if baseURL == "" {
+		return github.NewClient(tc), nil
+	}
+
+	baseURL = strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/api/v3")
+	return github.NewEnterpriseClient(baseURL+"/api/v3/", baseURL+"/api/uploads/", tc)
+}
+
+// NewGitlabClient creates a Gitlab client authenticated with the token. An empty baseURL targets
+// gitlab.com, otherwise baseURL is the address of a self-managed Gitlab instance, e.g.
+// https://gitlab.example.com
+func NewGitlabClient(token, baseURL string) (*gitlab.Client, error) {
+	if baseURL == "" {
+		return gitlab.NewClient(token)
+	}
+	return gitlab.NewClient(token, gitlab.WithBaseURL(baseURL))
+}
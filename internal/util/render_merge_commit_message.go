@@ -0,0 +1,40 @@
+package util
+
+import (
+	"bytes"
+	"text/template"
+)
+
+// DefaultMergeCommitTemplate is the merge commit message used for repositories which have not been
+// onboarded with a template of their own
+const DefaultMergeCommitTemplate = "Merging pull request number {{.Number}}"
+
+// MergeCommit is the data available to a repository's merge commit message template, for example
+// "Release {{.Version}} ({{.Head}} into {{.Base}}, #{{.Number}})"
+type MergeCommit struct {
+	Number  int
+	Version string
+	Repo    string
+	Base    string
+	Head    string
+}
+
+// RenderMergeCommitMessage executes tmpl against c, falling back to DefaultMergeCommitTemplate when
+// tmpl is empty
+func RenderMergeCommitMessage(tmpl string, c MergeCommit) (string, error) {
+	if tmpl == "" {
+		tmpl = DefaultMergeCommitTemplate
+	}
+
+	t, err := template.New("merge_commit").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, c)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
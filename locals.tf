@@ -5,12 +5,62 @@ locals {
   frontend_module_path          = "${path.root}/${local.frontend_module_comprehension.Dir}"
   main_module_name              = split(".terraform/modules/", path.module)[1]
   main_module_path              = "./.terraform/modules/${local.main_module_name}"
+  releases_timeout              = 29
 
-  ssm_parameters = {
+  ssm_parameters = merge(local.ssm_provider_parameters, local.ssm_instance_parameters, local.ssm_github_app_parameters)
+
+  ssm_github_app_parameters = var.github_app_id != "" && var.github_app_private_key != "" ? {
+    github_app_id = {
+      description     = "ID of the Github App used for Github access."
+      parameter_value = var.github_app_id
+    }
+    github_app_private_key = {
+      description     = "Private key of the Github App used for Github access."
+      parameter_value = var.github_app_private_key
+    }
+  } : {}
+
+  ssm_instance_parameters = {
+    for key in keys(var.provider_instance_tokens) : "${key}_token" => {
+      description     = "Token for ${key} access."
+      parameter_value = var.provider_instance_tokens[key]
+    }
+  }
+
+  ssm_provider_token_arns = concat(
+    [
+      aws_ssm_parameter.this["azuredevops_token"].arn,
+      aws_ssm_parameter.this["bitbucket_token"].arn,
+      aws_ssm_parameter.this["gitea_token"].arn,
+      aws_ssm_parameter.this["github_token"].arn,
+      aws_ssm_parameter.this["gitlab_token"].arn,
+    ],
+    # NOTE(SMT): github app and self-hosted instance credentials may be created outside of terraform,
+    # and the lambdas must be able to determine that they do not exist
+    [
+      "${local.ssm_parameter_arn_prefix}/github_app_*",
+      "${local.ssm_parameter_arn_prefix}/*/*",
+    ],
+  )
+  ssm_parameter_arn_prefix = "arn:aws:ssm:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:parameter/${var.name}"
+
+  ssm_provider_parameters = {
     client_pool_secret = {
       description     = "Cognito User Pool client secret."
       parameter_value = aws_cognito_user_pool_client.this.client_secret
     }
+    azuredevops_token = {
+      description     = "Token for Azure DevOps access."
+      parameter_value = var.azuredevops_token == "" ? 42 : var.azuredevops_token
+    }
+    bitbucket_token = {
+      description     = "Token for Bitbucket access."
+      parameter_value = var.bitbucket_token == "" ? 42 : var.bitbucket_token
+    }
+    gitea_token = {
+      description     = "Token for Gitea access."
+      parameter_value = var.gitea_token == "" ? 42 : var.gitea_token
+    }
     github_token = {
       description     = "Token for Github access."
       parameter_value = var.github_token == "" ? 42 : var.github_token
@@ -56,31 +106,91 @@ locals {
     }
 
     releases = {
-      description = "Creates github and gitlab releases for repository specified in the event."
+      description = "Creates azure devops, bitbucket, gitea, github and gitlab releases for repository specified in the event, lists release history, and manages release approvals, schedules, trains and freezes."
       authorizer  = true
+      # NOTE(SMT): artifacts are held in memory while they are attached to the release
+      memory_size = 512
+      timeout     = local.releases_timeout
       environment = {
-        DASHBOARD_NAME    = var.name
-        SLACK_WEBHOOK_URL = aws_ssm_parameter.this["slack_webhook_url"].value
-        TABLE_NAME        = aws_dynamodb_table.this.id
+        ARTIFACT_HOSTS            = join(",", var.release_artifact_hosts)
+        ARTIFACTS_BUCKET          = aws_s3_bucket.this.id
+        CHECKS_TIMEOUT_SECONDS    = var.release_checks_timeout_seconds
+        DASHBOARD_NAME            = var.name
+        LAMBDA_TIMEOUT_SECONDS    = local.releases_timeout
+        RELEASE_REQUEST_TTL_HOURS = var.release_request_ttl_hours
+        REQUIRED_APPROVALS        = var.release_required_approvals
+        SLACK_WEBHOOK_URL         = aws_ssm_parameter.this["slack_webhook_url"].value
+        TABLE_NAME                = aws_dynamodb_table.this.id
       }
       routes = {
-        "/releases/create/github" = "POST"
-        "/releases/create/gitlab" = "POST"
+        "/releases/approve"            = "POST"
+        "/releases/create"             = "POST"
+        "/releases/create/azuredevops" = "POST"
+        "/releases/create/bitbucket"   = "POST"
+        "/releases/create/gitea"       = "POST"
+        "/releases/create/github"      = "POST"
+        "/releases/create/gitlab"      = "POST"
+        "/releases/draft/update"       = "POST"
+        "/releases/freeze/create"      = "POST"
+        "/releases/freeze/delete"      = "POST"
+        "/releases/freeze/list"        = "GET"
+        "/releases/halt"               = "POST"
+        "/releases/list"               = "GET"
+        "/releases/preview"            = "POST"
+        "/releases/promote"            = "POST"
+        "/releases/publish"            = "POST"
+        "/releases/reject"             = "POST"
+        "/releases/request"            = "POST"
+        "/releases/rollback"           = "POST"
+        "/releases/schedule/cancel"    = "POST"
+        "/releases/schedule/create"    = "POST"
+        "/releases/schedule/list"      = "GET"
+        "/releases/train/create"       = "POST"
+        "/releases/train/list"         = "GET"
       }
       iam_statements = {
         dynamodb = {
-          actions   = ["dynamodb:UpdateItem"]
+          actions   = ["dynamodb:DeleteItem", "dynamodb:GetItem", "dynamodb:PutItem", "dynamodb:Query", "dynamodb:UpdateItem"]
           resources = [aws_dynamodb_table.this.arn]
         }
+        s3 = {
+          actions   = ["s3:GetObject"]
+          resources = ["${aws_s3_bucket.this.arn}/artifacts/*"]
+        }
         ssm = {
-          actions   = ["ssm:GetParameter"]
-          resources = [aws_ssm_parameter.this["github_token"].arn, aws_ssm_parameter.this["gitlab_token"].arn]
+          actions   = ["ssm:GetParameter", "ssm:GetParameters"]
+          resources = local.ssm_provider_token_arns
+        }
+        # NOTE(SMT): release trains run one release per invocation of the releases lambda
+        lambda = {
+          actions   = ["lambda:InvokeFunction"]
+          resources = ["arn:aws:lambda:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:function:${var.name}_releases"]
+        }
+      }
+    }
+
+    scheduler = {
+      description = "Starts scheduled releases which are due through the releases lambda."
+      authorizer  = false
+      environment = {
+        RELEASES_FUNCTION_NAME = "${var.name}_releases"
+        TABLE_NAME             = aws_dynamodb_table.this.id
+      }
+      routes = {}
+      iam_statements = {
+        dynamodb = {
+          actions   = ["dynamodb:Query", "dynamodb:UpdateItem"]
+          resources = [aws_dynamodb_table.this.arn]
+        }
+        lambda = {
+          actions   = ["lambda:InvokeFunction"]
+          resources = ["arn:aws:lambda:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:function:${var.name}_releases"]
         }
       }
     }
 
     repositories = {
-      description = "Writes github and gitlab repository details to DynamoDB."
+      description = "Writes azure devops, bitbucket, gitea, github and gitlab repository details to DynamoDB."
       authorizer  = true
       environment = {
         DASHBOARD_NAME = var.name
@@ -102,8 +212,8 @@ locals {
           resources = [aws_dynamodb_table.this.arn]
         }
         ssm = {
-          actions   = ["ssm:GetParameter"]
-          resources = [aws_ssm_parameter.this["github_token"].arn, aws_ssm_parameter.this["gitlab_token"].arn]
+          actions   = ["ssm:GetParameter", "ssm:GetParameters"]
+          resources = local.ssm_provider_token_arns
         }
       }
     }
@@ -148,6 +258,7 @@ locals {
         authorizer = lambda_value.authorizer
         method     = method
         route      = route
+        timeout    = lookup(lambda_value, "timeout", 10)
       }
     ]
   ])
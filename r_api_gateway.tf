@@ -76,7 +76,7 @@ resource "aws_apigatewayv2_integration" "this" {
   connection_type        = "INTERNET"
   integration_method     = each.value.method
   integration_uri        = aws_lambda_function.this[each.value.lambda_key].arn
-  timeout_milliseconds   = 10500
+  timeout_milliseconds   = min(each.value.timeout * 1000 + 500, 30000)
   payload_format_version = "2.0"
 }
 
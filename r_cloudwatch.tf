@@ -13,3 +13,15 @@ resource "aws_cloudwatch_log_group" "api_gateway" {
   retention_in_days = 7
   tags              = var.tags
 }
+
+resource "aws_cloudwatch_event_rule" "scheduler" {
+  name                = "${var.name}_scheduler"
+  description         = "Starts scheduled releases which are due and fails stale release trains."
+  schedule_expression = var.scheduler_schedule_expression
+  tags                = var.tags
+}
+
+resource "aws_cloudwatch_event_target" "scheduler" {
+  rule = aws_cloudwatch_event_rule.scheduler.name
+  arn  = aws_lambda_function.this["scheduler"].arn
+}
@@ -33,6 +33,12 @@ resource "aws_cognito_user_pool" "this" {
   tags = var.tags
 }
 
+resource "aws_cognito_user_group" "admin" {
+  name         = "admin"
+  user_pool_id = aws_cognito_user_pool.this.id
+  description  = "Users who may manage release freezes and override them for hotfixes."
+}
+
 resource "aws_cognito_user_pool_client" "this" {
   name                                 = var.name
   user_pool_id                         = aws_cognito_user_pool.this.id
@@ -14,6 +14,11 @@ resource "aws_dynamodb_table" "this" {
     type = "S"
   }
 
+  ttl {
+    attribute_name = "ExpiresAt"
+    enabled        = true
+  }
+
   tags = var.tags
 }
 
@@ -10,7 +10,8 @@ resource "aws_lambda_function" "this" {
   publish          = false
   source_code_hash = data.archive_file.this[each.key].output_base64sha256
   runtime          = "go1.x"
-  timeout          = "10"
+  timeout          = lookup(each.value, "timeout", 10)
+  memory_size      = lookup(each.value, "memory_size", 128)
   tags             = var.tags
 
   environment {
@@ -27,3 +28,11 @@ resource "aws_lambda_permission" "this" {
   principal     = "apigateway.amazonaws.com"
   source_arn    = "${aws_apigatewayv2_api.this.execution_arn}/*/*${each.value.route}"
 }
+
+resource "aws_lambda_permission" "scheduler" {
+  statement_id  = "AllowEventBridgeInvoke"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.this["scheduler"].function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.scheduler.arn
+}
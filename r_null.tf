@@ -90,11 +90,12 @@ resource "null_resource" "lambda_test" {
 }
 
 resource "null_resource" "create_admin_user" {
-  count = var.admin_user_email != "" && !var.enable_delete_admin_user ? 1 : 0
+  count      = var.admin_user_email != "" && !var.enable_delete_admin_user ? 1 : 0
+  depends_on = [aws_cognito_user_group.admin]
 
   provisioner "local-exec" {
     interpreter = ["/bin/bash", "-c"]
-    command     = "AWS_DEFAULT_PROFILE=${local.aws_profile} aws --region ${data.aws_region.current.name} cognito-idp admin-create-user --user-pool-id ${aws_cognito_user_pool.this.id} --username ${var.admin_user_email} --user-attributes Name=email,Value=${var.admin_user_email}"
+    command     = "AWS_DEFAULT_PROFILE=${local.aws_profile} aws --region ${data.aws_region.current.name} cognito-idp admin-create-user --user-pool-id ${aws_cognito_user_pool.this.id} --username ${var.admin_user_email} --user-attributes Name=email,Value=${var.admin_user_email} && AWS_DEFAULT_PROFILE=${local.aws_profile} aws --region ${data.aws_region.current.name} cognito-idp admin-add-user-to-group --user-pool-id ${aws_cognito_user_pool.this.id} --username ${var.admin_user_email} --group-name ${aws_cognito_user_group.admin.name}"
   }
 }
 
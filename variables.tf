@@ -57,6 +57,40 @@ variable "enable_delete_admin_user" {
   default     = false
 }
 
+variable "azuredevops_token" {
+  type        = string
+  description = "Personal access token for Azure DevOps with Code (Read & Write) scope."
+  default     = "42"
+}
+
+variable "bitbucket_token" {
+  type        = string
+  description = "Token for Bitbucket. Either an access token, or `username:app_password`."
+  default     = "42"
+}
+
+variable "gitea_token" {
+  type        = string
+  description = "Token for a self-hosted Gitea or Forgejo instance."
+  default     = "42"
+}
+
+variable "github_app_id" {
+  type        = string
+  description = <<-DESC
+  ID of a Github App installed on the owners of onboarded repositories. When set along with
+  `github_app_private_key`, releases and pull requests are created by the app's bot account using
+  short-lived installation tokens instead of `github_token`.
+  DESC
+  default     = ""
+}
+
+variable "github_app_private_key" {
+  type        = string
+  description = "PEM encoded private key generated for the Github App specified by `github_app_id`."
+  default     = ""
+}
+
 variable "github_token" {
   type        = string
   description = "Token for Github."
@@ -69,6 +103,58 @@ variable "gitlab_token" {
   default     = "42"
 }
 
+variable "provider_instance_tokens" {
+  type        = map(string)
+  description = <<-DESC
+  Tokens for self-hosted provider instances such as Github Enterprise Server or self-managed
+  Gitlab, keyed by `<host>/<provider>`. For example, `github.example.com/github` is used by
+  repositories onboarded with a `base_url` of `https://github.example.com`.
+
+  Repositories on an instance without a token here use the provider's token instead.
+  DESC
+  default     = {}
+}
+
+variable "release_artifact_hosts" {
+  type        = list(string)
+  description = <<-DESC
+  Hosts which release artifacts passed as URLs may be downloaded from over https, e.g.
+  `github.com` or `ci.example.com:8443`. Artifacts are never downloaded from private addresses.
+  DESC
+  default     = []
+}
+
+variable "release_checks_timeout_seconds" {
+  type        = number
+  description = <<-DESC
+  Number of seconds a release waits for pending CI checks on its pull request before refusing to
+  merge it. Along with the 15 second artifact download timeout, must be less than the 29 second
+  releases lambda timeout.
+  DESC
+  default     = 5
+}
+
+variable "release_required_approvals" {
+  type        = number
+  description = <<-DESC
+  Number of users other than the requester who must approve a release through `/releases/approve`.
+  When greater than 0, releases can no longer be created directly through `/releases/create`.
+  DESC
+  default     = 0
+}
+
+variable "release_request_ttl_hours" {
+  type        = number
+  description = "Number of hours a release requested through `/releases/request` can be approved for."
+  default     = 24
+}
+
+variable "scheduler_schedule_expression" {
+  type        = string
+  description = "How often scheduled releases are checked for releases which are due."
+  default     = "rate(5 minutes)"
+}
+
 variable "slack_webhook_url" {
   type        = string
   description = "URL to send slack message payloads to."